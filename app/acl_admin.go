@@ -0,0 +1,142 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/authorization"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+)
+
+// aclRequest is the body of the grant and revoke requests
+type aclRequest struct {
+	Topics []string `json:"topics"`
+}
+
+// ACLResponse lists the topic filters granted to a user by all its ACLs
+type ACLResponse struct {
+	Username string   `json:"username"`
+	Topics   []string `json:"topics"`
+}
+
+// ListACLHandler is the handler responsible for listing the topic filters
+// granted to a user
+func ListACLHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "ListACL")
+		username, err := parseUsernameParam(c)
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting username parameter.")
+		}
+		return aclResponse(c, app, username)
+	}
+}
+
+// GrantACLHandler is the handler responsible for granting topic filters to a user
+func GrantACLHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "GrantACL")
+		username, err := parseUsernameParam(c)
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting username parameter.")
+		}
+		topics, err := parseACLTopics(c)
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting topics parameter.")
+		}
+
+		if err := app.ACLStore.GrantTopics(c, GameID(c), username, topics); err != nil {
+			return err
+		}
+		logger.Logger.Infof("Operator %s granted %v to %s.", CurrentOperator(c).Name, topics, username)
+		forgetDecisions(app, username)
+		return aclResponse(c, app, username)
+	}
+}
+
+// RevokeACLHandler is the handler responsible for revoking topic filters from a user
+func RevokeACLHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "RevokeACL")
+		username, err := parseUsernameParam(c)
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting username parameter.")
+		}
+		topics, err := parseACLTopics(c)
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting topics parameter.")
+		}
+
+		if err := app.ACLStore.RevokeTopics(c, GameID(c), username, topics); err != nil {
+			return err
+		}
+		logger.Logger.Infof("Operator %s revoked %v from %s.", CurrentOperator(c).Name, topics, username)
+		forgetDecisions(app, username)
+		return aclResponse(c, app, username)
+	}
+}
+
+func parseUsernameParam(c echo.Context) (string, error) {
+	username, err := url.PathUnescape(c.Param("username"))
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return "", errors.New("the username is empty")
+	}
+	return username, nil
+}
+
+// parseACLTopics returns the topic filters of the body of the grant and
+// revoke requests, rejecting the invalid ones
+func parseACLTopics(c echo.Context) ([]string, error) {
+	var body aclRequest
+	if err := c.Bind(&body); err != nil {
+		return nil, err
+	}
+	if len(body.Topics) == 0 {
+		return nil, errors.New("no topics")
+	}
+	for _, topic := range body.Topics {
+		if err := models.ValidateTopicFilter(topic); err != nil {
+			return nil, err
+		}
+	}
+	return body.Topics, nil
+}
+
+func aclResponse(c echo.Context, app *App, username string) error {
+	acls, err := app.ACLStore.FindACLs(c, GameID(c), username)
+	if err != nil {
+		return err
+	}
+
+	granted := make(map[string]bool)
+	topics := make([]string, 0)
+	for _, acl := range acls {
+		for _, topic := range acl.Pubsub {
+			if !granted[topic] {
+				granted[topic] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+	sort.Strings(topics)
+	return c.JSON(http.StatusOK, ACLResponse{Username: username, Topics: topics})
+}
+
+// forgetDecisions drops the authorization decisions remembered for the user
+func forgetDecisions(app *App, username string) {
+	if forgetter, ok := app.Authorizer.(authorization.Forgetter); ok {
+		forgetter.Forget(username)
+	}
+}
@@ -0,0 +1,86 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/mqtt-history/app"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestACLAdminHandlers(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("ACLAdmin", func() {
+		a := GetDefaultTestApp()
+
+		g.Describe("ACL admin handlers", func() {
+			g.It("It should return 401 without an API key and 403 without the acl:admin role", func() {
+				status, _ := Get(a, "/admin/v2/acl/test:test", t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+
+				status, _ = PostJSONAsOperator(a, "/admin/v2/acl/test:test/grant", `{"topics": ["chat/#"]}`, SupportReadAPIKey, t)
+				g.Assert(status).Equal(http.StatusForbidden)
+			})
+
+			g.It("It should grant, list and revoke the topics of a user", func() {
+				userID := fmt.Sprintf("test:%s", uuid.NewV4().String())
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				clan := fmt.Sprintf("clan/test_%s/#", testID)
+				room := fmt.Sprintf("clan/test_%s/room", testID)
+				historyPath := fmt.Sprintf("/v2/history/%s?userid=%s", room, userID)
+
+				status, _ := Get(a, historyPath, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+
+				body := fmt.Sprintf(`{"topics": ["%s", "chat/%s"]}`, clan, testID)
+				status, response := PostJSONAsOperator(a, fmt.Sprintf("/admin/v2/acl/%s/grant", userID), body, ACLAdminAPIKey, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var acl app.ACLResponse
+				err := json.Unmarshal([]byte(response), &acl)
+				Expect(err).To(BeNil())
+				g.Assert(acl.Username).Equal(userID)
+				g.Assert(acl.Topics).Equal([]string{fmt.Sprintf("chat/%s", testID), clan})
+
+				status, _ = Get(a, historyPath, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				body = fmt.Sprintf(`{"topics": ["%s"]}`, clan)
+				status, _ = PostJSONAsOperator(a, fmt.Sprintf("/admin/v2/acl/%s/revoke", userID), body, ACLAdminAPIKey, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				status, response = GetAsOperator(a, fmt.Sprintf("/admin/v2/acl/%s", userID), ACLAdminAPIKey, t)
+				g.Assert(status).Equal(http.StatusOK)
+				err = json.Unmarshal([]byte(response), &acl)
+				Expect(err).To(BeNil())
+				g.Assert(acl.Topics).Equal([]string{fmt.Sprintf("chat/%s", testID)})
+
+				status, _ = Get(a, historyPath, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+			})
+
+			g.It("It should return 422 if a topic filter is invalid", func() {
+				for _, body := range []string{`{"topics": ["chat/#/a"]}`, `{"topics": ["chat/a+"]}`, `{"topics": []}`, `{}`} {
+					status, _ := PostJSONAsOperator(a, "/admin/v2/acl/test:test/grant", body, ACLAdminAPIKey, t)
+					g.Assert(status).Equal(http.StatusUnprocessableEntity)
+				}
+			})
+		})
+	})
+}
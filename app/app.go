@@ -8,20 +8,28 @@
 package app
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"os"
 	"strings"
+	"time"
 
 	newrelic "github.com/newrelic/go-agent"
 
 	"github.com/getsentry/raven-go"
+	labstack "github.com/labstack/echo"
 	"github.com/labstack/echo/engine"
 	"github.com/labstack/echo/engine/standard"
 	"github.com/spf13/viper"
 	"github.com/topfreegames/extensions/echo"
 
+	"github.com/topfreegames/mqtt-history/audit"
+	"github.com/topfreegames/mqtt-history/authorization"
 	"github.com/topfreegames/mqtt-history/logger"
 	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/ratelimit"
+	"github.com/topfreegames/mqtt-history/storage"
 	"github.com/uber-go/zap"
 
 	"github.com/uber/jaeger-client-go/config"
@@ -40,6 +48,22 @@ type App struct {
 	NumberOfDaysToSearch int
 	Defaults             *models.Defaults
 	Bucket               *models.Bucket
+	MessageStore         storage.MessageStore
+	ACLStore             storage.ACLStore
+	ReadMarkerStore      storage.ReadMarkerStore
+	MuteStore            storage.MuteStore
+	Authentication       *AuthenticationMiddleware
+	OperatorStore        storage.OperatorStore
+	AuditStore           storage.AuditStore
+	ModerationStore      storage.ModerationStore
+	Auditor              *audit.Auditor
+	Authorizer           authorization.Authorizer
+	// TLS is nil when the public API is served over plain HTTP
+	TLS *TLSReloader
+	// RateLimiter is nil when the rate limits are disabled
+	RateLimiter ratelimit.Limiter
+	// Tenant is nil when multi-tenancy is disabled
+	Tenant *TenantMiddleware
 }
 
 // GetApp creates an app given the parameters
@@ -69,25 +93,203 @@ func (app *App) Configure() {
 	app.configureJaeger()
 
 	app.configureStorage()
+	app.configureAuthentication()
+	app.configureTenant()
+	app.configureTLS()
+	app.configureRateLimit()
 	app.configureApplication()
 }
 
+func (app *App) configureTenant() {
+	if !app.Config.GetBool("tenant.enabled") {
+		return
+	}
+
+	middleware, err := NewTenantMiddleware(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize multi-tenancy.", err)
+		panic(fmt.Sprintf("Could not initialize multi-tenancy, err: %s", err))
+	}
+	app.Tenant = middleware
+}
+
+// resolveTenant returns the middleware setting the game ID of the player
+// requests, a no-op when multi-tenancy is disabled
+func (app *App) resolveTenant(next labstack.HandlerFunc) labstack.HandlerFunc {
+	if app.Tenant == nil {
+		return next
+	}
+	return app.Tenant.Serve(next)
+}
+
+// resolveOperatorTenant returns the middleware setting the game ID of the
+// operator requests, a no-op when multi-tenancy is disabled
+func (app *App) resolveOperatorTenant(next labstack.HandlerFunc) labstack.HandlerFunc {
+	if app.Tenant == nil {
+		return next
+	}
+	return app.Tenant.ServeOperators(next)
+}
+
+func (app *App) configureRateLimit() {
+	if !app.Config.GetBool("rateLimit.enabled") {
+		return
+	}
+
+	limiter, err := ratelimit.NewLimiter(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the rate limiter.", err)
+		panic(fmt.Sprintf("Could not initialize the rate limiter, err: %s", err))
+	}
+	app.RateLimiter = limiter
+}
+
+// rateLimit returns the rate limit middleware of the route, configured in
+// rateLimit.routes.<route>, or else in rateLimit.default
+func (app *App) rateLimit(route string) labstack.MiddlewareFunc {
+	noLimit := func(next labstack.HandlerFunc) labstack.HandlerFunc { return next }
+	if app.RateLimiter == nil {
+		return noLimit
+	}
+
+	key := fmt.Sprintf("rateLimit.routes.%s", route)
+	if !app.Config.IsSet(key) {
+		key = "rateLimit.default"
+	}
+	var limit RouteRateLimit
+	err := app.Config.UnmarshalKey(key, &limit)
+	if err == nil {
+		switch limit.KeyBy {
+		case "":
+			limit.KeyBy = RateLimitByUser
+		case RateLimitByUser, RateLimitByIP, RateLimitByGame:
+		default:
+			err = fmt.Errorf("unknown rate limit key: %s", limit.KeyBy)
+		}
+	}
+	if err != nil {
+		logger.Logger.Error("Failed to read the rate limit of the route.", err)
+		panic(fmt.Sprintf("Could not read the rate limit of route %s, err: %s", route, err))
+	}
+	if limit.Rate <= 0 && limit.Burst <= 0 {
+		return noLimit
+	}
+
+	middleware := &RateLimitMiddleware{
+		Limiter:           app.RateLimiter,
+		Route:             route,
+		Limit:             limit,
+		TrustForwardedFor: app.Config.GetBool("rateLimit.trustForwardedFor"),
+	}
+	return middleware.Serve
+}
+
+func (app *App) configureTLS() {
+	if !app.Config.GetBool("tls.enabled") {
+		return
+	}
+
+	reloader, err := NewTLSReloader(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to load the TLS certificates.", err)
+		panic(fmt.Sprintf("Could not load the TLS certificates, err: %s", err))
+	}
+	app.TLS = reloader
+}
+
+func (app *App) configureAuthentication() {
+	authentication, err := NewAuthenticationMiddleware(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to load the JWT keys.", err)
+		panic(fmt.Sprintf("Could not load the JWT keys, err: %s", err))
+	}
+	if authentication.Keys.Empty() && !authentication.AllowLegacyUserID {
+		logger.Logger.Warning("No JWT key configured and auth.legacyUserIdParam disabled, every player request will be rejected.")
+	}
+	app.Authentication = authentication
+
+	operatorStore, err := storage.NewOperatorStore(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the operator store.", err)
+		panic(fmt.Sprintf("Could not initialize the operator store, err: %s", err))
+	}
+	app.OperatorStore = operatorStore
+}
+
 func (app *App) configureBucket() {
 	app.Bucket = models.NewBucket(app.Config)
 }
 
 func (app *App) configureStorage() {
-
 	app.Defaults.LimitOfMessages = app.Config.GetInt64("mongo.messages.limit")
-	return
-
 	app.configureBucket()
+	app.configureStores()
+}
+
+func (app *App) configureStores() {
+	messageStore, err := storage.NewMessageStore(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the message store.", err)
+		panic(fmt.Sprintf("Could not initialize the message store, err: %s", err))
+	}
+	app.MessageStore = messageStore
+
+	aclStore, err := storage.NewACLStore(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the ACL store.", err)
+		panic(fmt.Sprintf("Could not initialize the ACL store, err: %s", err))
+	}
+	app.ACLStore = aclStore
+
+	readMarkerStore, err := storage.NewReadMarkerStore(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the read marker store.", err)
+		panic(fmt.Sprintf("Could not initialize the read marker store, err: %s", err))
+	}
+	app.ReadMarkerStore = readMarkerStore
+
+	muteStore, err := storage.NewMuteStore(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the mute store.", err)
+		panic(fmt.Sprintf("Could not initialize the mute store, err: %s", err))
+	}
+	app.MuteStore = muteStore
+
+	auditStore, err := storage.NewAuditStore(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the audit store.", err)
+		panic(fmt.Sprintf("Could not initialize the audit store, err: %s", err))
+	}
+	app.AuditStore = auditStore
+
+	moderationStore, err := storage.NewModerationStore(app.Config)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the moderation store.", err)
+		panic(fmt.Sprintf("Could not initialize the moderation store, err: %s", err))
+	}
+	app.ModerationStore = moderationStore
+
+	auditor, err := audit.NewAuditor(app.Config, auditStore)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the audit sinks.", err)
+		panic(fmt.Sprintf("Could not initialize the audit sinks, err: %s", err))
+	}
+	app.Auditor = auditor
+
+	authorizer, err := authorization.NewAuthorizer(app.Config, aclStore)
+	if err != nil {
+		logger.Logger.Error("Failed to initialize the authorizers.", err)
+		panic(fmt.Sprintf("Could not initialize the authorizers, err: %s", err))
+	}
+	app.Authorizer = authorizer
 }
 
 func (app *App) configureDefaults() {
 	app.Defaults = &models.Defaults{
 		LimitOfMessages:         app.Config.GetInt64("mongo.messages.limit"),
+		ForwardLimitOfMessages:  app.Config.GetInt64("mongo.messages.forwardLimit"),
 		MongoMessagesCollection: app.Config.GetString("mongo.messages.collection"),
+		LimitOfWildcardTopics:   app.Config.GetInt64("mongo.messages.wildcardTopicsLimit"),
 	}
 }
 
@@ -130,6 +332,49 @@ func (app *App) configureJaeger() {
 func (app *App) setConfigurationDefaults() {
 	app.Config.SetDefault("healthcheck.workingText", "WORKING")
 	app.Config.SetDefault("mongo.database", "mqtt")
+	app.Config.SetDefault("storage.type", "mongo")
+	app.Config.SetDefault("mongo.messages.forwardLimit", 100)
+	app.Config.SetDefault("mongo.messages.wildcardTopicsLimit", 100)
+	app.Config.SetDefault("mongo.messages.wildcardTopicsMaxPages", 10)
+	app.Config.SetDefault("mongo.messages.wildcardTopicsLookback", 30*24*60*60)
+	app.Config.SetDefault("mongo.readMarkers.collection", "read_markers")
+	app.Config.SetDefault("mongo.mutes.collection", "muted_players")
+	app.Config.SetDefault("mutes.maxPlayers", 1000)
+	app.Config.SetDefault("unread.maxTopics", 100)
+	app.Config.SetDefault("unread.concurrency", 8)
+	app.Config.SetDefault("auth.legacyUserIdParam", false)
+	app.Config.SetDefault("auth.jwt.userIdClaim", "sub")
+	app.Config.SetDefault("auth.jwt.requireExp", true)
+	app.Config.SetDefault("operatorAuth.source", "config")
+	app.Config.SetDefault("mongo.operators.collection", "operators")
+	app.Config.SetDefault("mongo.audit.collection", "audit_log")
+	app.Config.SetDefault("audit.sinks", []string{"storage"})
+	app.Config.SetDefault("mongo.moderation.collection", "moderation_history")
+	app.Config.SetDefault("mongo.moderation.claimsCollection", "moderation_claims")
+	app.Config.SetDefault("moderation.maxMessages", 100)
+	app.Config.SetDefault("moderation.leaseDuration", 300)
+	app.Config.SetDefault("gdpr.export.progressInterval", 1000)
+	app.Config.SetDefault("httpAuth.batch.size", 100)
+	app.Config.SetDefault("httpAuth.concurrency", 8)
+	app.Config.SetDefault("httpAuth.retries", 2)
+	app.Config.SetDefault("httpAuth.retryBackoff", 50)
+	app.Config.SetDefault("httpAuth.circuitBreaker.failures", 5)
+	app.Config.SetDefault("httpAuth.circuitBreaker.openFor", 30)
+	app.Config.SetDefault("httpAuth.outage.answer", "error")
+	app.Config.SetDefault("httpAuth.outage.cachedAllowTTL", 3600)
+	app.Config.SetDefault("httpAuth.outage.cacheSize", 10000)
+	app.Config.SetDefault("authorization.cache.size", 10000)
+	app.Config.SetDefault("authorization.cache.allowTTL", 60)
+	app.Config.SetDefault("authorization.cache.denyTTL", 10)
+	app.Config.SetDefault("tenant.required", true)
+	app.Config.SetDefault("tenant.sources", []string{TenantFromClaim, TenantFromHost})
+	app.Config.SetDefault("tenant.operatorSources", []string{TenantFromHeader, TenantFromHost})
+	app.Config.SetDefault("tenant.header", GameIDHeader)
+	app.Config.SetDefault("tenant.claim", "game_id")
+	app.Config.SetDefault("rateLimit.backend", "memory")
+	app.Config.SetDefault("rateLimit.maxKeys", 100000)
+	app.Config.SetDefault("tls.minVersion", "1.2")
+	app.Config.SetDefault("tls.reloadInterval", 60)
 	app.Config.SetDefault("extensions.prometheus.enabled", true)
 	app.Config.SetDefault("extensions.prometheus.port", 9090)
 }
@@ -168,18 +413,65 @@ func (app *App) configureApplication() {
 	)).Serve)
 	a.Use(NewSentryMiddleware().Serve)
 	a.Use(VersionMiddleware)
+	a.Use(RequestIDMiddleware)
 	a.Use(NewRecoveryMiddleware(app.OnErrorHandler).Serve)
 	if app.Config.GetBool("extensions.prometheus.enabled") {
 		a.Use(NewResponseTimeMetricsMiddleware(NewPrometheus()).Serve)
 	}
 	// Routes
 	a.Get("/healthcheck", HealthCheckHandler(app))
-	a.Get("/history/*", HistoryHandler(app))
-	a.Get("/histories/*", HistoriesHandler(app))
-	a.Get("/v2/history/*", HistoryV2Handler(app))
-	a.Get("/v2/histories/*", HistoriesV2Handler(app))
+	// the player routes
+	authenticate := app.Authentication.Serve
+	a.Get("/history/*", HistoryHandler(app), authenticate, app.resolveTenant, app.rateLimit("History"))
+	a.Get("/histories/*", HistoriesHandler(app), authenticate, app.resolveTenant, app.rateLimit("Histories"))
+	a.Get("/v2/history/*", HistoryV2Handler(app), authenticate, app.resolveTenant, app.rateLimit("HistoryV2"))
+	a.Get("/v2/histories/*", HistoriesV2Handler(app), authenticate, app.resolveTenant, app.rateLimit("HistoriesV2"))
+	a.Get("/v2/unread/*", UnreadV2Handler(app), authenticate, app.resolveTenant, app.rateLimit("UnreadV2"))
+	a.Get("/v2/marker/*", GetReadMarkerV2Handler(app), authenticate, app.resolveTenant, app.rateLimit("GetReadMarkerV2"))
+	a.Put("/v2/marker/*", SetReadMarkerV2Handler(app), authenticate, app.resolveTenant, app.rateLimit("SetReadMarkerV2"))
+	a.Get("/v2/markers/*", ReadMarkersV2Handler(app), authenticate, app.resolveTenant, app.rateLimit("ReadMarkersV2"))
+	a.Get("/v2/mutes", ListMutesV2Handler(app), authenticate, app.resolveTenant, app.rateLimit("ListMutesV2"))
+	a.Put("/v2/mutes/:playerId", MuteV2Handler(app), authenticate, app.resolveTenant, app.rateLimit("MuteV2"))
+	a.Delete("/v2/mutes/:playerId", UnmuteV2Handler(app), authenticate, app.resolveTenant, app.rateLimit("UnmuteV2"))
 	a.Get("/:other", NotFoundHandler(app))
-	a.Get("/ps/v2/history*", HistoriesV2PSHandler(app))
+	// the player support routes
+	a.Get("/ps/v2/history*", HistoriesV2PSHandler(app), app.gameOperatorMiddlewares("HistoriesV2PlayerSupport", models.RoleSupportRead)...)
+	a.Get("/ps/v2/audit", AuditPSHandler(app), app.operatorMiddlewares("AuditPlayerSupport", models.RoleSupportAudit)...)
+	// the moderation routes
+	a.Post("/moderation/v2/block", BlockMessagesHandler(app), app.gameOperatorMiddlewares("BlockMessages", models.RoleModerationWrite)...)
+	a.Post("/moderation/v2/unblock", UnblockMessagesHandler(app), app.gameOperatorMiddlewares("UnblockMessages", models.RoleModerationWrite)...)
+	a.Get("/moderation/v2/queue", ModerationQueueHandler(app), app.gameOperatorMiddlewares("ModerationQueue", models.RoleModerationWrite)...)
+	a.Post("/moderation/v2/queue/claim", ClaimModerationQueueHandler(app), app.gameOperatorMiddlewares("ClaimModerationQueue", models.RoleModerationWrite)...)
+	a.Post("/moderation/v2/queue/release", ReleaseModerationQueueHandler(app), app.operatorMiddlewares("ReleaseModerationQueue", models.RoleModerationWrite)...)
+	a.Get("/moderation/v2/history/:id", ModerationHistoryHandler(app), app.gameOperatorMiddlewares("ModerationHistory", models.RoleModerationWrite)...)
+	// the GDPR routes
+	a.Post("/gdpr/v2/erase", ErasePlayerHandler(app), app.gameOperatorMiddlewares("ErasePlayer", models.RoleGDPRErase)...)
+	a.Get("/gdpr/v2/export", ExportPlayerHandler(app), app.gameOperatorMiddlewares("ExportPlayer", models.RoleGDPRExport)...)
+	// the admin routes
+	a.Get("/admin/v2/acl/:username", ListACLHandler(app), app.gameOperatorMiddlewares("ListACL", models.RoleACLAdmin)...)
+	a.Post("/admin/v2/acl/:username/grant", GrantACLHandler(app), app.gameOperatorMiddlewares("GrantACL", models.RoleACLAdmin)...)
+	a.Post("/admin/v2/acl/:username/revoke", RevokeACLHandler(app), app.gameOperatorMiddlewares("RevokeACL", models.RoleACLAdmin)...)
+}
+
+// operatorMiddlewares returns the middlewares of the operator route
+// requiring the roles, along with a client certificate if configured
+func (app *App) operatorMiddlewares(route string, roles ...string) []labstack.MiddlewareFunc {
+	middlewares := make([]labstack.MiddlewareFunc, 0, 3)
+	if app.TLS != nil && app.TLS.ClientAuth == ClientAuthOperators {
+		middlewares = append(middlewares, ClientCertificateMiddleware)
+	}
+	return append(middlewares,
+		NewOperatorAuthenticationMiddleware(app.OperatorStore, roles...).Serve,
+		app.rateLimit(route),
+	)
+}
+
+// gameOperatorMiddlewares returns the middlewares of the operator route
+// scoped to the game of the request, resolved before the rate limit
+func (app *App) gameOperatorMiddlewares(route string, roles ...string) []labstack.MiddlewareFunc {
+	middlewares := app.operatorMiddlewares(route, roles...)
+	last := len(middlewares) - 1
+	return append(middlewares[:last:last], app.resolveOperatorTenant, middlewares[last])
 }
 
 // OnErrorHandler handles application panics
@@ -206,5 +498,14 @@ func (app *App) Start() {
 	if app.Config.GetBool("extensions.prometheus.enabled") {
 		startMetricsServer(app.Config.GetInt("extensions.prometheus.port"))
 	}
+	if app.TLS != nil {
+		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", app.Host, app.Port))
+		if err != nil {
+			logger.Logger.Error("Failed to listen.", err)
+			panic(fmt.Sprintf("Could not listen, err: %s", err))
+		}
+		app.Engine = standard.WithConfig(engine.Config{Listener: tls.NewListener(listener, app.TLS.Config())})
+		go app.TLS.Watch(time.Duration(app.Config.GetInt("tls.reloadInterval")) * time.Second)
+	}
 	app.API.Run(app.Engine)
 }
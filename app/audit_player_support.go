@@ -0,0 +1,50 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+// AuditPSHandler is the handler responsible for listing the audit log of
+// the player support reads, newest first
+func AuditPSHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "AuditPlayerSupport")
+		filter := storage.AuditFilter{
+			Operator: c.QueryParam("operator"),
+			PlayerID: c.QueryParam("playerId"),
+			Topic:    c.QueryParam("topic"),
+		}
+
+		filter.Limit, _ = strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+		if filter.Limit <= 0 {
+			filter.Limit = app.Defaults.LimitOfMessages
+		}
+
+		var err error
+		if initialDate := c.QueryParam("initialDate"); initialDate != "" {
+			filter.From, err = transformDate(initialDate, true)
+			if err != nil {
+				logger.Logger.Warningf("Error: %s", err.Error())
+				return c.JSON(http.StatusUnprocessableEntity, "Error getting initialDate parameter.")
+			}
+		}
+		if finalDate := c.QueryParam("finalDate"); finalDate != "" {
+			filter.To, err = transformDate(finalDate, false)
+			if err != nil {
+				logger.Logger.Warningf("Error: %s", err.Error())
+				return c.JSON(http.StatusUnprocessableEntity, "Error getting finalDate parameter.")
+			}
+		}
+
+		entries, err := app.AuditStore.FindAuditEntries(c, filter)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, entries)
+	}
+}
@@ -0,0 +1,96 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/mqtt-history/models"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestAuditPSHandler(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("AuditPS", func() {
+		ctx := context.Background()
+		a := GetDefaultTestApp()
+
+		g.Describe("AuditPS Handler", func() {
+
+			g.It("It should return 403 without the support:audit role", func() {
+				status, _ := GetAsOperator(a, "/ps/v2/audit", SupportReadAPIKey, t)
+				g.Assert(status).Equal(http.StatusForbidden)
+			})
+
+			g.It("It should record and list every player support read", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test_%s", testID)
+				playerID := "test"
+				requestID := uuid.NewV4().String()
+				finalDate := strings.Split(time.Now().AddDate(0, 0, 1).UTC().String(), " ")[0]
+
+				err := InsertMongoMessagesWithParameters(ctx, []string{topic}, false)
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/ps/v2/history?topic=%s&playerId=%s&initialDate=2022-01-01&finalDate=%s", topic, playerID, finalDate)
+				headers := map[string]string{"X-API-Key": SupportReadAPIKey, "X-Request-ID": requestID}
+				status, _, responseHeaders := GetWithHeaders(a, path, headers, t)
+				g.Assert(status).Equal(http.StatusOK)
+				g.Assert(responseHeaders.Get("X-Request-ID")).Equal(requestID)
+
+				path = fmt.Sprintf("/ps/v2/history?topic=%s&initialDate=2022-01-01&finalDate=%s&isBlocked=true", topic, finalDate)
+				status, _ = GetAsOperator(a, path, SupportBlockedAPIKey, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				path = fmt.Sprintf("/ps/v2/audit?topic=%s", topic)
+				status, body := GetAsOperator(a, path, SupportAuditAPIKey, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var entries []models.AuditEntry
+				err = json.Unmarshal([]byte(body), &entries)
+				Expect(err).To(BeNil())
+				g.Assert(len(entries)).Equal(2)
+				g.Assert(entries[0].Operator).Equal("support-moderator")
+				g.Assert(entries[0].IsBlocked).IsTrue()
+				g.Assert(entries[0].ResultCount).Equal(0)
+				g.Assert(entries[1].Operator).Equal("support-reader")
+				g.Assert(entries[1].Action).Equal("ps_history")
+				g.Assert(entries[1].PlayerId).Equal(playerID)
+				g.Assert(entries[1].ResultCount).Equal(1)
+				g.Assert(entries[1].RequestId).Equal(requestID)
+				g.Assert(entries[1].To > entries[1].From).IsTrue()
+
+				path = fmt.Sprintf("/ps/v2/audit?topic=%s&operator=support-reader&playerId=%s&initialDate=2022-01-01", topic, playerID)
+				status, body = GetAsOperator(a, path, SupportAuditAPIKey, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				err = json.Unmarshal([]byte(body), &entries)
+				Expect(err).To(BeNil())
+				g.Assert(len(entries)).Equal(1)
+				g.Assert(entries[0].RequestId).Equal(requestID)
+			})
+
+			g.It("It should return 422 if the dates are invalid", func() {
+				status, _ := GetAsOperator(a, "/ps/v2/audit?initialDate=yesterday", SupportAuditAPIKey, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			})
+		})
+	})
+}
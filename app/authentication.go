@@ -0,0 +1,123 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/logger"
+)
+
+const (
+	userIDContextKey = "userID"
+	claimsContextKey = "claims"
+)
+
+// AuthenticationMiddleware authenticates the players by the JWT sent as a
+// bearer token, or by the userid query parameter in legacy mode, and sets
+// their user ID in the request context
+type AuthenticationMiddleware struct {
+	Keys *JWTKeys
+	// UserIDClaim is the claim holding the user ID
+	UserIDClaim string
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// RequireExp rejects the tokens without an exp claim
+	RequireExp bool
+	// AllowLegacyUserID trusts the userid query parameter of the requests
+	// without a bearer token
+	AllowLegacyUserID bool
+}
+
+// NewAuthenticationMiddleware returns the middleware configured in auth
+func NewAuthenticationMiddleware(config *viper.Viper) (*AuthenticationMiddleware, error) {
+	keys, err := LoadJWTKeys(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthenticationMiddleware{
+		Keys:              keys,
+		UserIDClaim:       config.GetString("auth.jwt.userIdClaim"),
+		Issuer:            config.GetString("auth.jwt.issuer"),
+		Audience:          config.GetString("auth.jwt.audience"),
+		RequireExp:        config.GetBool("auth.jwt.requireExp"),
+		AllowLegacyUserID: config.GetBool("auth.legacyUserIdParam"),
+	}, nil
+}
+
+// Serve rejects with 401 the requests whose player can't be authenticated
+func (m *AuthenticationMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authorization := c.Request().Header().Get(echo.HeaderAuthorization)
+		if authorization == "" && m.AllowLegacyUserID {
+			c.Set(userIDContextKey, c.QueryParam("userid"))
+			return next(c)
+		}
+
+		userID, claims, err := m.authenticate(authorization)
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		c.Set(userIDContextKey, userID)
+		c.Set(claimsContextKey, claims)
+		return next(c)
+	}
+}
+
+// authenticate returns the user ID and the claims held by the bearer token
+// of the Authorization header
+func (m *AuthenticationMiddleware) authenticate(authorization string) (string, jwt.MapClaims, error) {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", nil, fmt.Errorf("missing bearer token")
+	}
+
+	claims, err := m.Keys.Parse(strings.TrimSpace(authorization[len(prefix):]))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if m.Issuer != "" && !claims.VerifyIssuer(m.Issuer, true) {
+		return "", nil, fmt.Errorf("invalid issuer")
+	}
+	if m.Audience != "" && !claims.VerifyAudience(m.Audience, true) {
+		return "", nil, fmt.Errorf("invalid audience")
+	}
+	// the parser only checks exp when the token has one
+	if m.RequireExp && !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return "", nil, fmt.Errorf("missing exp claim")
+	}
+
+	switch userID := claims[m.UserIDClaim].(type) {
+	case string:
+		if userID != "" {
+			return userID, claims, nil
+		}
+	case json.Number:
+		// numeric player IDs
+		return userID.String(), claims, nil
+	}
+	return "", nil, fmt.Errorf("missing %s claim", m.UserIDClaim)
+}
+
+// UserID returns the ID of the player authenticated by the AuthenticationMiddleware
+func UserID(c echo.Context) string {
+	userID, _ := c.Get(userIDContextKey).(string)
+	return userID
+}
+
+// Claims returns the claims of the bearer token authenticated by the
+// AuthenticationMiddleware, nil in legacy mode
+func Claims(c echo.Context) jwt.MapClaims {
+	claims, _ := c.Get(claimsContextKey).(jwt.MapClaims)
+	return claims
+}
@@ -0,0 +1,191 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/app"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestAuthenticationMiddleware(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	dir := t.TempDir()
+	hmacSecret := []byte("a-very-secret-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hmacSecretFile := filepath.Join(dir, "secret")
+	writeFile(t, hmacSecretFile, hmacSecret)
+
+	rsaPublicKey, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublicKeyFile := filepath.Join(dir, "rsa.pem")
+	writeFile(t, rsaPublicKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublicKey}))
+
+	jwksFile := filepath.Join(dir, "jwks.json")
+	writeFile(t, jwksFile, []byte(fmt.Sprintf(
+		`{"keys": [{"kty": "EC", "kid": "ec-1", "use": "sig", "crv": "P-256", "x": "%s", "y": "%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(ecdsaKey.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(ecdsaKey.Y.FillBytes(make([]byte, 32))),
+	)))
+
+	sign := func(method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		Expect(err).To(BeNil())
+		return signed
+	}
+
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	g.Describe("Authentication middleware", func() {
+		ctx := context.Background()
+		var a *app.App
+		var topic string
+
+		g.Before(func() {
+			viper.Set("auth.legacyUserIdParam", false)
+			viper.Set("auth.jwt.hmacSecretFile", hmacSecretFile)
+			viper.Set("auth.jwt.publicKeyFiles", []string{rsaPublicKeyFile})
+			viper.Set("auth.jwt.jwksFile", jwksFile)
+			a = GetDefaultTestApp()
+
+			topic = fmt.Sprintf("chat/test_%s", strings.Replace(uuid.NewV4().String(), "-", "", -1))
+			err := a.ACLStore.InsertACLs(ctx, []app.ACL{
+				{Username: "test:test", Pubsub: []string{topic}},
+				{Username: "12345", Pubsub: []string{topic}},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		g.After(func() {
+			viper.Set("auth.legacyUserIdParam", true)
+			viper.Set("auth.jwt.hmacSecretFile", "")
+			viper.Set("auth.jwt.publicKeyFiles", []string{})
+			viper.Set("auth.jwt.jwksFile", "")
+			viper.Set("auth.jwt.userIdClaim", "sub")
+		})
+
+		g.It("It should return 401 without a bearer token when the legacy userid is disabled", func() {
+			path := fmt.Sprintf("/v2/history/%s?userid=test:test", topic)
+			status, _, headers := GetWithHeaders(a, path, nil, t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+			g.Assert(headers.Get("WWW-Authenticate")).Equal("Bearer")
+		})
+
+		g.It("It should authenticate HMAC, RSA and JWKS ECDSA signed tokens", func() {
+			claims := jwt.MapClaims{"sub": "test:test", "exp": time.Now().Add(time.Hour).Unix()}
+			tokens := []string{
+				sign(jwt.SigningMethodHS256, hmacSecret, "", claims),
+				sign(jwt.SigningMethodRS256, rsaKey, "", claims),
+				sign(jwt.SigningMethodPS384, rsaKey, "", claims),
+				sign(jwt.SigningMethodES256, ecdsaKey, "ec-1", claims),
+			}
+
+			for _, token := range tokens {
+				path := fmt.Sprintf("/v2/history/%s", topic)
+				status, _, _ := GetWithHeaders(a, path, bearer(token), t)
+				g.Assert(status).Equal(http.StatusOK)
+			}
+		})
+
+		g.It("It should take the user from the token rather than the userid parameter", func() {
+			claims := jwt.MapClaims{"sub": fmt.Sprintf("test:%s", uuid.NewV4().String()), "exp": time.Now().Add(time.Hour).Unix()}
+			token := sign(jwt.SigningMethodHS256, hmacSecret, "", claims)
+
+			path := fmt.Sprintf("/v2/history/%s?userid=test:test", topic)
+			status, _, _ := GetWithHeaders(a, path, bearer(token), t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+		})
+
+		g.It("It should reject the expired, badly signed and unsigned tokens", func() {
+			otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).To(BeNil())
+
+			tokens := []string{
+				sign(jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"sub": "test:test", "exp": time.Now().Add(-time.Hour).Unix()}),
+				sign(jwt.SigningMethodHS256, []byte("another secret"), "", jwt.MapClaims{"sub": "test:test"}),
+				sign(jwt.SigningMethodRS256, otherKey, "", jwt.MapClaims{"sub": "test:test"}),
+				sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", jwt.MapClaims{"sub": "test:test"}),
+				sign(jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"user": "test:test"}),
+				sign(jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"sub": "test:test"}),
+			}
+
+			for _, token := range tokens {
+				path := fmt.Sprintf("/v2/history/%s", topic)
+				status, _, _ := GetWithHeaders(a, path, bearer(token), t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+			}
+		})
+
+		g.It("It should accept the tokens without exp when auth.jwt.requireExp is disabled", func() {
+			viper.Set("auth.jwt.requireExp", false)
+			defer viper.Set("auth.jwt.requireExp", nil)
+			lenientApp := GetDefaultTestApp()
+
+			token := sign(jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"sub": "test:test"})
+			path := fmt.Sprintf("/v2/history/%s", topic)
+			status, _, _ := GetWithHeaders(lenientApp, path, bearer(token), t)
+			g.Assert(status).Equal(http.StatusOK)
+		})
+
+		g.It("It should read the user ID from the configured claim", func() {
+			viper.Set("auth.jwt.userIdClaim", "player_id")
+			claimApp := GetDefaultTestApp()
+			viper.Set("auth.jwt.userIdClaim", "sub")
+
+			token := sign(jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"sub": "other", "player_id": 12345, "exp": time.Now().Add(time.Hour).Unix()})
+			path := fmt.Sprintf("/v2/history/%s", topic)
+			status, _, _ := GetWithHeaders(claimApp, path, bearer(token), t)
+			g.Assert(status).Equal(http.StatusOK)
+		})
+	})
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
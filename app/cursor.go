@@ -0,0 +1,108 @@
+package app
+
+import (
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/models"
+)
+
+// NextCursorHeader is the response header holding the cursor of the next
+// page. It is only set when the page is full, i.e. there may be more messages
+const NextCursorHeader = "X-Next-Cursor"
+
+// HasMoreHeader is the response header of the forward queries telling
+// whether there are messages after the returned ones
+const HasMoreHeader = "X-Has-More"
+
+// ParseCursorQueryParam returns the cursor sent in the query, or nil if there is none
+func ParseCursorQueryParam(c echo.Context) (*models.Cursor, error) {
+	encoded := c.QueryParam("cursor")
+	if encoded == "" {
+		return nil, nil
+	}
+	return models.DecodeCursor(encoded)
+}
+
+// ParseTopicsCursorQueryParam returns the histories cursor sent in the query, or nil if there is none
+func ParseTopicsCursorQueryParam(c echo.Context) (models.TopicsCursor, error) {
+	encoded := c.QueryParam("cursor")
+	if encoded == "" {
+		return nil, nil
+	}
+	return models.DecodeTopicsCursor(encoded)
+}
+
+// setNextCursor sets the cursor of the page following messages when the page is full
+func setNextCursor(c echo.Context, messages []*models.MessageV2, limit int64) {
+	if len(messages) == 0 || int64(len(messages)) < limit {
+		return
+	}
+	cursor := models.CursorOf(messages[len(messages)-1])
+	c.Response().Header().Set(NextCursorHeader, cursor.Encode())
+}
+
+// setNextTopicsCursor sets the cursor of the topics whose page is full
+func setNextTopicsCursor(c echo.Context, topicsMessages map[string][]*models.MessageV2, limit int64) {
+	cursor := models.TopicsCursor{}
+	for topic, messages := range topicsMessages {
+		if len(messages) > 0 && int64(len(messages)) >= limit {
+			cursor[topic] = models.CursorOf(messages[len(messages)-1])
+		}
+	}
+	if len(cursor) > 0 {
+		c.Response().Header().Set(NextCursorHeader, cursor.Encode())
+	}
+}
+
+// forwardLimit caps the limit of a forward query
+func forwardLimit(limit, maxLimit int64) int64 {
+	if maxLimit > 0 && limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
+// trimForwardPage drops the extra message fetched by forward queries to know whether
+// more messages remain. It sets the HasMoreHeader and the cursor of the last message
+func trimForwardPage(c echo.Context, messages []*models.MessageV2, limit int64) []*models.MessageV2 {
+	hasMore := int64(len(messages)) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	c.Response().Header().Set(HasMoreHeader, strconv.FormatBool(hasMore))
+	if len(messages) > 0 {
+		cursor := models.CursorOf(messages[len(messages)-1])
+		c.Response().Header().Set(NextCursorHeader, cursor.Encode())
+	}
+	return messages
+}
+
+// trimForwardTopicsPage does what trimForwardPage does for every topic of a forward
+// histories query. The next cursor keeps the previous position of the topics without messages
+func trimForwardTopicsPage(
+	c echo.Context,
+	topicsMessages map[string][]*models.MessageV2,
+	limit int64,
+	previous models.TopicsCursor,
+) {
+	hasMore := false
+	cursor := models.TopicsCursor{}
+	for topic, position := range previous {
+		cursor[topic] = position
+	}
+	for topic, messages := range topicsMessages {
+		if int64(len(messages)) > limit {
+			hasMore = true
+			messages = messages[:limit]
+			topicsMessages[topic] = messages
+		}
+		if len(messages) > 0 {
+			cursor[topic] = models.CursorOf(messages[len(messages)-1])
+		}
+	}
+	c.Response().Header().Set(HasMoreHeader, strconv.FormatBool(hasMore))
+	if len(cursor) > 0 {
+		c.Response().Header().Set(NextCursorHeader, cursor.Encode())
+	}
+}
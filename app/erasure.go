@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+	"github.com/topfreegames/mqtt-history/tenant"
+)
+
+const (
+	// ErasureRedact keeps tombstones of the erased messages
+	ErasureRedact = "redact"
+	// ErasureDelete deletes the erased messages
+	ErasureDelete = "delete"
+)
+
+// erasureRequest is the body of the erasure requests
+type erasureRequest struct {
+	PlayerID string `json:"playerId"`
+	// Mode is either redact, the default, or delete
+	Mode string `json:"mode"`
+}
+
+// ErasureOptions selects the messages to erase and who erases them
+type ErasureOptions struct {
+	// GameID, when set, restricts the erasure to the messages of the game
+	GameID   string
+	PlayerID string
+	// Mode is either redact or delete
+	Mode string
+	// Operator and RequestID are recorded in the audit log
+	Operator  string
+	RequestID string
+}
+
+// ErasureReport counts the messages of the player erased in each collection
+type ErasureReport struct {
+	PlayerId    string           `json:"player_id"`
+	GameId      string           `json:"game_id,omitempty"`
+	Mode        string           `json:"mode"`
+	Collections map[string]int64 `json:"collections"`
+	Affected    int64            `json:"affected"`
+}
+
+// ErasePlayerHandler is the handler responsible for erasing all the
+// messages of a player
+func ErasePlayerHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "ErasePlayer")
+		var body erasureRequest
+		err := c.Bind(&body)
+		if err == nil && body.PlayerID == "" {
+			err = errors.New("the player id is empty")
+		}
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting playerId parameter.")
+		}
+		if body.Mode == "" {
+			body.Mode = ErasureRedact
+		}
+		if body.Mode != ErasureRedact && body.Mode != ErasureDelete {
+			logger.Logger.Warningf("Error: unknown erasure mode %s", body.Mode)
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting mode parameter.")
+		}
+
+		report, err := app.ErasePlayerMessages(c, ErasureOptions{
+			GameID:    GameID(c),
+			PlayerID:  body.PlayerID,
+			Mode:      body.Mode,
+			Operator:  CurrentOperator(c).Name,
+			RequestID: RequestID(c),
+		})
+		if err != nil {
+			return err
+		}
+		logger.Logger.Infof(
+			"Operator %s erased %d messages of player %s.",
+			CurrentOperator(c).Name, report.Affected, body.PlayerID,
+		)
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+// ErasePlayerMessages deletes or redacts the messages of the player in the
+// messages collection of the game, or in those of every game when
+// options.GameID is empty, and records the erasure in the audit log. It is
+// shared by the erasure route and the erase command
+func (app *App) ErasePlayerMessages(ctx context.Context, options ErasureOptions) (*ErasureReport, error) {
+	collections := tenant.Collections(app.Config)
+	if options.GameID != "" {
+		collections = []string{tenant.Collection(app.Config, options.GameID)}
+	}
+
+	report := &ErasureReport{
+		PlayerId:    options.PlayerID,
+		GameId:      options.GameID,
+		Mode:        options.Mode,
+		Collections: make(map[string]int64, len(collections)),
+	}
+	var eraseErr error
+	for _, collection := range collections {
+		affected, err := app.MessageStore.ErasePlayerMessages(ctx, storage.Erasure{
+			Collection: collection,
+			GameID:     options.GameID,
+			PlayerID:   options.PlayerID,
+			Redact:     options.Mode == ErasureRedact,
+		})
+		if err != nil {
+			eraseErr = fmt.Errorf("could not erase the messages of %s: %s", collection, err)
+			break
+		}
+		report.Collections[collection] = affected
+		report.Affected += affected
+	}
+
+	// a failed erasure is recorded too, with the messages already erased
+	auditErr := app.Auditor.Record(ctx, models.AuditEntry{
+		Operator:    options.Operator,
+		Action:      "gdpr_erase",
+		PlayerId:    options.PlayerID,
+		GameId:      options.GameID,
+		Mode:        options.Mode,
+		ResultCount: int(report.Affected),
+		Collections: report.Collections,
+		RequestId:   options.RequestID,
+	})
+	if eraseErr != nil {
+		return nil, eraseErr
+	}
+	if auditErr != nil {
+		return nil, fmt.Errorf("could not audit the erasure: %s", auditErr)
+	}
+	return report, nil
+}
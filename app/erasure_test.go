@@ -0,0 +1,162 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestErasureHandler(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Erasure", func() {
+		ctx := context.Background()
+		var a *app.App
+		var topic, playerID string
+
+		g.BeforeEach(func() {
+			viper.Set("tenant.games.game1.collection", "game1_messages")
+			a = GetDefaultTestApp()
+
+			topic = fmt.Sprintf("chat/test_%s", strings.Replace(uuid.NewV4().String(), "-", "", -1))
+			err := a.ACLStore.InsertACLs(ctx, []app.ACL{{Username: "test:test", Pubsub: []string{topic}}})
+			Expect(err).To(BeNil())
+
+			playerID = uuid.NewV4().String()
+			now := time.Now().Unix()
+			payload := map[string]interface{}{"text": "hello"}
+			err = InsertTestMessages(ctx, []*models.MessageV2{
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: playerID, Timestamp: now - 3, Message: "hello", Payload: payload},
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: playerID, Timestamp: now - 2, Message: "hello", Payload: payload},
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: "other", Timestamp: now - 1, Message: "hello", Payload: payload},
+			})
+			Expect(err).To(BeNil())
+			err = a.MessageStore.InsertMessages(ctx, "game1_messages", []*models.MessageV2{
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: playerID, GameId: "game1", Timestamp: now},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		g.AfterEach(func() {
+			viper.Set("tenant.games", nil)
+		})
+
+		erase := func(body string) app.ErasureReport {
+			status, response := PostJSONAsOperator(a, "/gdpr/v2/erase", body, GDPRAPIKey, t)
+			g.Assert(status).Equal(http.StatusOK)
+			var report app.ErasureReport
+			Expect(json.Unmarshal([]byte(response), &report)).To(BeNil())
+			return report
+		}
+
+		history := func() []models.MessageV2 {
+			status, body := Get(a, fmt.Sprintf("/v2/history/%s?userid=test:test", topic), t)
+			g.Assert(status).Equal(http.StatusOK)
+			var messages []models.MessageV2
+			Expect(json.Unmarshal([]byte(body), &messages)).To(BeNil())
+			return messages
+		}
+
+		g.It("It should return 401 without an API key and 403 without the gdpr:erase role", func() {
+			body := fmt.Sprintf(`{"playerId": "%s"}`, playerID)
+			status, _ := PostJSONAsOperator(a, "/gdpr/v2/erase", body, "", t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+
+			status, _ = PostJSONAsOperator(a, "/gdpr/v2/erase", body, ModeratorAPIKey, t)
+			g.Assert(status).Equal(http.StatusForbidden)
+		})
+
+		g.It("It should redact the messages of the player in every collection, keeping tombstones", func() {
+			report := erase(fmt.Sprintf(`{"playerId": "%s"}`, playerID))
+			g.Assert(report.Mode).Equal(app.ErasureRedact)
+			g.Assert(report.Affected).Equal(int64(3))
+			g.Assert(report.Collections["messages"]).Equal(int64(2))
+			g.Assert(report.Collections["game1_messages"]).Equal(int64(1))
+
+			messages := history()
+			g.Assert(len(messages)).Equal(3)
+			for _, message := range messages {
+				if message.PlayerId == playerID {
+					g.Assert(message.Redacted).IsTrue()
+					g.Assert(message.Message).Equal("")
+					g.Assert(len(message.Payload)).Equal(0)
+				} else {
+					g.Assert(message.Redacted).IsFalse()
+					g.Assert(message.Message).Equal("hello")
+				}
+			}
+
+			report = erase(fmt.Sprintf(`{"playerId": "%s"}`, playerID))
+			g.Assert(report.Affected).Equal(int64(0))
+		})
+
+		g.It("It should delete the messages of the player", func() {
+			report := erase(fmt.Sprintf(`{"playerId": "%s", "mode": "delete"}`, playerID))
+			g.Assert(report.Affected).Equal(int64(3))
+
+			messages := history()
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].PlayerId).Equal("other")
+		})
+
+		g.It("It should record the erasures of the route and of the command in the audit log", func() {
+			requestID := uuid.NewV4().String()
+			body := fmt.Sprintf(`{"playerId": "%s", "mode": "delete"}`, playerID)
+			headers := map[string]string{"X-API-Key": GDPRAPIKey, "X-Request-ID": requestID, "Content-Type": "application/json"}
+			status, _, _ := PostWithHeaders(a, "/gdpr/v2/erase", body, headers, t)
+			g.Assert(status).Equal(http.StatusOK)
+
+			_, err := a.ErasePlayerMessages(ctx, app.ErasureOptions{
+				GameID:    "game1",
+				PlayerID:  playerID,
+				Mode:      app.ErasureRedact,
+				Operator:  "cli:test",
+				RequestID: "cli-request",
+			})
+			Expect(err).To(BeNil())
+
+			entries, err := a.AuditStore.FindAuditEntries(ctx, storage.AuditFilter{PlayerID: playerID})
+			Expect(err).To(BeNil())
+			g.Assert(len(entries)).Equal(2)
+			g.Assert(entries[0].Operator).Equal("cli:test")
+			g.Assert(entries[0].GameId).Equal("game1")
+			g.Assert(entries[0].Mode).Equal(app.ErasureRedact)
+			g.Assert(entries[0].ResultCount).Equal(0)
+			g.Assert(entries[1].Action).Equal("gdpr_erase")
+			g.Assert(entries[1].Mode).Equal(app.ErasureDelete)
+			g.Assert(entries[1].ResultCount).Equal(3)
+			g.Assert(entries[1].Collections["messages"]).Equal(int64(2))
+			g.Assert(entries[1].Collections["game1_messages"]).Equal(int64(1))
+			g.Assert(entries[1].RequestId).Equal(requestID)
+		})
+
+		g.It("It should return 422 without a player id or with an unknown mode", func() {
+			for _, body := range []string{`{}`, `{"playerId": ""}`, fmt.Sprintf(`{"playerId": "%s", "mode": "shred"}`, playerID)} {
+				status, _ := PostJSONAsOperator(a, "/gdpr/v2/erase", body, GDPRAPIKey, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			}
+		})
+	})
+}
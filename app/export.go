@@ -0,0 +1,314 @@
+package app
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	"github.com/topfreegames/mqtt-history/tenant"
+)
+
+const (
+	// ExportNDJSON writes a message per line
+	ExportNDJSON = "ndjson"
+	// ExportJSON writes an array of messages
+	ExportJSON = "json"
+)
+
+// ExportOptions selects the messages to export and their format
+type ExportOptions struct {
+	// GameID, when set, restricts the export to the messages of the game
+	GameID   string
+	PlayerID string
+	// Format is either ndjson or json
+	Format string
+	// Zip writes the messages and the manifest in a zip archive
+	Zip bool
+	// Progress, when set, is called every progressInterval messages, and
+	// once the export is done, with the number of messages exported so far
+	Progress func(exported int64)
+	// Operator and RequestID are recorded in the audit log
+	Operator  string
+	RequestID string
+}
+
+const (
+	// ExportComplete is the status of the exports that wrote every message
+	ExportComplete = "complete"
+	// ExportFailed is the status of the exports interrupted by an error
+	ExportFailed = "failed"
+)
+
+// ExportStatus is the last line of the NDJSON exports, telling a complete
+// export apart from a truncated one
+type ExportStatus struct {
+	Status   string `json:"export_status"`
+	Messages int64  `json:"messages"`
+}
+
+// ExportManifest describes an export of the messages of a player
+type ExportManifest struct {
+	PlayerId    string           `json:"player_id"`
+	GameId      string           `json:"game_id,omitempty"`
+	Format      string           `json:"format"`
+	File        string           `json:"file"`
+	Collections map[string]int64 `json:"collections"`
+	Messages    int64            `json:"messages"`
+	ExportedAt  int64            `json:"exported_at"`
+}
+
+// ExportPlayerHandler is the handler responsible for streaming all the
+// messages of a player
+func ExportPlayerHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "ExportPlayer")
+		options, param, err := parseExportParams(c)
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, fmt.Sprintf("Error getting %s parameter.", param))
+		}
+		operator := CurrentOperator(c).Name
+		options.Operator = operator
+		options.RequestID = RequestID(c)
+		options.Progress = func(exported int64) {
+			logger.Logger.Infof("Operator %s exported %d messages of player %s.", operator, exported, options.PlayerID)
+			if flusher, ok := c.Response().(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+
+		fileName := ExportFileName(options)
+		contentType := "application/x-ndjson"
+		if options.Zip {
+			contentType = "application/zip"
+		} else if options.Format == ExportJSON {
+			contentType = echo.MIMEApplicationJSONCharsetUTF8
+		}
+		c.Response().Header().Set(echo.HeaderContentType, contentType)
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fileName))
+
+		// the response is only committed by the first message written, so
+		// that the errors of the first read of the store answer 500
+		if _, err := app.ExportPlayerMessages(c, c.Response(), options); err != nil {
+			if !c.Response().Committed() {
+				c.Response().Header().Del(echo.HeaderContentDisposition)
+				return err
+			}
+			// the truncated export is told apart by its failed status line,
+			// missing manifest or missing closing bracket
+			logger.Logger.Errorf("Could not export the messages of player %s, err: %s", options.PlayerID, err.Error())
+		}
+		return nil
+	}
+}
+
+// ExportPlayerMessages writes the messages of the player, of the messages
+// collection of the game or of those of every game when options.GameID is
+// empty, to w, and records the export in the audit log. It is shared by
+// the export route and the export command
+func (app *App) ExportPlayerMessages(ctx context.Context, w io.Writer, options ExportOptions) (*ExportManifest, error) {
+	collections := tenant.Collections(app.Config)
+	if options.GameID != "" {
+		collections = []string{tenant.Collection(app.Config, options.GameID)}
+	}
+
+	manifest := &ExportManifest{
+		PlayerId:    options.PlayerID,
+		GameId:      options.GameID,
+		Format:      options.Format,
+		File:        messagesFileName(options.Format),
+		Collections: make(map[string]int64, len(collections)),
+		ExportedAt:  time.Now().Unix(),
+	}
+
+	var archive *zip.Writer
+	if options.Zip {
+		archive = zip.NewWriter(w)
+		file, err := archive.Create(manifest.File)
+		if err != nil {
+			return nil, err
+		}
+		w = file
+	}
+
+	progressInterval := app.Config.GetInt64("gdpr.export.progressInterval")
+	// the NDJSON exports end with a status line, the manifest of the zip
+	// archives and the closing bracket of the JSON arrays telling instead
+	// whether they are complete
+	writer := newExportWriter(w, options.Format, !options.Zip)
+	var exportErr error
+	for _, collection := range collections {
+		err := app.MessageStore.StreamPlayerMessages(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			GameID:     options.GameID,
+			PlayerID:   options.PlayerID,
+		}, func(message *models.MessageV2) error {
+			if err := writer.write(message); err != nil {
+				return err
+			}
+			manifest.Collections[collection]++
+			manifest.Messages++
+			if options.Progress != nil && progressInterval > 0 && manifest.Messages%progressInterval == 0 {
+				options.Progress(manifest.Messages)
+			}
+			return nil
+		})
+		if err != nil {
+			exportErr = fmt.Errorf("could not export the messages of %s: %s", collection, err)
+			break
+		}
+	}
+
+	// a failed export is recorded too, with the messages already written
+	auditErr := app.Auditor.Record(ctx, models.AuditEntry{
+		Operator:    options.Operator,
+		Action:      "gdpr_export",
+		PlayerId:    options.PlayerID,
+		GameId:      options.GameID,
+		ResultCount: int(manifest.Messages),
+		Collections: manifest.Collections,
+		RequestId:   options.RequestID,
+	})
+	if exportErr == nil && auditErr != nil {
+		exportErr = fmt.Errorf("could not audit the export: %s", auditErr)
+	}
+	if exportErr != nil {
+		writer.fail()
+		return nil, exportErr
+	}
+
+	if err := writer.close(); err != nil {
+		return nil, err
+	}
+
+	if archive != nil {
+		file, err := archive.Create("manifest.json")
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(manifest); err != nil {
+			return nil, err
+		}
+		if err := archive.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if options.Progress != nil {
+		options.Progress(manifest.Messages)
+	}
+	return manifest, nil
+}
+
+// ExportFileName returns the name of the file of the export
+func ExportFileName(options ExportOptions) string {
+	extension := options.Format
+	if options.Zip {
+		extension = "zip"
+	}
+	return fmt.Sprintf("messages_%s.%s", options.PlayerID, extension)
+}
+
+// messagesFileName returns the name of the messages file in the zip archives
+func messagesFileName(format string) string {
+	return fmt.Sprintf("messages.%s", format)
+}
+
+// parseExportParams returns the options of the export: playerId, format and
+// zip. On error, it also returns the name of the invalid parameter
+func parseExportParams(c echo.Context) (ExportOptions, string, error) {
+	options := ExportOptions{
+		GameID:   GameID(c),
+		PlayerID: c.QueryParam("playerId"),
+		Format:   c.QueryParam("format"),
+	}
+	if options.PlayerID == "" {
+		return options, "playerId", errors.New("the player id is empty")
+	}
+
+	if options.Format == "" {
+		options.Format = ExportNDJSON
+	}
+	if options.Format != ExportNDJSON && options.Format != ExportJSON {
+		return options, "format", fmt.Errorf("unknown export format %s", options.Format)
+	}
+
+	if zipParam := c.QueryParam("zip"); zipParam != "" {
+		var err error
+		if options.Zip, err = strconv.ParseBool(zipParam); err != nil {
+			return options, "zip", err
+		}
+	}
+	return options, "", nil
+}
+
+// exportWriter encodes the exported messages in the format of the export
+type exportWriter struct {
+	w       io.Writer
+	encoder *json.Encoder
+	format  string
+	// status ends the NDJSON exports with an ExportStatus line
+	status  bool
+	written int64
+}
+
+func newExportWriter(w io.Writer, format string, status bool) *exportWriter {
+	return &exportWriter{w: w, encoder: json.NewEncoder(w), format: format, status: status}
+}
+
+// write encodes the message, on its own line
+func (e *exportWriter) write(message *models.MessageV2) error {
+	if e.format == ExportJSON {
+		separator := ","
+		if e.written == 0 {
+			separator = "[\n"
+		}
+		if _, err := io.WriteString(e.w, separator); err != nil {
+			return err
+		}
+	}
+	e.written++
+	return e.encoder.Encode(message)
+}
+
+// close ends the JSON array, or writes the complete status line
+func (e *exportWriter) close() error {
+	if e.format != ExportJSON {
+		return e.writeStatus(ExportComplete)
+	}
+	end := "]\n"
+	if e.written == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// fail writes the failed status line, once messages were written. The
+// exports that wrote nothing are left empty, the route answering 500
+func (e *exportWriter) fail() {
+	if e.format == ExportJSON || e.written == 0 {
+		return
+	}
+	if err := e.writeStatus(ExportFailed); err != nil {
+		logger.Logger.Warningf("Could not write the export status, err: %s", err.Error())
+	}
+}
+
+func (e *exportWriter) writeStatus(status string) error {
+	if !e.status {
+		return nil
+	}
+	return e.encoder.Encode(ExportStatus{Status: status, Messages: e.written})
+}
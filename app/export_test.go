@@ -0,0 +1,218 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestExportHandler(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Export", func() {
+		ctx := context.Background()
+		var a *app.App
+		var playerID string
+		var ids []string
+
+		g.BeforeEach(func() {
+			viper.Set("tenant.games.game1.collection", "game1_messages")
+			a = GetDefaultTestApp()
+
+			playerID = uuid.NewV4().String()
+			ids = []string{uuid.NewV4().String(), uuid.NewV4().String(), uuid.NewV4().String()}
+			now := time.Now().Unix()
+			err := InsertTestMessages(ctx, []*models.MessageV2{
+				{Id: ids[1], Topic: "chat/b", PlayerId: playerID, Timestamp: now - 2},
+				{Id: ids[0], Topic: "chat/a", PlayerId: playerID, Timestamp: now - 3},
+				{Id: uuid.NewV4().String(), Topic: "chat/a", PlayerId: "other", Timestamp: now - 1},
+			})
+			Expect(err).To(BeNil())
+			err = a.MessageStore.InsertMessages(ctx, "game1_messages", []*models.MessageV2{
+				{Id: ids[2], Topic: "chat/a", PlayerId: playerID, GameId: "game1", Timestamp: now},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		g.AfterEach(func() {
+			viper.Set("tenant.games", nil)
+		})
+
+		export := func(query string) (string, http.Header) {
+			status, body, headers := GetWithHeaders(a, "/gdpr/v2/export?"+query, map[string]string{"X-API-Key": GDPRAPIKey}, t)
+			g.Assert(status).Equal(http.StatusOK)
+			return body, headers
+		}
+
+		idsOf := func(messages []models.MessageV2) []string {
+			result := make([]string, len(messages))
+			for i, message := range messages {
+				result[i] = message.Id
+			}
+			return result
+		}
+
+		g.It("It should return 401 without an API key and 403 without the gdpr:export role", func() {
+			url := "/gdpr/v2/export?playerId=" + playerID
+			status, _ := GetAsOperator(a, url, "", t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+
+			status, _ = GetAsOperator(a, url, ModeratorAPIKey, t)
+			g.Assert(status).Equal(http.StatusForbidden)
+		})
+
+		g.It("It should stream the messages of the player of every collection as NDJSON", func() {
+			body, headers := export("playerId=" + playerID)
+			g.Assert(headers.Get("Content-Type")).Equal("application/x-ndjson")
+			g.Assert(strings.Contains(headers.Get("Content-Disposition"), "attachment")).IsTrue()
+
+			lines := strings.Split(strings.TrimSpace(body), "\n")
+			messages := make([]models.MessageV2, len(lines)-1)
+			for i, line := range lines[:len(lines)-1] {
+				Expect(json.Unmarshal([]byte(line), &messages[i])).To(BeNil())
+			}
+			Expect(idsOf(messages)).To(ConsistOf(ids))
+
+			var status app.ExportStatus
+			Expect(json.Unmarshal([]byte(lines[len(lines)-1]), &status)).To(BeNil())
+			g.Assert(status).Equal(app.ExportStatus{Status: app.ExportComplete, Messages: 3})
+		})
+
+		g.It("It should answer 500 when the first read fails and end a truncated export with a failed status", func() {
+			fake := &fakeMessageStore{streamErr: errors.New("connection reset")}
+			a.MessageStore = fake
+			url := "/gdpr/v2/export?playerId=" + playerID
+			status, _, headers := GetWithHeaders(a, url, map[string]string{"X-API-Key": GDPRAPIKey}, t)
+			g.Assert(status).Equal(http.StatusInternalServerError)
+			g.Assert(headers.Get("Content-Disposition")).Equal("")
+
+			fake.streamed = []*models.MessageV2{{Id: ids[0], PlayerId: playerID}}
+			status, body, _ := GetWithHeaders(a, url, map[string]string{"X-API-Key": GDPRAPIKey}, t)
+			g.Assert(status).Equal(http.StatusOK)
+			lines := strings.Split(strings.TrimSpace(body), "\n")
+			g.Assert(len(lines)).Equal(2)
+			var exportStatus app.ExportStatus
+			Expect(json.Unmarshal([]byte(lines[1]), &exportStatus)).To(BeNil())
+			g.Assert(exportStatus).Equal(app.ExportStatus{Status: app.ExportFailed, Messages: 1})
+		})
+
+		g.It("It should record the exports of the route and of the command in the audit log", func() {
+			requestID := uuid.NewV4().String()
+			headers := map[string]string{"X-API-Key": GDPRAPIKey, "X-Request-ID": requestID}
+			status, _, _ := GetWithHeaders(a, "/gdpr/v2/export?playerId="+playerID, headers, t)
+			g.Assert(status).Equal(http.StatusOK)
+
+			var output bytes.Buffer
+			_, err := a.ExportPlayerMessages(ctx, &output, app.ExportOptions{
+				GameID:    "game1",
+				PlayerID:  playerID,
+				Format:    app.ExportJSON,
+				Operator:  "cli:test",
+				RequestID: "cli-request",
+			})
+			Expect(err).To(BeNil())
+
+			entries, err := a.AuditStore.FindAuditEntries(ctx, storage.AuditFilter{PlayerID: playerID})
+			Expect(err).To(BeNil())
+			g.Assert(len(entries)).Equal(2)
+			g.Assert(entries[0].Operator).Equal("cli:test")
+			g.Assert(entries[0].GameId).Equal("game1")
+			g.Assert(entries[0].ResultCount).Equal(1)
+			g.Assert(entries[0].RequestId).Equal("cli-request")
+			g.Assert(entries[1].Action).Equal("gdpr_export")
+			g.Assert(entries[1].Operator).Equal("privacy-officer")
+			g.Assert(entries[1].ResultCount).Equal(3)
+			g.Assert(entries[1].Collections["game1_messages"]).Equal(int64(1))
+			g.Assert(entries[1].RequestId).Equal(requestID)
+		})
+
+		g.It("It should write the messages as a JSON array", func() {
+			body, _ := export(fmt.Sprintf("playerId=%s&format=json", playerID))
+			var messages []models.MessageV2
+			Expect(json.Unmarshal([]byte(body), &messages)).To(BeNil())
+			Expect(idsOf(messages)).To(ConsistOf(ids))
+
+			body, _ = export(fmt.Sprintf("playerId=%s&format=json", uuid.NewV4().String()))
+			Expect(json.Unmarshal([]byte(body), &messages)).To(BeNil())
+			g.Assert(len(messages)).Equal(0)
+		})
+
+		g.It("It should write the messages and the manifest in a zip archive", func() {
+			body, headers := export(fmt.Sprintf("playerId=%s&format=json&zip=true", playerID))
+			g.Assert(headers.Get("Content-Type")).Equal("application/zip")
+
+			archive, err := zip.NewReader(bytes.NewReader([]byte(body)), int64(len(body)))
+			Expect(err).To(BeNil())
+			files := make(map[string][]byte, len(archive.File))
+			for _, file := range archive.File {
+				reader, err := file.Open()
+				Expect(err).To(BeNil())
+				files[file.Name], err = ioutil.ReadAll(reader)
+				Expect(err).To(BeNil())
+				reader.Close()
+			}
+
+			var manifest app.ExportManifest
+			Expect(json.Unmarshal(files["manifest.json"], &manifest)).To(BeNil())
+			g.Assert(manifest.PlayerId).Equal(playerID)
+			g.Assert(manifest.File).Equal("messages.json")
+			g.Assert(manifest.Messages).Equal(int64(3))
+			g.Assert(manifest.Collections["messages"]).Equal(int64(2))
+			g.Assert(manifest.Collections["game1_messages"]).Equal(int64(1))
+
+			var messages []models.MessageV2
+			Expect(json.Unmarshal(files["messages.json"], &messages)).To(BeNil())
+			// the collections are exported in turn, each oldest first
+			g.Assert(idsOf(messages)).Equal([]string{ids[2], ids[0], ids[1]})
+		})
+
+		g.It("It should report the progress of the export", func() {
+			viper.Set("gdpr.export.progressInterval", 2)
+			defer viper.Set("gdpr.export.progressInterval", nil)
+
+			progress := make([]int64, 0)
+			var output bytes.Buffer
+			manifest, err := a.ExportPlayerMessages(ctx, &output, app.ExportOptions{
+				PlayerID: playerID,
+				Format:   app.ExportNDJSON,
+				Progress: func(exported int64) { progress = append(progress, exported) },
+			})
+			Expect(err).To(BeNil())
+			g.Assert(manifest.Messages).Equal(int64(3))
+			g.Assert(progress).Equal([]int64{2, 3})
+		})
+
+		g.It("It should return 422 without a player id or with an unknown format", func() {
+			for _, query := range []string{"", "playerId=" + playerID + "&format=csv", "playerId=" + playerID + "&zip=maybe"} {
+				status, _ := GetAsOperator(a, "/gdpr/v2/export?"+query, GDPRAPIKey, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			}
+		})
+	})
+}
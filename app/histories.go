@@ -15,7 +15,9 @@ func HistoriesHandler(app *App) func(c echo.Context) error {
 	return func(c echo.Context) error {
 		c.Set("route", "Histories")
 		topicPrefix := c.ParamValues()[0]
-		topicsSuffix, userID, from, limit := ParseHistoriesQueryParams(c, app.Defaults.LimitOfMessages)
+		userID := UserID(c)
+		defaults := app.TenantDefaults(c)
+		topicsSuffix, from, limit := ParseHistoriesQueryParams(c, defaults.LimitOfMessages)
 		topics := make([]string, len(topicsSuffix))
 
 		for i, topicSuffix := range topicsSuffix {
@@ -31,8 +33,14 @@ func HistoriesHandler(app *App) func(c echo.Context) error {
 			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
 		}
 
+		// the messages of the players muted by the user are left out
+		excludedPlayerIDs, err := mutedPlayerIDs(c, app, userID)
+		if err != nil {
+			return err
+		}
+
 		messages := make([]*models.Message, 0)
-		collection := app.Defaults.MongoMessagesCollection
+		collection := defaults.MongoMessagesCollection
 		var wg sync.WaitGroup
 		var mu sync.Mutex
 		// guarantees ordering in responses payload
@@ -40,13 +48,15 @@ func HistoriesHandler(app *App) func(c echo.Context) error {
 		for _, topic := range authorizedTopics {
 			wg.Add(1)
 			go func(topic string) {
-				topicMessages := mongoclient.GetMessagesV2(
+				topicMessages := app.MessageStore.GetMessagesV2(
 					c,
 					mongoclient.QueryParameters{
-						Topic:      topic,
-						From:       from,
-						Limit:      limit,
-						Collection: collection,
+						Topic:             topic,
+						From:              from,
+						Limit:             limit,
+						Collection:        collection,
+						GameID:            GameID(c),
+						ExcludedPlayerIDs: excludedPlayerIDs,
 					},
 				)
 				mu.Lock()
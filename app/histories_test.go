@@ -19,9 +19,7 @@ import (
 	uuid "github.com/satori/go.uuid"
 	. "github.com/topfreegames/mqtt-history/app"
 	"github.com/topfreegames/mqtt-history/models"
-	"github.com/topfreegames/mqtt-history/mongoclient"
 	. "github.com/topfreegames/mqtt-history/testing"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func TestHistoriesHandler(t *testing.T) {
@@ -104,15 +102,7 @@ func TestHistoriesHandler(t *testing.T) {
 
 				var topics []string
 
-				query := func(c *mongo.Collection) error {
-					_, err := c.InsertOne(ctx, ACL{Username: "test:test", Pubsub: topics})
-					return err
-				}
-
-				mongoCollection, err := mongoclient.GetCollection(ctx, "mqtt_acl")
-				Expect(err).To(BeNil())
-
-				err = query(mongoCollection)
+				err := a.ACLStore.InsertACLs(ctx, []ACL{{Username: "test:test", Pubsub: topics}})
 				Expect(err).To(BeNil())
 
 				err = InsertMongoMessages(ctx, []string{topic, topic2})
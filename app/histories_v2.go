@@ -2,6 +2,7 @@ package app
 
 import (
 	"net/http"
+	"strconv"
 	"sync"
 
 	"github.com/topfreegames/mqtt-history/logger"
@@ -15,13 +16,50 @@ func HistoriesV2Handler(app *App) func(c echo.Context) error {
 	return func(c echo.Context) error {
 		c.Set("route", "HistoriesV2")
 		topicPrefix := c.ParamValues()[0]
-		topicsSuffix, userID, from, limit := ParseHistoriesQueryParams(c, app.Defaults.LimitOfMessages)
+		userID := UserID(c)
+		defaults := app.TenantDefaults(c)
+		topicsSuffix, from, limit := ParseHistoriesQueryParams(c, defaults.LimitOfMessages)
+		// merged histories are a single timeline with a single cursor
+		merge, _ := strconv.ParseBool(c.QueryParam("merge"))
+		var cursor models.TopicsCursor
+		var timelineCursor *models.Cursor
+		var err error
+		if merge {
+			timelineCursor, err = ParseCursorQueryParam(c)
+		} else {
+			cursor, err = ParseTopicsCursorQueryParam(c)
+		}
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting cursor parameter.")
+		}
+		forward, since, err := ParseForwardQueryParams(c)
+		if err != nil || (forward && since == 0 && cursor == nil && timelineCursor == nil) {
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting since parameter.")
+		}
+		if limit < 0 {
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting limit parameter.")
+		}
+
+		queryLimit := limit
+		if forward {
+			limit = forwardLimit(limit, defaults.ForwardLimitOfMessages)
+			// one more message tells whether there are more to come
+			queryLimit = limit + 1
+		}
+
 		topics := make([]string, len(topicsSuffix))
 
 		for i, topicSuffix := range topicsSuffix {
 			topics[i] = topicPrefix + "/" + topicSuffix
 		}
 
+		withMarkers, _ := strconv.ParseBool(c.QueryParam("markers"))
+		if withMarkers && tooManyUnreadTopics(app, topics) {
+			logger.Logger.Warningf("Error: user %s asked the read markers of %d topics", userID, len(topics))
+			return c.JSON(http.StatusUnprocessableEntity, "Error: too many topics.")
+		}
+
 		logger.Logger.Debugf("user %s is asking for histories v2 for topicPrefix %s with args topics=%s from=%d and limit=%d", userID, topicPrefix, topics, from, limit)
 		authenticated, authorizedTopics, err := IsAuthorized(c.StdContext(), app, userID, topics...)
 		if err != nil {
@@ -32,9 +70,51 @@ func HistoriesV2Handler(app *App) func(c echo.Context) error {
 			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
 		}
 
+		// the messages of the players muted by the user are left out
+		excludedPlayerIDs, err := mutedPlayerIDs(c, app, userID)
+		if err != nil {
+			return err
+		}
+
+		var readStates map[string]TopicReadState
+		if withMarkers {
+			readStates, err = topicsReadStates(c, app, userID, authorizedTopics)
+			if err != nil {
+				return err
+			}
+		}
+
+		collection := defaults.MongoMessagesCollection
+		if merge {
+			messages := app.MessageStore.GetMessagesV2(
+				c,
+				mongoclient.QueryParameters{
+					Topics:            authorizedTopics,
+					From:              from,
+					Limit:             queryLimit,
+					Collection:        collection,
+					GameID:            GameID(c),
+					ExcludedPlayerIDs: excludedPlayerIDs,
+					Cursor:            timelineCursor,
+					Forward:           forward,
+					Since:             since,
+				},
+			)
+			if forward {
+				messages = trimForwardPage(c, messages, limit)
+			} else {
+				setNextCursor(c, messages, limit)
+			}
+			return historiesV2Response(c, messages, readStates)
+		}
+
+		if cursor != nil && !forward {
+			// the topics missing from the cursor have no more pages
+			authorizedTopics = cursorTopics(authorizedTopics, cursor)
+		}
+
 		// retrieve messages
 		messages := make([]*models.MessageV2, 0)
-		collection := app.Defaults.MongoMessagesCollection
 
 		var wg sync.WaitGroup
 		var mu sync.Mutex
@@ -43,13 +123,22 @@ func HistoriesV2Handler(app *App) func(c echo.Context) error {
 		for _, topic := range authorizedTopics {
 			wg.Add(1)
 			go func(topic string) {
-				topicMessages := mongoclient.GetMessagesV2(
+				var topicCursor *models.Cursor
+				if position, ok := cursor[topic]; ok {
+					topicCursor = &position
+				}
+				topicMessages := app.MessageStore.GetMessagesV2(
 					c,
 					mongoclient.QueryParameters{
-						Topic:      topic,
-						From:       from,
-						Limit:      limit,
-						Collection: collection,
+						Topic:             topic,
+						From:              from,
+						Limit:             queryLimit,
+						Collection:        collection,
+						GameID:            GameID(c),
+						ExcludedPlayerIDs: excludedPlayerIDs,
+						Cursor:            topicCursor,
+						Forward:           forward,
+						Since:             since,
 					},
 				)
 				mu.Lock()
@@ -59,18 +148,48 @@ func HistoriesV2Handler(app *App) func(c echo.Context) error {
 			}(topic)
 		}
 		wg.Wait()
+		if forward {
+			trimForwardTopicsPage(c, topicsMessagesMap, limit, cursor)
+		} else {
+			setNextTopicsCursor(c, topicsMessagesMap, limit)
+		}
 		// guarantees ordering in responses payload
 		for _, topic := range authorizedTopics {
 			messages = append(messages, topicsMessagesMap[topic]...)
 		}
 
-		if len(messages) > 0 {
-			gameId := messages[0].GameId
-			if metricTagsMap, ok := c.Get("metricTagsMap").(map[string]interface{}); ok {
-				metricTagsMap["gameID"] = gameId
-			}
+		return historiesV2Response(c, messages, readStates)
+	}
+}
+
+// HistoriesV2WithMarkers is the /v2/histories response when the read
+// markers are asked for
+type HistoriesV2WithMarkers struct {
+	Messages []*models.MessageV2       `json:"messages"`
+	Markers  map[string]TopicReadState `json:"markers"`
+}
+
+func historiesV2Response(c echo.Context, messages []*models.MessageV2, readStates map[string]TopicReadState) error {
+	if len(messages) > 0 {
+		gameId := messages[0].GameId
+		if metricTagsMap, ok := c.Get("metricTagsMap").(map[string]interface{}); ok {
+			metricTagsMap["gameID"] = gameId
 		}
+	}
 
-		return c.JSON(http.StatusOK, messages)
+	if readStates != nil {
+		return c.JSON(http.StatusOK, HistoriesV2WithMarkers{Messages: messages, Markers: readStates})
+	}
+	return c.JSON(http.StatusOK, messages)
+}
+
+// cursorTopics returns the topics that have a position in the cursor
+func cursorTopics(topics []string, cursor models.TopicsCursor) []string {
+	filtered := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if _, ok := cursor[topic]; ok {
+			filtered = append(filtered, topic)
+		}
 	}
+	return filtered
 }
@@ -13,10 +13,12 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	goblin "github.com/franela/goblin"
 	. "github.com/onsi/gomega"
 	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/mqtt-history/app"
 	"github.com/topfreegames/mqtt-history/models"
 	. "github.com/topfreegames/mqtt-history/testing"
 )
@@ -94,6 +96,198 @@ func TestHistoriesV2Handler(t *testing.T) {
 				g.Assert(len(messages)).Equal(1)
 				g.Assert(messages[0].Payload["test 0"]).Equal("test 1")
 			})
+
+			g.It("It should authorize the topics matching the user's ACL topic filters", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/%s/clan/room", testID)
+				topic2 := fmt.Sprintf("chat/%s/other/room", testID)
+				topic3 := fmt.Sprintf("chat/%s/other/lobby", testID)
+
+				err := AuthorizeTestUserInTopics(ctx, []string{
+					fmt.Sprintf("chat/%s/clan/#", testID),
+					fmt.Sprintf("chat/%s/+/room", testID),
+				})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/histories/chat/%s?userid=test:test&topics=clan/room,other/room,other/lobby&markers=true", testID)
+				status, body := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var histories app.HistoriesV2WithMarkers
+				err = json.Unmarshal([]byte(body), &histories)
+				Expect(err).To(BeNil())
+				g.Assert(len(histories.Markers)).Equal(2)
+				_, ok := histories.Markers[topic]
+				g.Assert(ok).IsTrue()
+				_, ok = histories.Markers[topic2]
+				g.Assert(ok).IsTrue()
+				_, ok = histories.Markers[topic3]
+				g.Assert(ok).IsFalse()
+			})
+
+			g.It("It should paginate every topic with the cursor", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				testID2 := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test/%s", testID)
+				topic2 := fmt.Sprintf("chat/test/%s", testID2)
+				now := time.Now().Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic, topic2})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: "a", Topic: topic, Timestamp: now},
+					{Id: "b", Topic: topic, Timestamp: now},
+					{Id: "c", Topic: topic, Timestamp: now - 1},
+					{Id: "d", Topic: topic2, Timestamp: now},
+				})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/histories/chat/test?userid=test:test&topics=%s,%s&limit=2", testID, testID2)
+				status, body, headers := GetWithHeaders(a, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var messages []models.MessageV2
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(3)
+				g.Assert(messages[0].Id).Equal("b")
+				g.Assert(messages[1].Id).Equal("a")
+				g.Assert(messages[2].Id).Equal("d")
+
+				cursor := headers.Get(app.NextCursorHeader)
+				g.Assert(cursor != "").IsTrue()
+
+				path = fmt.Sprintf("%s&cursor=%s", path, cursor)
+				status, body, headers = GetWithHeaders(a, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(1)
+				g.Assert(messages[0].Id).Equal("c")
+				g.Assert(headers.Get(app.NextCursorHeader)).Equal("")
+			})
+
+			g.It("It should return 422 if the limit is negative when reading forward", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test/%s", testID)
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+				err = InsertMongoMessages(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/histories/chat/test?userid=test:test&topics=%s&since=1&limit=-1", testID)
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			})
+
+			g.It("It should return the messages of every topic after since when reading forward", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				testID2 := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test/%s", testID)
+				topic2 := fmt.Sprintf("chat/test/%s", testID2)
+				now := time.Now().Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic, topic2})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: "a", Topic: topic, Timestamp: now - 3},
+					{Id: "b", Topic: topic, Timestamp: now - 2},
+					{Id: "c", Topic: topic, Timestamp: now - 1},
+					{Id: "d", Topic: topic2, Timestamp: now - 1},
+				})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/histories/chat/test?userid=test:test&topics=%s,%s&since=%d&limit=2", testID, testID2, now-10)
+				status, body, headers := GetWithHeaders(a, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var messages []models.MessageV2
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(3)
+				g.Assert(messages[0].Id).Equal("a")
+				g.Assert(messages[1].Id).Equal("b")
+				g.Assert(messages[2].Id).Equal("d")
+				g.Assert(headers.Get(app.HasMoreHeader)).Equal("true")
+
+				path = fmt.Sprintf("%s&cursor=%s", path, headers.Get(app.NextCursorHeader))
+				status, body, headers = GetWithHeaders(a, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(1)
+				g.Assert(messages[0].Id).Equal("c")
+				g.Assert(headers.Get(app.HasMoreHeader)).Equal("false")
+			})
+
+			g.It("It should return a single timeline sorted by timestamp when merging", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				testID2 := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test/%s", testID)
+				topic2 := fmt.Sprintf("chat/test/%s", testID2)
+				now := time.Now().Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic, topic2})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: "a", Topic: topic, Timestamp: now - 4},
+					{Id: "b", Topic: topic2, Timestamp: now - 3},
+					{Id: "c", Topic: topic, Timestamp: now - 2},
+					{Id: "d", Topic: topic2, Timestamp: now - 1},
+				})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/histories/chat/test?userid=test:test&topics=%s,%s&merge=true&limit=3", testID, testID2)
+				status, body, headers := GetWithHeaders(a, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var messages []models.MessageV2
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(3)
+				g.Assert(messages[0].Id).Equal("d")
+				g.Assert(messages[1].Id).Equal("c")
+				g.Assert(messages[2].Id).Equal("b")
+
+				path = fmt.Sprintf("%s&cursor=%s", path, headers.Get(app.NextCursorHeader))
+				status, body, headers = GetWithHeaders(a, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(1)
+				g.Assert(messages[0].Id).Equal("a")
+				g.Assert(headers.Get(app.NextCursorHeader)).Equal("")
+			})
+
+			g.It("It should only merge the authorized topics", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				testID2 := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test/%s", testID)
+				topic2 := fmt.Sprintf("chat/test/%s", testID2)
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				err = InsertMongoMessages(ctx, []string{topic, topic2})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/histories/chat/test?userid=test:test&topics=%s,%s&merge=true", testID, testID2)
+				status, body := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var messages []models.MessageV2
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(1)
+				g.Assert(messages[0].Topic).Equal(topic)
+			})
 		})
 	})
 }
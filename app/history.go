@@ -15,7 +15,9 @@ func HistoryHandler(app *App) func(c echo.Context) error {
 	return func(c echo.Context) error {
 		c.Set("route", "History")
 		topic := c.ParamValues()[0]
-		userID, from, limit, _ := ParseHistoryQueryParams(c, app.Defaults.LimitOfMessages)
+		userID := UserID(c)
+		defaults := app.TenantDefaults(c)
+		from, limit, _ := ParseHistoryQueryParams(c, defaults.LimitOfMessages)
 		authenticated, _, err := IsAuthorized(c, app, userID, topic)
 		if err != nil {
 			return err
@@ -29,15 +31,23 @@ func HistoryHandler(app *App) func(c echo.Context) error {
 			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
 		}
 
-		collection := app.Defaults.MongoMessagesCollection
+		// the messages of the players muted by the user are left out
+		excludedPlayerIDs, err := mutedPlayerIDs(c, app, userID)
+		if err != nil {
+			return err
+		}
+
+		collection := defaults.MongoMessagesCollection
 		messages := make([]*models.Message, 0)
-		messagesV2 := mongoclient.GetMessagesV2(
+		messagesV2 := app.MessageStore.GetMessagesV2(
 			c,
 			mongoclient.QueryParameters{
-				Topic:      topic,
-				From:       from,
-				Limit:      limit,
-				Collection: collection,
+				Topic:             topic,
+				From:              from,
+				Limit:             limit,
+				Collection:        collection,
+				GameID:            GameID(c),
+				ExcludedPlayerIDs: excludedPlayerIDs,
 			},
 		)
 
@@ -2,6 +2,7 @@ package app
 
 import (
 	"net/http"
+	"net/url"
 
 	"github.com/topfreegames/mqtt-history/mongoclient"
 
@@ -16,12 +17,68 @@ import (
 func HistoryV2Handler(app *App) func(c echo.Context) error {
 	return func(c echo.Context) error {
 		c.Set("route", "HistoryV2")
-		topic := c.ParamValues()[0]
-		userID, from, limit, isBlocked := ParseHistoryQueryParams(c, app.Defaults.LimitOfMessages)
-		authenticated, _, err := IsAuthorized(c.StdContext(), app, userID, topic)
+		// # must be escaped in URLs, so that topic filters are sent escaped
+		topic, err := url.PathUnescape(c.ParamValues()[0])
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting topic parameter.")
+		}
+		userID := UserID(c)
+		defaults := app.TenantDefaults(c)
+		from, limit, isBlocked := ParseHistoryQueryParams(c, defaults.LimitOfMessages)
+		cursor, err := ParseCursorQueryParam(c)
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting cursor parameter.")
+		}
+		forward, since, err := ParseForwardQueryParams(c)
+		if err != nil || (forward && since == 0 && cursor == nil) {
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting since parameter.")
+		}
+		if limit < 0 {
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting limit parameter.")
+		}
+
+		queryLimit := limit
+		if forward {
+			limit = forwardLimit(limit, defaults.ForwardLimitOfMessages)
+			// one more message tells whether there are more to come
+			queryLimit = limit + 1
+		}
+
+		collection := defaults.MongoMessagesCollection
+		// a topic filter is authorized and queried as the topics it matches
+		var authorizedTopics []string
+		isTopicFilter := models.IsTopicFilter(topic)
+		if isTopicFilter {
+			if err := models.ValidateTopicFilter(topic); err != nil {
+				logger.Logger.Warningf("Error: %s", err.Error())
+				return c.JSON(http.StatusUnprocessableEntity, "Error getting topic parameter.")
+			}
+			// only the topics with messages in the range of the page are matched
+			topicsQuery := mongoclient.QueryParameters{
+				Topic:      topic,
+				Limit:      defaults.LimitOfWildcardTopics,
+				Collection: collection,
+				GameID:     GameID(c),
+				Forward:    forward,
+				From:       from,
+				Since:      since,
+			}
+			if !forward {
+				if cursor != nil {
+					topicsQuery.From = cursor.Timestamp
+				}
+				topicsQuery.Since = topicsQuery.From - app.Config.GetInt64("mongo.messages.wildcardTopicsLookback")
+			}
+			authorizedTopics, err = authorizedTopicsMatching(c, app, userID, topicsQuery)
+		} else {
+			_, authorizedTopics, err = IsAuthorized(c.StdContext(), app, userID, topic)
+		}
 		if err != nil {
 			return err
 		}
+		authenticated := len(authorizedTopics) > 0
 
 		logger.Logger.Debugf(
 			"user %s (authenticated=%v) is asking for history v2 for topic %s with args from=%d and limit=%d",
@@ -31,18 +88,36 @@ func HistoryV2Handler(app *App) func(c echo.Context) error {
 			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
 		}
 
-		messages := make([]*models.MessageV2, 0)
-		collection := app.Defaults.MongoMessagesCollection
-		messages = mongoclient.GetMessagesV2WithParameter(
-			c,
-			mongoclient.QueryParameters{
-				Topic:      topic,
-				From:       from,
-				Limit:      limit,
-				Collection: collection,
-				IsBlocked:  isBlocked,
-			},
-		)
+		// the messages of the players muted by the user are left out
+		excludedPlayerIDs, err := mutedPlayerIDs(c, app, userID)
+		if err != nil {
+			return err
+		}
+
+		queryParameters := mongoclient.QueryParameters{
+			Topic:             topic,
+			From:              from,
+			Limit:             queryLimit,
+			Collection:        collection,
+			GameID:            GameID(c),
+			ExcludedPlayerIDs: excludedPlayerIDs,
+			IsBlocked:         isBlocked,
+			Cursor:            cursor,
+			Forward:           forward,
+			Since:             since,
+		}
+		if isTopicFilter {
+			// the matched topics are merged in a single timeline bounded by limit
+			queryParameters.Topic = ""
+			queryParameters.Topics = authorizedTopics
+		}
+
+		messages := app.MessageStore.GetMessagesV2(c, queryParameters)
+		if forward {
+			messages = trimForwardPage(c, messages, limit)
+		} else {
+			setNextCursor(c, messages, limit)
+		}
 
 		if len(messages) > 0 {
 			gameId := messages[0].GameId
@@ -54,3 +129,35 @@ func HistoryV2Handler(app *App) func(c echo.Context) error {
 		return c.JSON(http.StatusOK, messages)
 	}
 }
+
+// authorizedTopicsMatching authorizes the topics matching the topic filter
+// page by page, until queryParameters.Limit of them are authorized or
+// mongo.messages.wildcardTopicsMaxPages pages are read, so that the topics
+// the user may not read do not take up the limit
+func authorizedTopicsMatching(c echo.Context, app *App, userID string, queryParameters mongoclient.QueryParameters) ([]string, error) {
+	authorizedTopics := []string{}
+	maxPages := app.Config.GetInt("mongo.messages.wildcardTopicsMaxPages")
+	for page := 0; page < maxPages; page++ {
+		topics, err := app.MessageStore.GetTopicsV2(c, queryParameters)
+		if err != nil {
+			return nil, err
+		}
+		if len(topics) == 0 {
+			break
+		}
+
+		_, authorized, err := IsAuthorized(c.StdContext(), app, userID, topics...)
+		if err != nil {
+			return nil, err
+		}
+		authorizedTopics = append(authorizedTopics, authorized...)
+		if int64(len(authorizedTopics)) >= queryParameters.Limit {
+			return authorizedTopics[:queryParameters.Limit], nil
+		}
+		if int64(len(topics)) < queryParameters.Limit {
+			break
+		}
+		queryParameters.TopicsAfter = topics[len(topics)-1]
+	}
+	return authorizedTopics, nil
+}
@@ -14,7 +14,12 @@ import (
 func HistoriesV2PSHandler(app *App) func(c echo.Context) error {
 	return func(c echo.Context) error {
 		c.Set("route", "HistoriesV2PlayerSupport")
-		userID, playerId, topic, limit, isBlocked := ParseHistoryPSQueryParams(c, app.Defaults.LimitOfMessages)
+		operator := CurrentOperator(c)
+		defaults := app.TenantDefaults(c)
+		playerId, topic, limit, isBlocked := ParseHistoryPSQueryParams(c, defaults.LimitOfMessages)
+		if isBlocked && !operator.HasRole(models.RoleSupportBlocked) {
+			return forbidden(c, operator, models.RoleSupportBlocked)
+		}
 
 		initialDateParamsFilter := c.QueryParam("initialDate")
 		from, err := transformDate(initialDateParamsFilter, true)
@@ -31,12 +36,12 @@ func HistoriesV2PSHandler(app *App) func(c echo.Context) error {
 		}
 
 		logger.Logger.Debugf(
-			"user %s is asking for history v2 for topic %s with date args from=%d to=%d and limit=%d",
-			userID, topic, from, to, limit)
+			"operator %s is asking for history v2 for topic %s with date args from=%d to=%d and limit=%d",
+			operator.Name, topic, from, to, limit)
 
 		messages := make([]*models.MessageV2, 0)
-		collection := app.Defaults.MongoMessagesCollection
-		messages = mongoclient.GetMessagesPlayerSupportV2WithParameter(
+		collection := defaults.MongoMessagesCollection
+		messages = app.MessageStore.GetMessagesPlayerSupportV2(
 			c,
 			mongoclient.QueryParameters{
 				Topic:      topic,
@@ -44,11 +49,28 @@ func HistoriesV2PSHandler(app *App) func(c echo.Context) error {
 				To:         to,
 				Limit:      limit,
 				Collection: collection,
+				GameID:     GameID(c),
 				IsBlocked:  isBlocked,
 				PlayerID:   playerId,
 			},
 		)
 
+		err = app.Auditor.Record(c, models.AuditEntry{
+			Operator:    operator.Name,
+			Action:      "ps_history",
+			PlayerId:    playerId,
+			Topic:       topic,
+			From:        from,
+			To:          to,
+			IsBlocked:   isBlocked,
+			ResultCount: len(messages),
+			RequestId:   RequestID(c),
+		})
+		if err != nil {
+			// the messages are not sent when the read can't be audited
+			return err
+		}
+
 		if len(messages) > 0 {
 			gameId := messages[0].GameId
 			if metricTagsMap, ok := c.Get("metricTagsMap").(map[string]interface{}); ok {
@@ -18,6 +18,7 @@ import (
 	goblin "github.com/franela/goblin"
 	. "github.com/onsi/gomega"
 	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
 	"github.com/topfreegames/mqtt-history/models"
 	. "github.com/topfreegames/mqtt-history/testing"
 )
@@ -40,7 +41,7 @@ func TestHistoryV2PSHandler(t *testing.T) {
 				playerID := "test"
 
 				path := fmt.Sprintf("/ps/v2/history?topic=%s&playerId=%s&initialDate=2022-01-01&finalDate=2022-12-01", topic, playerID)
-				status, body := Get(a, path, t)
+				status, body := GetAsOperator(a, path, SupportReadAPIKey, t)
 				g.Assert(status).Equal(http.StatusOK)
 
 				var messages []models.Message
@@ -59,7 +60,7 @@ func TestHistoryV2PSHandler(t *testing.T) {
 				Expect(err).To(BeNil())
 
 				path := fmt.Sprintf("/ps/v2/history?topic=%s&playerId=%s&initialDate=2022-01-01&finalDate=%s", topic, playerID, finalDate)
-				status, body := Get(a, path, t)
+				status, body := GetAsOperator(a, path, SupportReadAPIKey, t)
 				g.Assert(status).Equal(http.StatusOK)
 
 				var messages []models.MessageV2
@@ -81,7 +82,7 @@ func TestHistoryV2PSHandler(t *testing.T) {
 				Expect(err).To(BeNil())
 
 				path := fmt.Sprintf("/ps/v2/history?topic=%s&initialDate=2022-01-01&finalDate=%s", topic, finalDate)
-				status, body := Get(a, path, t)
+				status, body := GetAsOperator(a, path, SupportReadAPIKey, t)
 				g.Assert(status).Equal(http.StatusOK)
 
 				var messages []models.MessageV2
@@ -103,7 +104,7 @@ func TestHistoryV2PSHandler(t *testing.T) {
 				Expect(err).To(BeNil())
 
 				path := fmt.Sprintf("/ps/v2/history?topic=%s&playerId=%s", topic, playerID)
-				status, _ := Get(a, path, t)
+				status, _ := GetAsOperator(a, path, SupportReadAPIKey, t)
 				g.Assert(status).Equal(http.StatusUnprocessableEntity)
 
 			})
@@ -118,7 +119,7 @@ func TestHistoryV2PSHandler(t *testing.T) {
 				Expect(err).To(BeNil())
 
 				path := fmt.Sprintf("/ps/v2/history?playerId=%s&initialDate=2022-01-01&finalDate=%s", playerID, finalDate)
-				status, body := Get(a, path, t)
+				status, body := GetAsOperator(a, path, SupportReadAPIKey, t)
 				g.Assert(status).Equal(http.StatusOK)
 
 				var messages []models.MessageV2
@@ -147,7 +148,7 @@ func TestHistoryV2PSHandler(t *testing.T) {
 				Expect(err).To(BeNil())
 
 				path := fmt.Sprintf("/ps/v2/history?topic=%s&playerId=%s&initialDate=2022-01-01&finalDate=%s&isBlocked=true", topic, playerID, finalDate)
-				status, body := Get(a, path, t)
+				status, body := GetAsOperator(a, path, SupportBlockedAPIKey, t)
 				g.Assert(status).Equal(http.StatusOK)
 
 				var messages []models.MessageV2
@@ -159,6 +160,45 @@ func TestHistoryV2PSHandler(t *testing.T) {
 				g.Assert(messages[0].Blocked).Equal(true)
 
 			})
+			g.It("It should return 401 without a known API key", func() {
+				path := "/ps/v2/history?playerId=test&initialDate=2022-01-01&finalDate=2022-12-01"
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+
+				status, _ = GetAsOperator(a, path, "unknown-key", t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+			})
+
+			g.It("It should accept the API key as a bearer token", func() {
+				path := "/ps/v2/history?playerId=test&initialDate=2022-01-01&finalDate=2022-12-01"
+				headers := map[string]string{"Authorization": "Bearer " + SupportReadAPIKey}
+				status, _, _ := GetWithHeaders(a, path, headers, t)
+				g.Assert(status).Equal(http.StatusOK)
+			})
+
+			g.It("It should return 403 when reading blocked messages without the support:blocked role", func() {
+				path := "/ps/v2/history?playerId=test&initialDate=2022-01-01&finalDate=2022-12-01&isBlocked=true"
+				status, _ := GetAsOperator(a, path, SupportReadAPIKey, t)
+				g.Assert(status).Equal(http.StatusForbidden)
+			})
+
+			g.It("It should return 403 without the support:read role", func() {
+				viper.Set("operatorAuth.source", "storage")
+				storageApp := GetDefaultTestApp()
+				viper.Set("operatorAuth.source", "config")
+
+				operatorStore := storageApp.OperatorStore.(interface {
+					InsertOperators(context.Context, []models.Operator) error
+				})
+				err := operatorStore.InsertOperators(ctx, []models.Operator{
+					{Name: "no-roles", KeySha256: models.HashAPIKey("no-roles-key")},
+				})
+				Expect(err).To(BeNil())
+
+				path := "/ps/v2/history?playerId=test&initialDate=2022-01-01&finalDate=2022-12-01"
+				status, _ := GetAsOperator(storageApp, path, "no-roles-key", t)
+				g.Assert(status).Equal(http.StatusForbidden)
+			})
 		})
 	})
 }
@@ -13,10 +13,12 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	goblin "github.com/franela/goblin"
 	. "github.com/onsi/gomega"
 	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/mqtt-history/app"
 	"github.com/topfreegames/mqtt-history/models"
 	. "github.com/topfreegames/mqtt-history/testing"
 )
@@ -162,6 +164,273 @@ func TestHistoryV2Handler(t *testing.T) {
 				g.Assert(messages[0].Blocked).Equal(false)
 
 			})
+
+			g.It("It should paginate with the cursor without duplicating or skipping messages", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test_%s", testID)
+				now := time.Now().Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: "a", Topic: topic, Timestamp: now},
+					{Id: "b", Topic: topic, Timestamp: now},
+					{Id: "c", Topic: topic, Timestamp: now},
+					{Id: "d", Topic: topic, Timestamp: now - 1},
+				})
+				Expect(err).To(BeNil())
+
+				ids := []string{}
+				path := fmt.Sprintf("/v2/history/%s?userid=test:test&limit=2", topic)
+				for page := 0; page < 3; page++ {
+					status, body, headers := GetWithHeaders(a, path, nil, t)
+					g.Assert(status).Equal(http.StatusOK)
+
+					var messages []models.MessageV2
+					err = json.Unmarshal([]byte(body), &messages)
+					Expect(err).To(BeNil())
+					for _, message := range messages {
+						ids = append(ids, message.Id)
+					}
+
+					cursor := headers.Get(app.NextCursorHeader)
+					if cursor == "" {
+						break
+					}
+					path = fmt.Sprintf("/v2/history/%s?userid=test:test&limit=2&cursor=%s", topic, cursor)
+				}
+
+				g.Assert(ids).Equal([]string{"c", "b", "a", "d"})
+			})
+
+			g.It("It should not return a cursor if the page is not full", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test_%s", testID)
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				err = InsertMongoMessages(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/history/%s?userid=test:test&limit=2", topic)
+				status, _, headers := GetWithHeaders(a, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+				g.Assert(headers.Get(app.NextCursorHeader)).Equal("")
+			})
+
+			g.It("It should return 422 if the cursor is invalid", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test_%s", testID)
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/history/%s?userid=test:test&cursor=invalid!", topic)
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			})
+
+			g.It("It should return the messages after since oldest first when reading forward", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test_%s", testID)
+				now := time.Now().Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: "a", Topic: topic, Timestamp: now - 3},
+					{Id: "b", Topic: topic, Timestamp: now - 2},
+					{Id: "c", Topic: topic, Timestamp: now - 2},
+					{Id: "d", Topic: topic, Timestamp: now - 1},
+				})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/history/%s?userid=test:test&direction=forward&since=%d&limit=2", topic, now-3)
+				status, body, headers := GetWithHeaders(a, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var messages []models.MessageV2
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(2)
+				g.Assert(messages[0].Id).Equal("b")
+				g.Assert(messages[1].Id).Equal("c")
+				g.Assert(headers.Get(app.HasMoreHeader)).Equal("true")
+
+				cursor := headers.Get(app.NextCursorHeader)
+				path = fmt.Sprintf("/v2/history/%s?userid=test:test&direction=forward&cursor=%s&limit=2", topic, cursor)
+				status, body, headers = GetWithHeaders(a, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(1)
+				g.Assert(messages[0].Id).Equal("d")
+				g.Assert(headers.Get(app.HasMoreHeader)).Equal("false")
+			})
+
+			g.It("It should cap the limit of forward queries", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test_%s", testID)
+				now := time.Now().Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: "a", Topic: topic, Timestamp: now - 2},
+					{Id: "b", Topic: topic, Timestamp: now - 1},
+				})
+				Expect(err).To(BeNil())
+
+				cappedApp := GetDefaultTestApp()
+				cappedApp.Defaults.ForwardLimitOfMessages = 1
+
+				path := fmt.Sprintf("/v2/history/%s?userid=test:test&since=%d&limit=100", topic, now-10)
+				status, body, headers := GetWithHeaders(cappedApp, path, nil, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var messages []models.MessageV2
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(1)
+				g.Assert(messages[0].Id).Equal("a")
+				g.Assert(headers.Get(app.HasMoreHeader)).Equal("true")
+			})
+
+			g.It("It should return 422 when reading forward without since nor cursor", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test_%s", testID)
+
+				path := fmt.Sprintf("/v2/history/%s?userid=test:test&direction=forward", topic)
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			})
+			g.It("It should merge the authorized topics matching a topic filter", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/%s/a", testID)
+				topic2 := fmt.Sprintf("chat/%s/b/c", testID)
+				unauthorizedTopic := fmt.Sprintf("chat/%s/d", testID)
+				now := time.Now().Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic, topic2})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: "a", Topic: topic, Timestamp: now - 4},
+					{Id: "b", Topic: topic2, Timestamp: now - 3},
+					{Id: "c", Topic: unauthorizedTopic, Timestamp: now - 2},
+					{Id: "d", Topic: topic, Timestamp: now - 1},
+				})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/history/chat/%s/%%23?userid=test:test&limit=2", testID)
+				status, body := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var messages []models.MessageV2
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(2)
+				g.Assert(messages[0].Id).Equal("d")
+				g.Assert(messages[1].Id).Equal("b")
+
+				path = fmt.Sprintf("/v2/history/chat/%s/+?userid=test:test", testID)
+				status, body = Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(2)
+				g.Assert(messages[0].Id).Equal("d")
+				g.Assert(messages[1].Id).Equal("a")
+			})
+
+			g.It("It should not count the unauthorized topics matching a topic filter in its limit", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				unauthorizedTopic := fmt.Sprintf("chat/%s/a", testID)
+				topic := fmt.Sprintf("chat/%s/b", testID)
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				err = InsertMongoMessages(ctx, []string{unauthorizedTopic, topic})
+				Expect(err).To(BeNil())
+
+				limit := a.Defaults.LimitOfWildcardTopics
+				a.Defaults.LimitOfWildcardTopics = 1
+				defer func() { a.Defaults.LimitOfWildcardTopics = limit }()
+
+				path := fmt.Sprintf("/v2/history/chat/%s/+?userid=test:test", testID)
+				status, body := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var messages []models.MessageV2
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(1)
+				g.Assert(messages[0].Topic).Equal(topic)
+			})
+
+			g.It("It should only match the topics with messages in the lookback before from", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/%s/a", testID)
+				sentAt := time.Now().AddDate(0, 0, -40).Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: uuid.NewV4().String(), Topic: topic, Timestamp: sentAt},
+				})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/history/chat/%s/+?userid=test:test", testID)
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+
+				path = fmt.Sprintf("/v2/history/chat/%s/+?userid=test:test&from=%d", testID, sentAt+1)
+				status, body := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var messages []models.MessageV2
+				err = json.Unmarshal([]byte(body), &messages)
+				Expect(err).To(BeNil())
+				g.Assert(len(messages)).Equal(1)
+			})
+
+			g.It("It should return 401 if the user is not authorized into any topic matching the filter", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				err := InsertMongoMessages(ctx, []string{fmt.Sprintf("chat/%s/a", testID)})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/history/chat/%s/+?userid=test:test", testID)
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+			})
+
+			g.It("It should return 422 if the limit is negative when reading forward", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test_%s", testID)
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+				err = InsertMongoMessages(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/history/%s?userid=test:test&since=1&limit=-1", topic)
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			})
+
+			g.It("It should return 422 if the topic filter is invalid", func() {
+				path := "/v2/history/chat/a%23?userid=test:test"
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			})
 		})
 	})
 }
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/viper"
+)
+
+// jwtMethods are the signing methods accepted in the players' tokens
+var jwtMethods = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+}
+
+// JWTKeys holds the keys able to verify the players' tokens
+type JWTKeys struct {
+	HMAC  [][]byte
+	RSA   []*rsa.PublicKey
+	ECDSA []*ecdsa.PublicKey
+	// ByKid holds the JWKS keys, looked up by the kid header of the tokens
+	ByKid map[string]interface{}
+}
+
+// jwk is a key of a JWKS document, as defined by RFC 7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWTKeys loads the HMAC secret, the PEM encoded RSA and ECDSA public
+// keys and the JWKS document configured in auth.jwt
+func LoadJWTKeys(config *viper.Viper) (*JWTKeys, error) {
+	keys := &JWTKeys{ByKid: make(map[string]interface{})}
+
+	if path := config.GetString("auth.jwt.hmacSecretFile"); path != "" {
+		secret, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys.HMAC = append(keys.HMAC, bytes.TrimSpace(secret))
+	}
+
+	for _, path := range config.GetStringSlice("auth.jwt.publicKeyFiles") {
+		encoded, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(encoded); err == nil {
+			keys.RSA = append(keys.RSA, rsaKey)
+			continue
+		}
+		ecdsaKey, err := jwt.ParseECPublicKeyFromPEM(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s is neither an RSA nor an ECDSA public key", path)
+		}
+		keys.ECDSA = append(keys.ECDSA, ecdsaKey)
+	}
+
+	if path := config.GetString("auth.jwt.jwksFile"); path != "" {
+		if err := keys.loadJWKS(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// Empty tells whether no key was loaded
+func (k *JWTKeys) Empty() bool {
+	return len(k.HMAC) == 0 && len(k.RSA) == 0 && len(k.ECDSA) == 0
+}
+
+func (k *JWTKeys) loadJWKS(path string) error {
+	encoded, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(encoded, &jwks); err != nil {
+		return fmt.Errorf("invalid JWKS document %s: %s", path, err)
+	}
+
+	for _, key := range jwks.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		var parsed interface{}
+		switch key.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil {
+				return fmt.Errorf("invalid oct key %s: %s", key.Kid, err)
+			}
+			k.HMAC = append(k.HMAC, secret)
+			parsed = secret
+		case "RSA":
+			rsaKey, err := parseRSAJWK(key)
+			if err != nil {
+				return fmt.Errorf("invalid RSA key %s: %s", key.Kid, err)
+			}
+			k.RSA = append(k.RSA, rsaKey)
+			parsed = rsaKey
+		case "EC":
+			ecdsaKey, err := parseECJWK(key)
+			if err != nil {
+				return fmt.Errorf("invalid EC key %s: %s", key.Kid, err)
+			}
+			k.ECDSA = append(k.ECDSA, ecdsaKey)
+			parsed = ecdsaKey
+		default:
+			return fmt.Errorf("unsupported key type %s of key %s", key.Kty, key.Kid)
+		}
+
+		if key.Kid != "" {
+			k.ByKid[key.Kid] = parsed
+		}
+	}
+	return nil
+}
+
+func parseRSAJWK(key jwk) (*rsa.PublicKey, error) {
+	n, err := decodeJWKInt(key.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeJWKInt(key.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func parseECJWK(key jwk) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch key.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %s", key.Crv)
+	}
+
+	x, err := decodeJWKInt(key.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeJWKInt(key.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("point is not on curve %s", key.Crv)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+// candidates returns the keys that may have signed the token: the JWKS key
+// named by its kid header or else every key of its signing method family.
+// The signing methods reject the keys of another family
+func (k *JWTKeys) candidates(token *jwt.Token) []interface{} {
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok := k.ByKid[kid]; ok {
+			return []interface{}{key}
+		}
+	}
+
+	candidates := make([]interface{}, 0)
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		for _, key := range k.HMAC {
+			candidates = append(candidates, key)
+		}
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		for _, key := range k.RSA {
+			candidates = append(candidates, key)
+		}
+	case *jwt.SigningMethodECDSA:
+		for _, key := range k.ECDSA {
+			candidates = append(candidates, key)
+		}
+	}
+	return candidates
+}
+
+// Parse verifies the signature and the time based claims of the token
+// and returns its claims
+func (k *JWTKeys) Parse(tokenString string) (jwt.MapClaims, error) {
+	// numbers are kept as json.Number, so that numeric player IDs keep their precision
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtMethods), jwt.WithJSONNumber())
+	unverified, _, err := parser.ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = fmt.Errorf("no key to verify %s tokens", unverified.Method.Alg())
+	for _, key := range k.candidates(unverified) {
+		key := key
+		claims := jwt.MapClaims{}
+		_, err = parser.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		})
+		if err == nil {
+			return claims, nil
+		}
+	}
+	return nil, err
+}
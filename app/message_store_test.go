@@ -0,0 +1,134 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	"github.com/topfreegames/mqtt-history/storage"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+type fakeMessageStore struct {
+	queries []mongoclient.QueryParameters
+	// streamed are emitted by StreamPlayerMessages before failing with
+	// streamErr, when set
+	streamed  []*models.MessageV2
+	streamErr error
+}
+
+func (s *fakeMessageStore) GetMessagesV2(ctx context.Context, queryParameters mongoclient.QueryParameters) []*models.MessageV2 {
+	s.queries = append(s.queries, queryParameters)
+	return []*models.MessageV2{{Id: "fake", Topic: queryParameters.Topic}}
+}
+
+func (s *fakeMessageStore) GetMessagesPlayerSupportV2(ctx context.Context, queryParameters mongoclient.QueryParameters) []*models.MessageV2 {
+	s.queries = append(s.queries, queryParameters)
+	return []*models.MessageV2{}
+}
+
+func (s *fakeMessageStore) CountMessagesV2(ctx context.Context, queryParameters mongoclient.QueryParameters) (int64, error) {
+	return 0, nil
+}
+
+func (s *fakeMessageStore) GetTopicsV2(ctx context.Context, queryParameters mongoclient.QueryParameters) ([]string, error) {
+	return nil, nil
+}
+
+func (s *fakeMessageStore) InsertMessages(ctx context.Context, collection string, messages []*models.MessageV2) error {
+	return nil
+}
+
+func (s *fakeMessageStore) ModerateMessages(ctx context.Context, moderation storage.Moderation) ([]*models.MessageV2, error) {
+	return []*models.MessageV2{}, nil
+}
+
+func (s *fakeMessageStore) GetModerationQueue(ctx context.Context, queryParameters mongoclient.QueryParameters) ([]*models.MessageV2, error) {
+	s.queries = append(s.queries, queryParameters)
+	return []*models.MessageV2{}, nil
+}
+
+func (s *fakeMessageStore) ErasePlayerMessages(ctx context.Context, erasure storage.Erasure) (int64, error) {
+	return 0, nil
+}
+
+func (s *fakeMessageStore) StreamPlayerMessages(
+	ctx context.Context,
+	queryParameters mongoclient.QueryParameters,
+	emit func(*models.MessageV2) error,
+) error {
+	for _, message := range s.streamed {
+		if err := emit(message); err != nil {
+			return err
+		}
+	}
+	return s.streamErr
+}
+
+func TestMessageStore(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("MessageStore", func() {
+		g.It("It should use the message store selected by storage.type", func() {
+			defer viper.Set("storage.type", nil)
+
+			viper.Set("storage.type", "mongo")
+			a := GetDefaultTestApp()
+			_, ok := a.MessageStore.(*storage.MongoMessageStore)
+			g.Assert(ok).IsTrue()
+			_, ok = a.ACLStore.(*storage.MongoACLStore)
+			g.Assert(ok).IsTrue()
+
+			viper.Set("storage.type", "memory")
+			a = GetDefaultTestApp()
+			_, ok = a.MessageStore.(*storage.MemoryMessageStore)
+			g.Assert(ok).IsTrue()
+			_, ok = a.ACLStore.(*storage.MemoryACLStore)
+			g.Assert(ok).IsTrue()
+		})
+
+		g.It("It should panic if the storage type is unknown", func() {
+			viper.Set("storage.type", "unknown")
+			defer viper.Set("storage.type", nil)
+
+			Expect(func() { GetDefaultTestApp() }).To(Panic())
+		})
+
+		g.It("It should query the injected message store", func() {
+			viper.Set("mongo.allow_anonymous", true)
+			defer viper.Set("mongo.allow_anonymous", false)
+
+			a := GetDefaultTestApp()
+			fake := &fakeMessageStore{}
+			a.MessageStore = fake
+
+			status, body := Get(a, "/v2/history/chat/fake?userid=test:test&limit=5", t)
+			g.Assert(status).Equal(http.StatusOK)
+
+			var messages []models.MessageV2
+			err := json.Unmarshal([]byte(body), &messages)
+			Expect(err).To(BeNil())
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Id).Equal("fake")
+			g.Assert(len(fake.queries)).Equal(1)
+			g.Assert(fake.queries[0].Topic).Equal("chat/fake")
+			g.Assert(fake.queries[0].Limit).Equal(int64(5))
+			g.Assert(fake.queries[0].Collection).Equal(a.Defaults.MongoMessagesCollection)
+		})
+	})
+}
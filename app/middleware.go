@@ -7,6 +7,7 @@ import (
 
 	"github.com/getsentry/raven-go"
 	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
 	"github.com/uber-go/zap"
 )
 
@@ -18,6 +19,29 @@ func VersionMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// RequestIDHeader is the header holding the ID of the request
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDMiddleware keeps the ID sent in the X-Request-ID header, or
+// generates one, sets it in the request context and the response header
+func RequestIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		requestID := c.Request().Header().Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.NewV4().String()
+		}
+		c.Set("requestID", requestID)
+		c.Response().Header().Set(RequestIDHeader, requestID)
+		return next(c)
+	}
+}
+
+// RequestID returns the ID of the request set by the RequestIDMiddleware
+func RequestID(c echo.Context) string {
+	requestID, _ := c.Get("requestID").(string)
+	return requestID
+}
+
 // NewRecoveryMiddleware returns a configured middleware
 func NewRecoveryMiddleware(onError func(interface{}, []byte)) *RecoveryMiddleware {
 	return &RecoveryMiddleware{
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+// moderationRequest is the body of the block and unblock requests
+type moderationRequest struct {
+	IDs    []string `json:"ids"`
+	Reason string   `json:"reason"`
+	// Moderator defaults to the name of the operator
+	Moderator string `json:"moderator"`
+}
+
+// ModerationResponse lists the ids of the moderated messages, and those
+// of the messages that were not found
+type ModerationResponse struct {
+	Moderated []string `json:"moderated"`
+	Missing   []string `json:"missing"`
+}
+
+// BlockMessagesHandler is the handler responsible for blocking messages
+func BlockMessagesHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "BlockMessages")
+		return moderateMessages(c, app, true)
+	}
+}
+
+// UnblockMessagesHandler is the handler responsible for unblocking messages
+func UnblockMessagesHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "UnblockMessages")
+		return moderateMessages(c, app, false)
+	}
+}
+
+// ModerationHistoryHandler is the handler responsible for listing the
+// moderation events of a message of the game of the request, newest first
+func ModerationHistoryHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "ModerationHistory")
+		messageID, err := url.PathUnescape(c.Param("id"))
+		if err != nil || messageID == "" {
+			if err == nil {
+				err = errors.New("the message id is empty")
+			}
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting id parameter.")
+		}
+
+		limit, _ := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+		if limit <= 0 || limit > app.Defaults.LimitOfMessages {
+			limit = app.Defaults.LimitOfMessages
+		}
+
+		events, err := app.ModerationStore.FindModerationEvents(c, GameID(c), messageID, limit)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, events)
+	}
+}
+
+// moderateMessages sets the blocked flag of the messages of the request,
+// marks them as reviewed and records the change in the moderation history
+func moderateMessages(c echo.Context, app *App, blocked bool) error {
+	body, err := parseModerationRequest(c, app.Config.GetInt("moderation.maxMessages"))
+	if err != nil {
+		logger.Logger.Warningf("Error: %s", err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, "Error getting ids parameter.")
+	}
+
+	operator := CurrentOperator(c)
+	if body.Moderator == "" {
+		body.Moderator = operator.Name
+	}
+
+	messages, err := app.MessageStore.ModerateMessages(c, storage.Moderation{
+		Collection: app.TenantDefaults(c).MongoMessagesCollection,
+		GameID:     GameID(c),
+		IDs:        body.IDs,
+		Blocked:    blocked,
+	})
+	if err != nil {
+		return err
+	}
+
+	action := models.ModerationUnblock
+	if blocked {
+		action = models.ModerationBlock
+	}
+	now := time.Now().Unix()
+	moderated := make(map[string]bool, len(messages))
+	events := make([]models.ModerationEvent, 0, len(messages))
+	for _, message := range messages {
+		if moderated[message.Id] {
+			continue
+		}
+		moderated[message.Id] = true
+		events = append(events, models.ModerationEvent{
+			Id:        uuid.NewV4().String(),
+			MessageId: message.Id,
+			GameId:    message.GameId,
+			Topic:     message.Topic,
+			PlayerId:  message.PlayerId,
+			Action:    action,
+			Reason:    body.Reason,
+			Moderator: body.Moderator,
+			Operator:  operator.Name,
+			RequestId: RequestID(c),
+			Timestamp: now,
+		})
+	}
+	if err := app.ModerationStore.InsertModerationEvents(c, events); err != nil {
+		return err
+	}
+	// the reviewed messages leave the queue, along with their claims, which
+	// are kept by game
+	reviewed := make(map[string][]string)
+	for _, message := range messages {
+		reviewed[message.GameId] = append(reviewed[message.GameId], message.Id)
+	}
+	for gameID, ids := range reviewed {
+		if err := app.ModerationStore.ReleaseMessages(c, gameID, "", ids); err != nil {
+			return err
+		}
+	}
+	logger.Logger.Infof("Operator %s %sed %d messages.", operator.Name, action, len(events))
+
+	response := ModerationResponse{Moderated: make([]string, 0), Missing: make([]string, 0)}
+	for _, id := range body.IDs {
+		if moderated[id] {
+			response.Moderated = append(response.Moderated, id)
+		} else {
+			response.Missing = append(response.Missing, id)
+		}
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// parseModerationRequest returns the body of the block and unblock
+// requests, holding between one and maxMessages unique ids
+func parseModerationRequest(c echo.Context, maxMessages int) (*moderationRequest, error) {
+	var body moderationRequest
+	if err := c.Bind(&body); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(body.IDs))
+	ids := make([]string, 0, len(body.IDs))
+	for _, id := range body.IDs {
+		if id == "" {
+			return nil, errors.New("empty message id")
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no message ids")
+	}
+	if maxMessages > 0 && len(ids) > maxMessages {
+		return nil, fmt.Errorf("more than %d message ids", maxMessages)
+	}
+	body.IDs = ids
+	return &body, nil
+}
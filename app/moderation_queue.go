@@ -0,0 +1,281 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+)
+
+// queueScanPages bounds the pages of the queue read to fill a page of
+// messages that are not claimed by other moderators
+const queueScanPages = 5
+
+// ModerationQueueItem is a message of the moderation queue, along with the
+// lease of the operator who claimed it, if any
+type ModerationQueueItem struct {
+	*models.MessageV2
+	ClaimedBy      string `json:"claimed_by,omitempty"`
+	ClaimModerator string `json:"claim_moderator,omitempty"`
+	ClaimExpiresAt int64  `json:"claim_expires_at,omitempty"`
+}
+
+// releaseRequest is the body of the release requests
+type releaseRequest struct {
+	GameID string   `json:"gameId"`
+	IDs    []string `json:"ids"`
+}
+
+// ModerationQueueHandler is the handler responsible for listing the
+// messages to review, oldest first, skipping those claimed by other operators
+func ModerationQueueHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "ModerationQueue")
+		return moderationQueue(c, app, false)
+	}
+}
+
+// ClaimModerationQueueHandler is the handler responsible for leasing the
+// next messages to review to the operator
+func ClaimModerationQueueHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "ClaimModerationQueue")
+		return moderationQueue(c, app, true)
+	}
+}
+
+// ReleaseModerationQueueHandler is the handler responsible for dropping the
+// operator's leases on messages, returning them to the queue
+func ReleaseModerationQueueHandler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "ReleaseModerationQueue")
+		var body releaseRequest
+		err := c.Bind(&body)
+		if err == nil && len(body.IDs) == 0 {
+			err = errors.New("no message ids")
+		}
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting ids parameter.")
+		}
+		// the claims are kept by game, those of every game being released
+		// when the request names none
+		gameID := GameID(c)
+		if body.GameID != "" && gameID != "" && body.GameID != gameID {
+			logger.Logger.Warningf("Error: the gameId is not the game of the request")
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting gameId parameter.")
+		}
+		if body.GameID != "" {
+			gameID = body.GameID
+		}
+
+		if err := app.ModerationStore.ReleaseMessages(c, gameID, CurrentOperator(c).Name, body.IDs); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// claimKey identifies the claims of the queue, kept by game
+type claimKey struct {
+	gameID    string
+	messageID string
+}
+
+// moderationQueue answers a page of the queue, claiming its messages for
+// the operator if asked to. The leases belong to the operator of the API
+// key, the moderator parameter only annotates them
+func moderationQueue(c echo.Context, app *App, claim bool) error {
+	queryParameters, param, err := parseModerationQueueParams(c, app)
+	if err != nil {
+		logger.Logger.Warningf("Error: %s", err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, fmt.Sprintf("Error getting %s parameter.", param))
+	}
+	operator := CurrentOperator(c).Name
+	moderator := c.QueryParam("moderator")
+
+	limit := queryParameters.Limit
+	now := time.Now().Unix()
+	expiresAt := now + int64(app.Config.GetInt("moderation.leaseDuration"))
+	items := make([]ModerationQueueItem, 0, limit)
+	var last *models.MessageV2
+	exhausted := false
+	for page := 0; page < queueScanPages && int64(len(items)) < limit; page++ {
+		messages, err := app.MessageStore.GetModerationQueue(c, queryParameters)
+		if err != nil {
+			return err
+		}
+
+		available, err := unclaimedMessages(c, app, messages, operator, now)
+		if err != nil {
+			return err
+		}
+		for len(available) > 0 && int64(len(items)) < limit {
+			batch := available
+			if needed := limit - int64(len(items)); int64(len(batch)) > needed {
+				batch = batch[:needed]
+			}
+			available = available[len(batch):]
+			last = batch[len(batch)-1].MessageV2
+
+			if claim {
+				batch, err = claimItems(c, app, batch, operator, moderator, now, expiresAt)
+				if err != nil {
+					return err
+				}
+			}
+			items = append(items, batch...)
+		}
+		if len(available) == 0 && len(messages) > 0 {
+			// the whole page was scanned
+			last = messages[len(messages)-1]
+		}
+		// the scan is over once a short page was read to its end, the
+		// messages it still holds being left for the next cursor
+		exhausted = int64(len(messages)) < limit && len(available) == 0
+		if exhausted {
+			break
+		}
+		cursor := models.CursorOf(last)
+		queryParameters.Cursor = &cursor
+	}
+
+	if !exhausted && last != nil {
+		cursor := models.CursorOf(last)
+		c.Response().Header().Set(NextCursorHeader, cursor.Encode())
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+// unclaimedMessages returns the messages that are not claimed by another
+// operator, with the operator's own leases
+func unclaimedMessages(
+	c echo.Context,
+	app *App,
+	messages []*models.MessageV2,
+	operator string,
+	now int64,
+) ([]ModerationQueueItem, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	// the claims are kept by game, two games possibly sharing message ids
+	idsByGame := make(map[string][]string)
+	for _, message := range messages {
+		idsByGame[message.GameId] = append(idsByGame[message.GameId], message.Id)
+	}
+	leases := make(map[claimKey]models.ModerationClaim)
+	for gameID, ids := range idsByGame {
+		claims, err := app.ModerationStore.FindClaims(c, gameID, ids, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, claim := range claims {
+			leases[claimKey{gameID: gameID, messageID: claim.MessageId}] = claim
+		}
+	}
+
+	items := make([]ModerationQueueItem, 0, len(messages))
+	for _, message := range messages {
+		lease, claimed := leases[claimKey{gameID: message.GameId, messageID: message.Id}]
+		if claimed && lease.Operator != operator {
+			continue
+		}
+		items = append(items, ModerationQueueItem{
+			MessageV2:      message,
+			ClaimedBy:      lease.Operator,
+			ClaimModerator: lease.Moderator,
+			ClaimExpiresAt: lease.ExpiresAt,
+		})
+	}
+	return items, nil
+}
+
+// claimItems leases the items to the operator and returns those it could
+// claim, the others having been claimed by another operator meanwhile
+func claimItems(
+	c echo.Context,
+	app *App,
+	items []ModerationQueueItem,
+	operator, moderator string,
+	now, expiresAt int64,
+) ([]ModerationQueueItem, error) {
+	idsByGame := make(map[string][]string)
+	for _, item := range items {
+		idsByGame[item.GameId] = append(idsByGame[item.GameId], item.Id)
+	}
+	claimed := make(map[claimKey]bool, len(items))
+	for gameID, ids := range idsByGame {
+		claimedIDs, err := app.ModerationStore.ClaimMessages(c, gameID, operator, moderator, ids, now, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range claimedIDs {
+			claimed[claimKey{gameID: gameID, messageID: id}] = true
+		}
+	}
+
+	results := make([]ModerationQueueItem, 0, len(items))
+	for _, item := range items {
+		if claimed[claimKey{gameID: item.GameId, messageID: item.Id}] {
+			item.ClaimedBy = operator
+			item.ClaimModerator = moderator
+			item.ClaimExpiresAt = expiresAt
+			results = append(results, item)
+		}
+	}
+	return results, nil
+}
+
+// parseModerationQueueParams returns the filters of the queue: gameId,
+// topic, playerId, initialDate, finalDate, cursor and limit, capped to the
+// limit of messages of the game. On error, it also returns the name of the
+// invalid parameter
+func parseModerationQueueParams(c echo.Context, app *App) (mongoclient.QueryParameters, string, error) {
+	defaults := app.TenantDefaults(c)
+	queryParameters := mongoclient.QueryParameters{
+		Collection: defaults.MongoMessagesCollection,
+		GameID:     GameID(c),
+		Topic:      c.QueryParam("topic"),
+		PlayerID:   c.QueryParam("playerId"),
+	}
+
+	if gameID := c.QueryParam("gameId"); gameID != "" {
+		if queryParameters.GameID != "" && queryParameters.GameID != gameID {
+			return queryParameters, "gameId", errors.New("the gameId is not the game of the request")
+		}
+		queryParameters.GameID = gameID
+	}
+
+	queryParameters.Limit, _ = strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+	if queryParameters.Limit <= 0 || queryParameters.Limit > defaults.LimitOfMessages {
+		queryParameters.Limit = defaults.LimitOfMessages
+	}
+
+	var err error
+	if initialDate := c.QueryParam("initialDate"); initialDate != "" {
+		queryParameters.From, err = transformDate(initialDate, true)
+		if err != nil {
+			return queryParameters, "initialDate", err
+		}
+	}
+	if finalDate := c.QueryParam("finalDate"); finalDate != "" {
+		queryParameters.To, err = transformDate(finalDate, false)
+		if err != nil {
+			return queryParameters, "finalDate", err
+		}
+	}
+
+	queryParameters.Cursor, err = ParseCursorQueryParam(c)
+	if err != nil {
+		return queryParameters, "cursor", err
+	}
+	return queryParameters, "", nil
+}
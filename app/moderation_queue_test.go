@@ -0,0 +1,200 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/models"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestModerationQueueHandlers(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Moderation queue", func() {
+		ctx := context.Background()
+		a := GetDefaultTestApp()
+		var topic string
+		var ids []string
+
+		g.BeforeEach(func() {
+			topic = fmt.Sprintf("chat/test_%s", strings.Replace(uuid.NewV4().String(), "-", "", -1))
+			ids = []string{uuid.NewV4().String(), uuid.NewV4().String(), uuid.NewV4().String()}
+			now := time.Now().Unix()
+			err := InsertTestMessages(ctx, []*models.MessageV2{
+				{Id: ids[2], Topic: topic, PlayerId: "p2", Timestamp: now - 1, ShouldModerate: true},
+				{Id: ids[0], Topic: topic, PlayerId: "p1", Timestamp: now - 3, ShouldModerate: true},
+				{Id: ids[1], Topic: topic, PlayerId: "p1", Timestamp: now - 2, ShouldModerate: true},
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: "p1", Timestamp: now - 2},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		queueAs := func(apiKey, method, query string) ([]app.ModerationQueueItem, string) {
+			var status int
+			var body, cursor string
+			if method == http.MethodPost {
+				status, body = PostJSONAsOperator(a, "/moderation/v2/queue/claim?"+query, "", apiKey, t)
+			} else {
+				var headers http.Header
+				status, body, headers = GetWithHeaders(a, "/moderation/v2/queue?"+query,
+					map[string]string{"X-API-Key": apiKey}, t)
+				cursor = headers.Get(app.NextCursorHeader)
+			}
+			g.Assert(status).Equal(http.StatusOK)
+			var items []app.ModerationQueueItem
+			Expect(json.Unmarshal([]byte(body), &items)).To(BeNil())
+			return items, cursor
+		}
+
+		queue := func(method, query string) ([]app.ModerationQueueItem, string) {
+			return queueAs(ModeratorAPIKey, method, query)
+		}
+
+		idsOf := func(items []app.ModerationQueueItem) []string {
+			result := make([]string, len(items))
+			for i, item := range items {
+				result[i] = item.Id
+			}
+			return result
+		}
+
+		g.It("It should return 401 without an API key and 403 without the moderation:write role", func() {
+			status, _ := GetAsOperator(a, "/moderation/v2/queue", "", t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+
+			status, _ = GetAsOperator(a, "/moderation/v2/queue", SupportBlockedAPIKey, t)
+			g.Assert(status).Equal(http.StatusForbidden)
+		})
+
+		g.It("It should list the messages to moderate oldest first, filtered and paged", func() {
+			items, _ := queue(http.MethodGet, "topic="+topic)
+			g.Assert(idsOf(items)).Equal(ids)
+
+			items, _ = queue(http.MethodGet, fmt.Sprintf("topic=%s&playerId=p1", topic))
+			g.Assert(idsOf(items)).Equal(ids[:2])
+
+			items, cursor := queue(http.MethodGet, fmt.Sprintf("topic=%s&limit=2", topic))
+			g.Assert(idsOf(items)).Equal(ids[:2])
+			g.Assert(cursor == "").IsFalse()
+
+			items, cursor = queue(http.MethodGet, fmt.Sprintf("topic=%s&limit=2&cursor=%s", topic, cursor))
+			g.Assert(idsOf(items)).Equal(ids[2:])
+			g.Assert(cursor).Equal("")
+
+			items, _ = queue(http.MethodGet, fmt.Sprintf("topic=%s&limit=1000000000", topic))
+			g.Assert(idsOf(items)).Equal(ids)
+		})
+
+		g.It("It should page past the messages left in a short page once the limit is filled", func() {
+			more := []string{uuid.NewV4().String(), uuid.NewV4().String()}
+			now := time.Now().Unix()
+			err := InsertTestMessages(ctx, []*models.MessageV2{
+				{Id: more[0], Topic: topic, PlayerId: "p3", Timestamp: now + 1, ShouldModerate: true},
+				{Id: more[1], Topic: topic, PlayerId: "p3", Timestamp: now + 2, ShouldModerate: true},
+			})
+			Expect(err).To(BeNil())
+			items, _ := queueAs(SecondModeratorAPIKey, http.MethodPost, fmt.Sprintf("topic=%s&limit=1", topic))
+			g.Assert(idsOf(items)).Equal(ids[:1])
+
+			// the second page is short and only its first message fits
+			items, cursor := queue(http.MethodGet, fmt.Sprintf("topic=%s&limit=3", topic))
+			g.Assert(idsOf(items)).Equal([]string{ids[1], ids[2], more[0]})
+			g.Assert(cursor == "").IsFalse()
+
+			items, cursor = queue(http.MethodGet, fmt.Sprintf("topic=%s&limit=3&cursor=%s", topic, cursor))
+			g.Assert(idsOf(items)).Equal(more[1:])
+			g.Assert(cursor).Equal("")
+		})
+
+		g.It("It should lease the claimed messages to the operator until released", func() {
+			items, _ := queue(http.MethodPost, fmt.Sprintf("topic=%s&limit=2&moderator=alice", topic))
+			g.Assert(idsOf(items)).Equal(ids[:2])
+			g.Assert(items[0].ClaimedBy).Equal("moderator")
+			g.Assert(items[0].ClaimModerator).Equal("alice")
+
+			items, _ = queueAs(SecondModeratorAPIKey, http.MethodGet, fmt.Sprintf("topic=%s&moderator=alice", topic))
+			g.Assert(idsOf(items)).Equal(ids[2:])
+
+			items, _ = queueAs(SecondModeratorAPIKey, http.MethodPost, fmt.Sprintf("topic=%s&moderator=bob", topic))
+			g.Assert(idsOf(items)).Equal(ids[2:])
+			g.Assert(items[0].ClaimedBy).Equal("second-moderator")
+
+			items, _ = queue(http.MethodGet, "topic="+topic)
+			g.Assert(idsOf(items)).Equal(ids[:2])
+			g.Assert(items[1].ClaimedBy).Equal("moderator")
+			g.Assert(items[1].ClaimExpiresAt > time.Now().Unix()).IsTrue()
+
+			// the leases of other operators are kept
+			body := fmt.Sprintf(`{"ids": ["%s", "%s"]}`, ids[0], ids[2])
+			status, _ := PostJSONAsOperator(a, "/moderation/v2/queue/release", body, ModeratorAPIKey, t)
+			g.Assert(status).Equal(http.StatusNoContent)
+
+			items, _ = queueAs(SecondModeratorAPIKey, http.MethodGet, "topic="+topic)
+			g.Assert(idsOf(items)).Equal([]string{ids[0], ids[2]})
+		})
+
+		g.It("It should lease apart the messages of two games sharing an id", func() {
+			shared := uuid.NewV4().String()
+			games := []string{uuid.NewV4().String(), uuid.NewV4().String()}
+			err := InsertTestMessages(ctx, []*models.MessageV2{
+				{Id: shared, Topic: topic + "/a", GameId: games[0], PlayerId: "p1", Timestamp: time.Now().Unix(), ShouldModerate: true},
+				{Id: shared, Topic: topic + "/b", GameId: games[1], PlayerId: "p1", Timestamp: time.Now().Unix(), ShouldModerate: true},
+			})
+			Expect(err).To(BeNil())
+
+			items, _ := queue(http.MethodPost, "gameId="+games[0])
+			g.Assert(idsOf(items)).Equal([]string{shared})
+			items, _ = queueAs(SecondModeratorAPIKey, http.MethodPost, "gameId="+games[1])
+			g.Assert(idsOf(items)).Equal([]string{shared})
+			g.Assert(items[0].ClaimedBy).Equal("second-moderator")
+
+			items, _ = queueAs(SecondModeratorAPIKey, http.MethodGet, "gameId="+games[0])
+			g.Assert(len(items)).Equal(0)
+
+			body := fmt.Sprintf(`{"gameId": "%s", "ids": ["%s"]}`, games[0], shared)
+			status, _ := PostJSONAsOperator(a, "/moderation/v2/queue/release", body, ModeratorAPIKey, t)
+			g.Assert(status).Equal(http.StatusNoContent)
+			items, _ = queueAs(SecondModeratorAPIKey, http.MethodGet, "gameId="+games[0])
+			g.Assert(idsOf(items)).Equal([]string{shared})
+			g.Assert(items[0].ClaimedBy).Equal("")
+		})
+
+		g.It("It should take the moderated messages out of the queue", func() {
+			body := fmt.Sprintf(`{"ids": ["%s"]}`, ids[1])
+			status, _ := PostJSONAsOperator(a, "/moderation/v2/block", body, ModeratorAPIKey, t)
+			g.Assert(status).Equal(http.StatusOK)
+
+			items, _ := queue(http.MethodGet, "topic="+topic)
+			g.Assert(idsOf(items)).Equal([]string{ids[0], ids[2]})
+		})
+
+		g.It("It should return 422 with invalid parameters", func() {
+			for _, query := range []string{"initialDate=yesterday", "finalDate=2020-13-01", "cursor=invalid"} {
+				status, _ := GetAsOperator(a, "/moderation/v2/queue?"+query, ModeratorAPIKey, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			}
+
+			status, _ := PostJSONAsOperator(a, "/moderation/v2/queue/release", `{"ids": []}`, ModeratorAPIKey, t)
+			g.Assert(status).Equal(http.StatusUnprocessableEntity)
+		})
+	})
+}
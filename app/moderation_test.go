@@ -0,0 +1,163 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/models"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestModerationHandlers(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Moderation", func() {
+		ctx := context.Background()
+		a := GetDefaultTestApp()
+		var topic string
+		var ids []string
+
+		g.BeforeEach(func() {
+			topic = fmt.Sprintf("chat/test_%s", strings.Replace(uuid.NewV4().String(), "-", "", -1))
+			err := a.ACLStore.InsertACLs(ctx, []app.ACL{{Username: "test:test", Pubsub: []string{topic}}})
+			Expect(err).To(BeNil())
+
+			ids = []string{uuid.NewV4().String(), uuid.NewV4().String()}
+			now := time.Now().Unix()
+			err = InsertTestMessages(ctx, []*models.MessageV2{
+				{Id: ids[0], Topic: topic, PlayerId: "p1", GameId: "game1", Timestamp: now - 2, ShouldModerate: true},
+				{Id: ids[1], Topic: topic, PlayerId: "p2", GameId: "game1", Timestamp: now - 1, ShouldModerate: true},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		history := func() []models.MessageV2 {
+			status, body := Get(a, fmt.Sprintf("/v2/history/%s?userid=test:test", topic), t)
+			g.Assert(status).Equal(http.StatusOK)
+			var messages []models.MessageV2
+			Expect(json.Unmarshal([]byte(body), &messages)).To(BeNil())
+			return messages
+		}
+
+		g.It("It should return 401 without an API key and 403 without the moderation:write role", func() {
+			body := fmt.Sprintf(`{"ids": ["%s"]}`, ids[0])
+			status, _ := PostJSONAsOperator(a, "/moderation/v2/block", body, "", t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+
+			status, _ = PostJSONAsOperator(a, "/moderation/v2/block", body, SupportBlockedAPIKey, t)
+			g.Assert(status).Equal(http.StatusForbidden)
+		})
+
+		g.It("It should block and unblock the messages and record the history", func() {
+			missing := uuid.NewV4().String()
+			body := fmt.Sprintf(`{"ids": ["%s", "%s"], "reason": "spam", "moderator": "alice"}`, ids[0], missing)
+			status, response := PostJSONAsOperator(a, "/moderation/v2/block", body, ModeratorAPIKey, t)
+			g.Assert(status).Equal(http.StatusOK)
+
+			var moderation app.ModerationResponse
+			Expect(json.Unmarshal([]byte(response), &moderation)).To(BeNil())
+			g.Assert(moderation.Moderated).Equal([]string{ids[0]})
+			g.Assert(moderation.Missing).Equal([]string{missing})
+
+			messages := history()
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Id).Equal(ids[1])
+			g.Assert(messages[0].ShouldModerate).IsTrue()
+
+			status, _ = PostJSONAsOperator(a, "/moderation/v2/unblock", fmt.Sprintf(`{"ids": ["%s"]}`, ids[0]), ModeratorAPIKey, t)
+			g.Assert(status).Equal(http.StatusOK)
+
+			messages = history()
+			g.Assert(len(messages)).Equal(2)
+			g.Assert(messages[1].Id).Equal(ids[0])
+			g.Assert(messages[1].Blocked).IsFalse()
+			g.Assert(messages[1].ShouldModerate).IsFalse()
+
+			status, response = GetAsOperator(a, fmt.Sprintf("/moderation/v2/history/%s", ids[0]), ModeratorAPIKey, t)
+			g.Assert(status).Equal(http.StatusOK)
+			var events []models.ModerationEvent
+			Expect(json.Unmarshal([]byte(response), &events)).To(BeNil())
+			g.Assert(len(events)).Equal(2)
+			actions := []string{events[0].Action, events[1].Action}
+			Expect(actions).To(ConsistOf(models.ModerationBlock, models.ModerationUnblock))
+			for _, event := range events {
+				g.Assert(event.Topic).Equal(topic)
+				g.Assert(event.PlayerId).Equal("p1")
+				g.Assert(event.Operator).Equal("moderator")
+				if event.Action == models.ModerationBlock {
+					g.Assert(event.Reason).Equal("spam")
+					g.Assert(event.Moderator).Equal("alice")
+				} else {
+					g.Assert(event.Moderator).Equal("moderator")
+				}
+			}
+		})
+
+		g.It("It should scope the history and the released claims to the game of the request", func() {
+			viper.Set("tenant.enabled", true)
+			defer viper.Set("tenant.enabled", false)
+			tenantApp := GetDefaultTestApp()
+			asGame := func(gameID string) map[string]string {
+				return map[string]string{"X-API-Key": ModeratorAPIKey, "X-Game-ID": gameID, "Content-Type": "application/json"}
+			}
+
+			now := time.Now().Unix()
+			claimed, err := a.ModerationStore.ClaimMessages(ctx, "game1", "moderator", "", ids, now, now+60)
+			Expect(err).To(BeNil())
+			g.Assert(claimed).Equal(ids)
+
+			body := fmt.Sprintf(`{"ids": ["%s", "%s"]}`, ids[0], ids[1])
+			status, _, _ := PostWithHeaders(tenantApp, "/moderation/v2/block", body, asGame("game2"), t)
+			g.Assert(status).Equal(http.StatusOK)
+			claims, err := a.ModerationStore.FindClaims(ctx, "game1", ids, now)
+			Expect(err).To(BeNil())
+			g.Assert(len(claims)).Equal(2)
+
+			body = fmt.Sprintf(`{"ids": ["%s"]}`, ids[0])
+			status, _, _ = PostWithHeaders(tenantApp, "/moderation/v2/block", body, asGame("game1"), t)
+			g.Assert(status).Equal(http.StatusOK)
+			claims, err = a.ModerationStore.FindClaims(ctx, "game1", ids, now)
+			Expect(err).To(BeNil())
+			g.Assert(len(claims)).Equal(1)
+			g.Assert(claims[0].MessageId).Equal(ids[1])
+
+			var events []models.ModerationEvent
+			url := fmt.Sprintf("/moderation/v2/history/%s", ids[0])
+			status, response, _ := GetWithHeaders(tenantApp, url, asGame("game2"), t)
+			g.Assert(status).Equal(http.StatusOK)
+			Expect(json.Unmarshal([]byte(response), &events)).To(BeNil())
+			g.Assert(len(events)).Equal(0)
+
+			status, response, _ = GetWithHeaders(tenantApp, url, asGame("game1"), t)
+			g.Assert(status).Equal(http.StatusOK)
+			Expect(json.Unmarshal([]byte(response), &events)).To(BeNil())
+			g.Assert(len(events)).Equal(1)
+		})
+
+		g.It("It should return 422 without message ids", func() {
+			for _, body := range []string{`{"ids": []}`, `{"ids": [""]}`, `{}`} {
+				status, _ := PostJSONAsOperator(a, "/moderation/v2/block", body, ModeratorAPIKey, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			}
+		})
+	})
+}
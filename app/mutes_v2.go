@@ -0,0 +1,141 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+)
+
+// ListMutesV2Handler is the handler responsible for sending the players
+// muted by the player
+func ListMutesV2Handler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "ListMutesV2")
+		userID := UserID(c)
+		if userID == "" {
+			// the mute lists are kept by player, legacy requests without
+			// a userid have none
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		mutes, err := app.MuteStore.GetMutedPlayers(c, GameID(c), userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, mutes)
+	}
+}
+
+// MuteV2Handler is the handler responsible for hiding the messages of a
+// player from the history of the player
+func MuteV2Handler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "MuteV2")
+		userID := UserID(c)
+		if userID == "" {
+			// the mute lists are kept by player, legacy requests without
+			// a userid have none
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		mutedPlayerID, err := parseMutedPlayerParam(c)
+		if err == nil && mutedPlayerID == userID {
+			err = errors.New("players cannot mute themselves")
+		}
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting playerId parameter.")
+		}
+
+		mutes, err := app.MuteStore.GetMutedPlayers(c, GameID(c), userID)
+		if err != nil {
+			return err
+		}
+		maxPlayers := app.Config.GetInt("mutes.maxPlayers")
+		if maxPlayers > 0 && len(mutes) >= maxPlayers && !isMuted(mutes, mutedPlayerID) {
+			logger.Logger.Warningf("Error: user %s already muted %d players", userID, len(mutes))
+			return c.JSON(http.StatusUnprocessableEntity, "Error: too many muted players.")
+		}
+
+		mute := models.MutedPlayer{
+			GameId:        GameID(c),
+			PlayerId:      userID,
+			MutedPlayerId: mutedPlayerID,
+			CreatedAt:     time.Now().Unix(),
+		}
+		if err := app.MuteStore.MutePlayer(c, mute); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, mute)
+	}
+}
+
+// UnmuteV2Handler is the handler responsible for showing again the messages
+// of a player in the history of the player
+func UnmuteV2Handler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "UnmuteV2")
+		userID := UserID(c)
+		if userID == "" {
+			// the mute lists are kept by player, legacy requests without
+			// a userid have none
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		mutedPlayerID, err := parseMutedPlayerParam(c)
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting playerId parameter.")
+		}
+
+		if err := app.MuteStore.UnmutePlayer(c, GameID(c), userID, mutedPlayerID); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// mutedPlayerIDs returns the ids of the players muted by the user in the
+// game of the request, whose messages are left out of the history queries
+// of the user
+func mutedPlayerIDs(c echo.Context, app *App, userID string) ([]string, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	mutes, err := app.MuteStore.GetMutedPlayers(c, GameID(c), userID)
+	if err != nil {
+		return nil, err
+	}
+	playerIDs := make([]string, len(mutes))
+	for i, mute := range mutes {
+		playerIDs[i] = mute.MutedPlayerId
+	}
+	return playerIDs, nil
+}
+
+// isMuted tells whether the player is in the mute list
+func isMuted(mutes []models.MutedPlayer, playerID string) bool {
+	for _, mute := range mutes {
+		if mute.MutedPlayerId == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMutedPlayerParam returns the unescaped playerId path parameter
+func parseMutedPlayerParam(c echo.Context) (string, error) {
+	playerID, err := url.PathUnescape(c.Param("playerId"))
+	if err != nil {
+		return "", err
+	}
+	if playerID == "" {
+		return "", errors.New("the player id is empty")
+	}
+	return playerID, nil
+}
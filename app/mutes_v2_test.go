@@ -0,0 +1,130 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/models"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestMutesV2Handlers(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("MutesV2", func() {
+		ctx := context.Background()
+		a := GetDefaultTestApp()
+		var userID, topic string
+
+		g.BeforeEach(func() {
+			userID = fmt.Sprintf("test:%s", uuid.NewV4().String())
+			topic = fmt.Sprintf("chat/test_%s", strings.Replace(uuid.NewV4().String(), "-", "", -1))
+			err := a.ACLStore.InsertACLs(ctx, []app.ACL{{Username: userID, Pubsub: []string{topic}}})
+			Expect(err).To(BeNil())
+		})
+
+		mutes := func() []string {
+			status, body := Get(a, "/v2/mutes?userid="+userID, t)
+			g.Assert(status).Equal(http.StatusOK)
+			var muted []models.MutedPlayer
+			Expect(json.Unmarshal([]byte(body), &muted)).To(BeNil())
+			playerIDs := make([]string, len(muted))
+			for i, mute := range muted {
+				g.Assert(mute.PlayerId).Equal(userID)
+				playerIDs[i] = mute.MutedPlayerId
+			}
+			return playerIDs
+		}
+
+		mute := func(playerID string) int {
+			status, _ := PutJSON(a, fmt.Sprintf("/v2/mutes/%s?userid=%s", playerID, userID), "", t)
+			return status
+		}
+
+		g.It("It should mute and unmute the players", func() {
+			g.Assert(mutes()).Equal([]string{})
+			g.Assert(mute("p2")).Equal(http.StatusOK)
+			g.Assert(mute("p1")).Equal(http.StatusOK)
+			g.Assert(mute("p1")).Equal(http.StatusOK)
+			g.Assert(mutes()).Equal([]string{"p1", "p2"})
+
+			status, _ := Delete(a, fmt.Sprintf("/v2/mutes/p1?userid=%s", userID), t)
+			g.Assert(status).Equal(http.StatusNoContent)
+			g.Assert(mutes()).Equal([]string{"p2"})
+		})
+
+		g.It("It should return 401 without a userid in legacy mode", func() {
+			status, _ := Get(a, "/v2/mutes", t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+			status, _ = PutJSON(a, "/v2/mutes/p1", "", t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+			status, _ = Delete(a, "/v2/mutes/p1", t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+		})
+
+		g.It("It should return 422 when muting oneself or too many players", func() {
+			g.Assert(mute(userID)).Equal(http.StatusUnprocessableEntity)
+
+			viper.Set("mutes.maxPlayers", 1)
+			defer viper.Set("mutes.maxPlayers", nil)
+			g.Assert(mute("p1")).Equal(http.StatusOK)
+			g.Assert(mute("p1")).Equal(http.StatusOK)
+			g.Assert(mute("p2")).Equal(http.StatusUnprocessableEntity)
+		})
+
+		g.It("It should leave the messages of the muted players out of the history, still filling limit", func() {
+			now := time.Now().Unix()
+			err := InsertTestMessages(ctx, []*models.MessageV2{
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: "p1", Timestamp: now - 1},
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: "p1", Timestamp: now - 2},
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: "p2", Timestamp: now - 3},
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: "p3", Timestamp: now - 4},
+				{Id: uuid.NewV4().String(), Topic: topic, PlayerId: "p2", Timestamp: now - 5},
+			})
+			Expect(err).To(BeNil())
+			g.Assert(mute("p1")).Equal(http.StatusOK)
+
+			status, body := Get(a, fmt.Sprintf("/v2/history/%s?userid=%s&limit=3", topic, userID), t)
+			g.Assert(status).Equal(http.StatusOK)
+			var messages []models.MessageV2
+			Expect(json.Unmarshal([]byte(body), &messages)).To(BeNil())
+			g.Assert(len(messages)).Equal(3)
+			for _, message := range messages {
+				g.Assert(message.PlayerId == "p1").IsFalse()
+			}
+
+			topicPrefix, topicSuffix := topic[:strings.LastIndex(topic, "/")], topic[strings.LastIndex(topic, "/")+1:]
+			status, body = Get(a, fmt.Sprintf("/v2/unread/%s?userid=%s&topics=%s&since=0", topicPrefix, userID, topicSuffix), t)
+			g.Assert(status).Equal(http.StatusOK)
+			var counts map[string]int64
+			Expect(json.Unmarshal([]byte(body), &counts)).To(BeNil())
+			g.Assert(counts[topic]).Equal(int64(3))
+
+			status, _ = Delete(a, fmt.Sprintf("/v2/mutes/p1?userid=%s", userID), t)
+			g.Assert(status).Equal(http.StatusNoContent)
+			status, body = Get(a, fmt.Sprintf("/v2/history/%s?userid=%s&limit=3", topic, userID), t)
+			g.Assert(status).Equal(http.StatusOK)
+			Expect(json.Unmarshal([]byte(body), &messages)).To(BeNil())
+			g.Assert(messages[0].PlayerId).Equal("p1")
+		})
+	})
+}
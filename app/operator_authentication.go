@@ -0,0 +1,86 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+const (
+	operatorContextKey = "operator"
+	// APIKeyHeader is the header holding the operators' API key
+	APIKeyHeader = "X-API-Key"
+)
+
+// OperatorAuthenticationMiddleware authenticates the player support operators
+// by their API key, sent in the X-API-Key header or as a bearer token
+type OperatorAuthenticationMiddleware struct {
+	Store storage.OperatorStore
+	// Roles are the roles required by every route of the middleware
+	Roles []string
+}
+
+// NewOperatorAuthenticationMiddleware returns a middleware requiring the roles
+func NewOperatorAuthenticationMiddleware(store storage.OperatorStore, roles ...string) *OperatorAuthenticationMiddleware {
+	return &OperatorAuthenticationMiddleware{
+		Store: store,
+		Roles: roles,
+	}
+}
+
+// Serve rejects with 401 the requests without a known API key and with
+// 403 those whose operator lacks one of the roles
+func (m *OperatorAuthenticationMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := operatorAPIKey(c)
+		if key == "" {
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		operator, err := m.Store.FindOperator(c, models.HashAPIKey(key))
+		if err != nil {
+			return err
+		}
+		if operator == nil {
+			logger.Logger.Warning("Unknown operator API key.")
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		for _, role := range m.Roles {
+			if !operator.HasRole(role) {
+				return forbidden(c, operator, role)
+			}
+		}
+
+		c.Set(operatorContextKey, operator)
+		return next(c)
+	}
+}
+
+func operatorAPIKey(c echo.Context) string {
+	if key := c.Request().Header().Get(APIKeyHeader); key != "" {
+		return key
+	}
+
+	const prefix = "bearer "
+	authorization := c.Request().Header().Get(echo.HeaderAuthorization)
+	if len(authorization) > len(prefix) && strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return strings.TrimSpace(authorization[len(prefix):])
+	}
+	return ""
+}
+
+func forbidden(c echo.Context, operator *models.Operator, role string) error {
+	logger.Logger.Warningf("Operator %s lacks the %s role.", operator.Name, role)
+	return c.String(http.StatusForbidden, http.StatusText(http.StatusForbidden))
+}
+
+// CurrentOperator returns the operator authenticated by the OperatorAuthenticationMiddleware
+func CurrentOperator(c echo.Context) *models.Operator {
+	operator, _ := c.Get(operatorContextKey).(*models.Operator)
+	return operator
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -9,8 +10,7 @@ import (
 	"github.com/topfreegames/mqtt-history/logger"
 )
 
-func ParseHistoryQueryParams(c echo.Context, defaultLimit int64) (string, int64, int64, bool) {
-	userID := c.QueryParam("userid")
+func ParseHistoryQueryParams(c echo.Context, defaultLimit int64) (int64, int64, bool) {
 	from, _ := strconv.ParseInt(c.QueryParam("from"), 10, 64)
 	limit, _ := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
 	isBlocked, err := strconv.ParseBool(c.QueryParam("isBlocked"))
@@ -26,14 +26,13 @@ func ParseHistoryQueryParams(c echo.Context, defaultLimit int64) (string, int64,
 	if err != nil {
 		// If it returns error, it will assume the default behavior(e.g. isBlocked=false).
 		logger.Logger.Debugf("Assuming the default behavior: isBlocked=false. Message: %s", err.Error())
-		return userID, from, limit, false
+		return from, limit, false
 	}
 
-	return userID, from, limit, isBlocked
+	return from, limit, isBlocked
 }
 
-func ParseHistoriesQueryParams(c echo.Context, defaultLimit int64) ([]string, string, int64, int64) {
-	userID := c.QueryParam("userid")
+func ParseHistoriesQueryParams(c echo.Context, defaultLimit int64) ([]string, int64, int64) {
 	topicsSuffix := strings.Split(c.QueryParam("topics"), ",")
 	from, _ := strconv.ParseInt(c.QueryParam("from"), 10, 64)
 	limit, _ := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
@@ -46,11 +45,10 @@ func ParseHistoriesQueryParams(c echo.Context, defaultLimit int64) ([]string, st
 		from = time.Now().Unix()
 	}
 
-	return topicsSuffix, userID, from, limit
+	return topicsSuffix, from, limit
 }
 
-func ParseHistoryPSQueryParams(c echo.Context, defaultLimit int64) (string, string, string, int64, bool) {
-	userID := c.QueryParam("userid")
+func ParseHistoryPSQueryParams(c echo.Context, defaultLimit int64) (string, string, int64, bool) {
 	playerId := c.QueryParam("playerId")
 	topic := c.QueryParam("topic")
 	limit, _ := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
@@ -58,5 +56,54 @@ func ParseHistoryPSQueryParams(c echo.Context, defaultLimit int64) (string, stri
 		limit = defaultLimit
 	}
 	isBlocked, _ := strconv.ParseBool(c.QueryParam("isBlocked"))
-	return userID, playerId, topic, limit, isBlocked
+	return playerId, topic, limit, isBlocked
+}
+
+// ParseForwardQueryParams returns whether the messages are read forward, i.e. newer
+// than since, and the since timestamp. Sending since without a direction reads forward
+func ParseForwardQueryParams(c echo.Context) (bool, int64, error) {
+	direction := c.QueryParam("direction")
+	sinceParam := c.QueryParam("since")
+	since, err := strconv.ParseInt(sinceParam, 10, 64)
+	if sinceParam != "" && err != nil {
+		return false, 0, fmt.Errorf("invalid since: %s", sinceParam)
+	}
+
+	switch direction {
+	case "forward":
+		return true, since, nil
+	case "backward":
+		return false, 0, nil
+	case "":
+		return sinceParam != "", since, nil
+	default:
+		return false, 0, fmt.Errorf("invalid direction: %s", direction)
+	}
+}
+
+// ParseSinceQueryParam returns the since marker of each of the n topics. since is either
+// a single timestamp, applied to every topic, or one timestamp per topic separated by commas
+func ParseSinceQueryParam(c echo.Context, n int) ([]int64, error) {
+	sinceParam := c.QueryParam("since")
+	markers := make([]int64, n)
+	if sinceParam == "" {
+		return markers, nil
+	}
+
+	values := strings.Split(sinceParam, ",")
+	if len(values) != 1 && len(values) != n {
+		return nil, fmt.Errorf("expected 1 or %d since values, got %d", n, len(values))
+	}
+	for i := range markers {
+		value := values[0]
+		if len(values) == n {
+			value = values[i]
+		}
+		since, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since: %s", value)
+		}
+		markers[i] = since
+	}
+	return markers, nil
 }
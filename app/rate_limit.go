@@ -0,0 +1,112 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/ratelimit"
+)
+
+// The keys of the rate limits
+const (
+	// RateLimitByUser limits each authenticated player or operator, and
+	// each IP for the anonymous requests
+	RateLimitByUser = "user"
+	// RateLimitByIP limits each client IP
+	RateLimitByIP = "ip"
+	// RateLimitByGame limits each game, resolved by the TenantMiddleware or
+	// else sent in the X-Game-ID header, and each IP for the requests
+	// without it
+	RateLimitByGame = "game"
+)
+
+// GameIDHeader is the default header holding the game ID of the requests
+const GameIDHeader = "X-Game-ID"
+
+// ThrottledRequests counts the requests rejected by the rate limits. It is
+// registered at init, like the other collectors, to stay test-safe
+var ThrottledRequests = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "http_throttled_requests_total",
+		Help: "Requests rejected by the rate limits.",
+	},
+	[]string{"route", "keyBy"},
+)
+
+// RouteRateLimit is the rate limit of a route
+type RouteRateLimit struct {
+	KeyBy           string `mapstructure:"keyBy"`
+	ratelimit.Limit `mapstructure:",squash"`
+}
+
+// RateLimitMiddleware rejects with 429 the requests of the clients that
+// emptied their token bucket for the route
+type RateLimitMiddleware struct {
+	Limiter ratelimit.Limiter
+	Route   string
+	Limit   RouteRateLimit
+	// TrustForwardedFor takes the client IP from the X-Forwarded-For and
+	// X-Real-IP headers, set by a trusted proxy
+	TrustForwardedFor bool
+}
+
+// Serve takes a token from the bucket of the client. The requests are let
+// through if the limiter fails
+func (m *RateLimitMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := fmt.Sprintf("%s:%s:%s", m.Route, m.Limit.KeyBy, m.clientKey(c))
+		allowed, retryAfter, err := m.Limiter.Allow(c, key, m.Limit.Limit)
+		if err != nil {
+			logger.Logger.Errorf("Failed to check the rate limit: %s", err.Error())
+			return next(c)
+		}
+		if allowed {
+			return next(c)
+		}
+
+		ThrottledRequests.WithLabelValues(m.Route, m.Limit.KeyBy).Inc()
+		seconds := int64(math.Ceil(retryAfter.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		return c.String(http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+	}
+}
+
+func (m *RateLimitMiddleware) clientKey(c echo.Context) string {
+	switch m.Limit.KeyBy {
+	case RateLimitByUser:
+		if userID := UserID(c); userID != "" {
+			return "user:" + userID
+		}
+		if operator := CurrentOperator(c); operator != nil {
+			return "operator:" + operator.Name
+		}
+	case RateLimitByGame:
+		// only the resolved game counts, a client-chosen header would let
+		// it pick its bucket
+		if gameID := GameID(c); gameID != "" {
+			return "game:" + gameID
+		}
+	}
+	return "ip:" + m.clientIP(c)
+}
+
+func (m *RateLimitMiddleware) clientIP(c echo.Context) string {
+	if m.TrustForwardedFor {
+		return c.Request().RealIP()
+	}
+	address := c.Request().RemoteAddress()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
@@ -0,0 +1,70 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	goblin "github.com/franela/goblin"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("RateLimit", func() {
+		g.AfterEach(func() {
+			viper.Set("rateLimit.enabled", false)
+		})
+
+		g.It("It should answer 429 with Retry-After once the user emptied its bucket", func() {
+			viper.Set("rateLimit.enabled", true)
+			viper.Set("rateLimit.default", map[string]interface{}{"keyBy": "ip", "rate": 0})
+			viper.Set("rateLimit.routes.HistoryV2", map[string]interface{}{"keyBy": "user", "rate": 0.01, "burst": 2})
+			a := GetDefaultTestApp()
+
+			userID := fmt.Sprintf("test:%s", uuid.NewV4().String())
+			path := fmt.Sprintf("/v2/history/chat/test_%s?userid=%s", uuid.NewV4().String(), userID)
+			for i := 0; i < 2; i++ {
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+			}
+
+			status, _, headers := GetWithHeaders(a, path, nil, t)
+			g.Assert(status).Equal(http.StatusTooManyRequests)
+			g.Assert(headers.Get("Retry-After")).Equal("100")
+
+			// other users and routes are not limited
+			otherPath := fmt.Sprintf("/v2/history/chat/test_%s?userid=other:%s", uuid.NewV4().String(), uuid.NewV4().String())
+			status, _ = Get(a, otherPath, t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+			for i := 0; i < 3; i++ {
+				status, _ = Get(a, fmt.Sprintf("/v2/unread/chat/test?userid=%s", userID), t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+			}
+		})
+
+		g.It("It should limit by IP, not by the X-Game-ID header, the game requests without multi-tenancy", func() {
+			viper.Set("rateLimit.enabled", true)
+			viper.Set("rateLimit.default", map[string]interface{}{"keyBy": "ip", "rate": 0})
+			viper.Set("rateLimit.routes.UnreadV2", map[string]interface{}{"keyBy": "game", "rate": 0.01, "burst": 1})
+			defer viper.Set("rateLimit.routes.UnreadV2", nil)
+			a := GetDefaultTestApp()
+
+			path := fmt.Sprintf("/v2/unread/chat/test?userid=test:%s", uuid.NewV4().String())
+			status, _, _ := GetWithHeaders(a, path, map[string]string{"X-Game-ID": uuid.NewV4().String()}, t)
+			g.Assert(status).Equal(http.StatusUnauthorized)
+
+			status, _, _ = GetWithHeaders(a, path, map[string]string{"X-Game-ID": uuid.NewV4().String()}, t)
+			g.Assert(status).Equal(http.StatusTooManyRequests)
+		})
+	})
+}
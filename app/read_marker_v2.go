@@ -0,0 +1,171 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+)
+
+// readMarkerRequest is the body of the set read marker requests
+type readMarkerRequest struct {
+	Timestamp int64  `json:"timestamp"`
+	MessageId string `json:"message_id"`
+}
+
+// TopicReadState is the read marker of the player on a topic, nil if the
+// player never read it, along with the number of messages sent after it
+type TopicReadState struct {
+	Marker *models.ReadMarker `json:"marker"`
+	Unread int64              `json:"unread"`
+}
+
+// SetReadMarkerV2Handler is the handler responsible for storing the position
+// up to which the player has read a room
+func SetReadMarkerV2Handler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "SetReadMarkerV2")
+		topic := c.ParamValues()[0]
+		userID := UserID(c)
+		if userID == "" {
+			// the markers are kept by player, legacy requests without
+			// a userid have none
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		var body readMarkerRequest
+		if err := c.Bind(&body); err != nil || body.Timestamp <= 0 {
+			if err != nil {
+				logger.Logger.Warningf("Error: %s", err.Error())
+			}
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting timestamp parameter.")
+		}
+
+		authenticated, _, err := IsAuthorized(c.StdContext(), app, userID, topic)
+		if err != nil {
+			return err
+		}
+
+		if !authenticated {
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		marker := models.ReadMarker{
+			GameId:    GameID(c),
+			PlayerId:  userID,
+			Topic:     topic,
+			Timestamp: body.Timestamp,
+			MessageId: body.MessageId,
+			UpdatedAt: time.Now().Unix(),
+		}
+		if err := app.ReadMarkerStore.SetReadMarker(c, marker); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, marker)
+	}
+}
+
+// GetReadMarkerV2Handler is the handler responsible for sending the position
+// up to which the player has read a room
+func GetReadMarkerV2Handler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "GetReadMarkerV2")
+		topic := c.ParamValues()[0]
+		userID := UserID(c)
+		if userID == "" {
+			// the markers are kept by player, legacy requests without
+			// a userid have none
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		authenticated, _, err := IsAuthorized(c.StdContext(), app, userID, topic)
+		if err != nil {
+			return err
+		}
+
+		if !authenticated {
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		markers, err := app.ReadMarkerStore.GetReadMarkers(c, GameID(c), userID, []string{topic})
+		if err != nil {
+			return err
+		}
+
+		if len(markers) == 0 {
+			return c.String(echo.ErrNotFound.Code, echo.ErrNotFound.Message)
+		}
+		return c.JSON(http.StatusOK, markers[0])
+	}
+}
+
+// ReadMarkersV2Handler is the handler responsible for sending the player's
+// read marker and unread count on each room
+func ReadMarkersV2Handler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "ReadMarkersV2")
+		topicPrefix := c.ParamValues()[0]
+		userID := UserID(c)
+		if userID == "" {
+			// the markers are kept by player, legacy requests without
+			// a userid have none
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+		topicsSuffix, _, _ := ParseHistoriesQueryParams(c, app.Defaults.LimitOfMessages)
+
+		topics := make([]string, len(topicsSuffix))
+		for i, topicSuffix := range topicsSuffix {
+			topics[i] = topicPrefix + "/" + topicSuffix
+		}
+		if tooManyUnreadTopics(app, topics) {
+			logger.Logger.Warningf("Error: user %s asked the read markers of %d topics", userID, len(topics))
+			return c.JSON(http.StatusUnprocessableEntity, "Error: too many topics.")
+		}
+
+		authenticated, authorizedTopics, err := IsAuthorized(c.StdContext(), app, userID, topics...)
+		if err != nil {
+			return err
+		}
+
+		if !authenticated {
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		readStates, err := topicsReadStates(c, app, userID, authorizedTopics)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, readStates)
+	}
+}
+
+// topicsReadStates returns the read marker and unread count of the player on each topic
+func topicsReadStates(c echo.Context, app *App, userID string, topics []string) (map[string]TopicReadState, error) {
+	markers, err := app.ReadMarkerStore.GetReadMarkers(c, GameID(c), userID, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	topicsMarker := make(map[string]*models.ReadMarker, len(markers))
+	topicsSince := make(map[string]int64, len(markers))
+	for i := range markers {
+		topicsMarker[markers[i].Topic] = &markers[i]
+		topicsSince[markers[i].Topic] = markers[i].Timestamp
+	}
+
+	counts, err := countUnread(c, app, userID, topics, topicsSince)
+	if err != nil {
+		return nil, err
+	}
+
+	readStates := make(map[string]TopicReadState, len(topics))
+	for _, topic := range topics {
+		readStates[topic] = TopicReadState{
+			Marker: topicsMarker[topic],
+			Unread: counts[topic],
+		}
+	}
+	return readStates, nil
+}
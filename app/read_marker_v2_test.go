@@ -0,0 +1,147 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/models"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestReadMarkerV2Handlers(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("ReadMarkerV2", func() {
+		ctx := context.Background()
+		a := GetDefaultTestApp()
+
+		g.Describe("Read marker handlers", func() {
+
+			g.It("It should return 401 if the user is not authorized into the topic", func() {
+				userID := fmt.Sprintf("test:%s", uuid.NewV4().String())
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				path := fmt.Sprintf("/v2/marker/chat/test/%s?userid=%s", testID, userID)
+				status, _ := PutJSON(a, path, `{"timestamp": 10}`, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+
+				status, _ = Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+			})
+
+			g.It("It should return 401 without a userid in legacy mode, even with anonymous access", func() {
+				viper.Set("mongo.allow_anonymous", true)
+				defer viper.Set("mongo.allow_anonymous", false)
+
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				path := fmt.Sprintf("/v2/marker/chat/test/%s", testID)
+				status, _ := PutJSON(a, path, `{"timestamp": 10}`, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+
+				status, _ = Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+
+				status, _ = Get(a, fmt.Sprintf("/v2/markers/chat/test?topics=%s", testID), t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+			})
+
+			g.It("It should return 422 if the timestamp is missing", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				path := fmt.Sprintf("/v2/marker/chat/test/%s?userid=test:test", testID)
+				status, _ := PutJSON(a, path, `{"message_id": "a"}`, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			})
+
+			g.It("It should store and return the marker of the player", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test/%s", testID)
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/marker/%s?userid=test:test", topic)
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusNotFound)
+
+				status, _ = PutJSON(a, path, `{"timestamp": 10, "message_id": "a"}`, t)
+				g.Assert(status).Equal(http.StatusOK)
+				status, _ = PutJSON(a, path, `{"timestamp": 20, "message_id": "b"}`, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				status, body := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var marker models.ReadMarker
+				err = json.Unmarshal([]byte(body), &marker)
+				Expect(err).To(BeNil())
+				g.Assert(marker.PlayerId).Equal("test:test")
+				g.Assert(marker.Topic).Equal(topic)
+				g.Assert(marker.Timestamp).Equal(int64(20))
+				g.Assert(marker.MessageId).Equal("b")
+			})
+
+			g.It("It should return the marker and unread count of every topic", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				testID2 := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test/%s", testID)
+				topic2 := fmt.Sprintf("chat/test/%s", testID2)
+				now := time.Now().Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic, topic2})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: "a", Topic: topic, Timestamp: now - 3},
+					{Id: "b", Topic: topic, Timestamp: now - 2},
+					{Id: "c", Topic: topic, Timestamp: now - 1},
+					{Id: "d", Topic: topic2, Timestamp: now - 1},
+				})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/marker/%s?userid=test:test", topic)
+				status, _ := PutJSON(a, path, fmt.Sprintf(`{"timestamp": %d, "message_id": "b"}`, now-2), t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				path = fmt.Sprintf("/v2/markers/chat/test?userid=test:test&topics=%s,%s", testID, testID2)
+				status, body := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var readStates map[string]app.TopicReadState
+				err = json.Unmarshal([]byte(body), &readStates)
+				Expect(err).To(BeNil())
+				g.Assert(readStates[topic].Marker.MessageId).Equal("b")
+				g.Assert(readStates[topic].Unread).Equal(int64(1))
+				g.Assert(readStates[topic2].Marker == nil).IsTrue()
+				g.Assert(readStates[topic2].Unread).Equal(int64(1))
+
+				path = fmt.Sprintf("/v2/histories/chat/test?userid=test:test&topics=%s,%s&markers=true", testID, testID2)
+				status, body = Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var histories app.HistoriesV2WithMarkers
+				err = json.Unmarshal([]byte(body), &histories)
+				Expect(err).To(BeNil())
+				g.Assert(len(histories.Messages)).Equal(4)
+				g.Assert(histories.Markers[topic].Unread).Equal(int64(1))
+				g.Assert(histories.Markers[topic2].Unread).Equal(int64(1))
+			})
+		})
+	})
+}
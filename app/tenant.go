@@ -0,0 +1,139 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/tenant"
+)
+
+// The sources of the game ID of the requests
+const (
+	// TenantFromClaim reads the game ID from a claim of the player's token
+	TenantFromClaim = "claim"
+	// TenantFromHost maps the host of the request to a game ID
+	TenantFromHost = "host"
+	// TenantFromHeader reads the game ID from the X-Game-ID header. Clients
+	// can send any game in it, so it is only trusted on the operator routes
+	// unless the deployment sits behind a proxy setting it
+	TenantFromHeader = "header"
+)
+
+const gameIDContextKey = "gameID"
+
+// TenantMiddleware resolves the game of the requests, their tenant, and
+// sets its ID in the request context, scoping the queries to its messages
+type TenantMiddleware struct {
+	// Sources are tried in order until one of them holds a game ID, on the
+	// player routes, and OperatorSources on the operator routes
+	Sources         []string
+	OperatorSources []string
+	Header          string
+	Claim           string
+	// Hosts maps the lowercased hosts, without port, to their game ID
+	Hosts map[string]string
+	// Required rejects with 422 the requests without a game ID
+	Required bool
+}
+
+// NewTenantMiddleware returns the middleware configured in tenant
+func NewTenantMiddleware(config *viper.Viper) (*TenantMiddleware, error) {
+	m := &TenantMiddleware{
+		Sources:         config.GetStringSlice("tenant.sources"),
+		OperatorSources: config.GetStringSlice("tenant.operatorSources"),
+		Header:          config.GetString("tenant.header"),
+		Claim:           config.GetString("tenant.claim"),
+		Hosts:           map[string]string{},
+		Required:        config.GetBool("tenant.required"),
+	}
+	for _, source := range append(m.Sources, m.OperatorSources...) {
+		switch source {
+		case TenantFromClaim, TenantFromHost, TenantFromHeader:
+		default:
+			return nil, fmt.Errorf("unknown game id source: %s", source)
+		}
+	}
+	for host, gameID := range config.GetStringMapString("tenant.hosts") {
+		m.Hosts[strings.ToLower(host)] = gameID
+	}
+	return m, nil
+}
+
+// Serve sets the game ID of the player request in its context
+func (m *TenantMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
+	return m.serve(next, m.Sources)
+}
+
+// ServeOperators sets the game ID of the operator request in its context
+func (m *TenantMiddleware) ServeOperators(next echo.HandlerFunc) echo.HandlerFunc {
+	return m.serve(next, m.OperatorSources)
+}
+
+func (m *TenantMiddleware) serve(next echo.HandlerFunc, sources []string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		gameID := m.gameID(c, sources)
+		if gameID == "" && m.Required {
+			logger.Logger.Warningf("Error: %s", "missing game id")
+			return c.JSON(422, "Error getting game id.")
+		}
+
+		c.Set(gameIDContextKey, gameID)
+		c.SetStdContext(tenant.NewContext(c.StdContext(), gameID))
+		return next(c)
+	}
+}
+
+func (m *TenantMiddleware) gameID(c echo.Context, sources []string) string {
+	for _, source := range sources {
+		var gameID string
+		switch source {
+		case TenantFromClaim:
+			switch claim := Claims(c)[m.Claim].(type) {
+			case string:
+				gameID = claim
+			case json.Number:
+				gameID = claim.String()
+			}
+		case TenantFromHost:
+			host := c.Request().Host()
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			gameID = m.Hosts[strings.ToLower(host)]
+		case TenantFromHeader:
+			gameID = c.Request().Header().Get(m.Header)
+		}
+		if gameID != "" {
+			return gameID
+		}
+	}
+	return ""
+}
+
+// GameID returns the ID of the game resolved by the TenantMiddleware,
+// empty if multi-tenancy is disabled
+func GameID(c echo.Context) string {
+	gameID, _ := c.Get(gameIDContextKey).(string)
+	return gameID
+}
+
+// TenantDefaults returns the defaults of the request's game, with its
+// overrides of the collection and the limits
+func (app *App) TenantDefaults(c echo.Context) *models.Defaults {
+	gameID := GameID(c)
+	if gameID == "" {
+		return app.Defaults
+	}
+
+	defaults := *app.Defaults
+	defaults.MongoMessagesCollection = tenant.Collection(app.Config, gameID)
+	defaults.LimitOfMessages = tenant.Limit(app.Config, gameID)
+	defaults.ForwardLimitOfMessages = tenant.ForwardLimit(app.Config, gameID)
+	return &defaults
+}
@@ -0,0 +1,213 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/models"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	hmacSecret := []byte("a-very-secret-secret")
+	hmacSecretFile := filepath.Join(t.TempDir(), "secret")
+	writeFile(t, hmacSecretFile, hmacSecret)
+
+	g.Describe("Tenant", func() {
+		ctx := context.Background()
+		var a *app.App
+		var topic string
+
+		g.BeforeEach(func() {
+			viper.Set("tenant.enabled", true)
+			viper.Set("tenant.sources", []string{"claim", "host", "header"})
+			viper.Set("auth.jwt.hmacSecretFile", hmacSecretFile)
+			a = GetDefaultTestApp()
+
+			topic = fmt.Sprintf("chat/test_%s", strings.Replace(uuid.NewV4().String(), "-", "", -1))
+			err := a.ACLStore.InsertACLs(ctx, []app.ACL{
+				{Username: "test:test", Pubsub: []string{topic}},
+				{GameId: "game1", Username: "test:test", Pubsub: []string{topic}},
+				{GameId: "game2", Username: "test:test", Pubsub: []string{topic}},
+				{GameId: "game3", Username: "test:test", Pubsub: []string{topic}},
+			})
+			Expect(err).To(BeNil())
+
+			now := time.Now().Unix()
+			err = InsertTestMessages(ctx, []*models.MessageV2{
+				{Id: uuid.NewV4().String(), Topic: topic, GameId: "game1", Timestamp: now - 3, Message: "first"},
+				{Id: uuid.NewV4().String(), Topic: topic, GameId: "game1", Timestamp: now - 2, Message: "second"},
+				{Id: uuid.NewV4().String(), Topic: topic, GameId: "game2", Timestamp: now - 1, Message: "other game"},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		g.AfterEach(func() {
+			viper.Set("tenant.enabled", false)
+			viper.Set("tenant.required", nil)
+			viper.Set("tenant.sources", nil)
+			viper.Set("tenant.hosts", map[string]string{})
+			viper.Set("tenant.games", map[string]interface{}{})
+			viper.Set("auth.jwt.hmacSecretFile", "")
+		})
+
+		getMessages := func(path string, headers map[string]string) (int, []models.MessageV2) {
+			status, body, _ := GetWithHeaders(a, path, headers, t)
+			var messages []models.MessageV2
+			if status == http.StatusOK {
+				Expect(json.Unmarshal([]byte(body), &messages)).To(BeNil())
+			}
+			return status, messages
+		}
+
+		g.It("It should scope the history to the game of the X-Game-ID header", func() {
+			path := fmt.Sprintf("/v2/history/%s?userid=test:test", topic)
+			status, messages := getMessages(path, map[string]string{"X-Game-ID": "game2"})
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Message).Equal("other game")
+
+			// every game is returned without a game id unless it is required
+			viper.Set("tenant.required", false)
+			a = GetDefaultTestApp()
+			status, messages = getMessages(path, nil)
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(len(messages)).Equal(3)
+		})
+
+		g.It("It should prefer the game of the token claim over the header", func() {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "test:test", "game_id": "game1", "exp": time.Now().Add(time.Hour).Unix()})
+			signed, err := token.SignedString(hmacSecret)
+			Expect(err).To(BeNil())
+
+			path := fmt.Sprintf("/v2/history/%s", topic)
+			status, messages := getMessages(path, map[string]string{
+				"Authorization": "Bearer " + signed,
+				"X-Game-ID":     "game2",
+			})
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(len(messages)).Equal(2)
+			for _, message := range messages {
+				g.Assert(message.GameId).Equal("game1")
+			}
+		})
+
+		g.It("It should map the host of the request to its game", func() {
+			viper.Set("tenant.hosts", map[string]string{"127.0.0.1": "game2"})
+			a = GetDefaultTestApp()
+
+			path := fmt.Sprintf("/v2/history/%s?userid=test:test", topic)
+			status, messages := getMessages(path, nil)
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].GameId).Equal("game2")
+		})
+
+		g.It("It should apply the limit and collection overrides of the game", func() {
+			viper.Set("tenant.games.game1.limit", 1)
+			viper.Set("tenant.games.game3.collection", "game3_messages")
+
+			path := fmt.Sprintf("/v2/history/%s?userid=test:test", topic)
+			status, messages := getMessages(path, map[string]string{"X-Game-ID": "game1"})
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Message).Equal("second")
+
+			err := a.MessageStore.InsertMessages(ctx, "game3_messages", []*models.MessageV2{
+				{Id: uuid.NewV4().String(), Topic: topic, GameId: "game3", Timestamp: time.Now().Unix() - 1},
+			})
+			Expect(err).To(BeNil())
+			status, messages = getMessages(path, map[string]string{"X-Game-ID": "game3"})
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].GameId).Equal("game3")
+		})
+
+		g.It("It should allow anonymous access to the games overriding it", func() {
+			viper.Set("tenant.games.game2.allowAnonymous", true)
+
+			path := fmt.Sprintf("/v2/history/%s?userid=anonymous:%s", topic, uuid.NewV4().String())
+			status, _ := getMessages(path, map[string]string{"X-Game-ID": "game1"})
+			g.Assert(status).Equal(http.StatusUnauthorized)
+
+			status, messages := getMessages(path, map[string]string{"X-Game-ID": "game2"})
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(len(messages)).Equal(1)
+		})
+
+		g.It("It should answer 422 without a game id, as it is required by default", func() {
+			path := fmt.Sprintf("/v2/history/%s?userid=test:test", topic)
+			status, _ := getMessages(path, nil)
+			g.Assert(status).Equal(http.StatusUnprocessableEntity)
+
+			status, _ = getMessages(path, map[string]string{"X-Game-ID": "game1"})
+			g.Assert(status).Equal(http.StatusOK)
+		})
+
+		g.It("It should only trust the X-Game-ID header on the operator routes by default", func() {
+			viper.Set("tenant.sources", nil)
+			viper.Set("tenant.games.game2.allowAnonymous", true)
+			a = GetDefaultTestApp()
+
+			path := fmt.Sprintf("/v2/history/%s?userid=anonymous:%s", topic, uuid.NewV4().String())
+			status, _ := getMessages(path, map[string]string{"X-Game-ID": "game2"})
+			g.Assert(status).Equal(http.StatusUnprocessableEntity)
+
+			today := time.Now().Format("2006-01-02")
+			path = fmt.Sprintf("/ps/v2/history?topic=%s&initialDate=%s&finalDate=%s", topic, today, today)
+			status, messages := getMessages(path, map[string]string{"X-API-Key": SupportReadAPIKey, "X-Game-ID": "game2"})
+			g.Assert(status).Equal(http.StatusOK)
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].GameId).Equal("game2")
+		})
+
+		g.It("It should keep the ACLs, read markers and mute lists of each game apart", func() {
+			err := a.ACLStore.InsertACLs(ctx, []app.ACL{{GameId: "game1", Username: "test:game1", Pubsub: []string{topic}}})
+			Expect(err).To(BeNil())
+
+			path := fmt.Sprintf("/v2/history/%s?userid=test:game1", topic)
+			status, _ := getMessages(path, map[string]string{"X-Game-ID": "game1"})
+			g.Assert(status).Equal(http.StatusOK)
+			status, _ = getMessages(path, map[string]string{"X-Game-ID": "game2"})
+			g.Assert(status).Equal(http.StatusUnauthorized)
+
+			game1 := map[string]string{"X-Game-ID": "game1"}
+			game2 := map[string]string{"X-Game-ID": "game2"}
+			status, _ = PutJSONWithHeaders(a, "/v2/mutes/p2?userid=test:test", "", game1, t)
+			g.Assert(status).Equal(http.StatusOK)
+			_, body, _ := GetWithHeaders(a, "/v2/mutes?userid=test:test", game2, t)
+			g.Assert(strings.TrimSpace(body)).Equal("[]")
+
+			marker := fmt.Sprintf("/v2/marker/%s?userid=test:test", topic)
+			status, _ = PutJSONWithHeaders(a, marker, `{"timestamp": 1}`, game1, t)
+			g.Assert(status).Equal(http.StatusOK)
+			status, _, _ = GetWithHeaders(a, marker, game2, t)
+			g.Assert(status).Equal(http.StatusNotFound)
+			status, _, _ = GetWithHeaders(a, marker, game1, t)
+			g.Assert(status).Equal(http.StatusOK)
+		})
+	})
+}
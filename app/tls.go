@@ -0,0 +1,207 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/engine/standard"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/logger"
+)
+
+// The client certificate modes of tls.clientAuth
+const (
+	// ClientAuthNone does not ask for client certificates
+	ClientAuthNone = "none"
+	// ClientAuthRequire requires a certificate signed by the CA bundle
+	// on every connection
+	ClientAuthRequire = "require"
+	// ClientAuthOperators verifies the certificates sent and requires one
+	// on the operator routes only, players connecting without
+	ClientAuthOperators = "operators"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSReloader holds the certificate of the public API and the CA bundle
+// verifying the client certificates. Both are read again when their files
+// change on disk, the new connections using them without a restart
+type TLSReloader struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAuth   string
+	MinVersion   uint16
+
+	mutex       sync.RWMutex
+	certificate tls.Certificate
+	clientCAs   *x509.CertPool
+	modTimes    map[string]time.Time
+}
+
+// NewTLSReloader returns the TLSReloader configured in tls, with its files loaded
+func NewTLSReloader(config *viper.Viper) (*TLSReloader, error) {
+	reloader := &TLSReloader{
+		CertFile:     config.GetString("tls.certFile"),
+		KeyFile:      config.GetString("tls.keyFile"),
+		ClientCAFile: config.GetString("tls.clientCAFile"),
+		ClientAuth:   config.GetString("tls.clientAuth"),
+	}
+	if reloader.CertFile == "" || reloader.KeyFile == "" {
+		return nil, fmt.Errorf("tls.certFile and tls.keyFile are required")
+	}
+
+	minVersion := config.GetString("tls.minVersion")
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version: %s", minVersion)
+	}
+	reloader.MinVersion = version
+
+	switch reloader.ClientAuth {
+	case "":
+		reloader.ClientAuth = ClientAuthNone
+		if reloader.ClientCAFile != "" {
+			reloader.ClientAuth = ClientAuthRequire
+		}
+	case ClientAuthNone:
+	case ClientAuthRequire, ClientAuthOperators:
+		if reloader.ClientCAFile == "" {
+			return nil, fmt.Errorf("tls.clientAuth %s needs tls.clientCAFile", reloader.ClientAuth)
+		}
+	default:
+		return nil, fmt.Errorf("unknown client auth: %s", reloader.ClientAuth)
+	}
+
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Config returns the TLS config of the listener, handing each connection
+// the files loaded last
+func (r *TLSReloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:         r.MinVersion,
+		GetConfigForClient: r.configForClient,
+	}
+}
+
+func (r *TLSReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	config := &tls.Config{
+		MinVersion:   r.MinVersion,
+		Certificates: []tls.Certificate{r.certificate},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	switch r.ClientAuth {
+	case ClientAuthRequire:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = r.clientCAs
+	case ClientAuthOperators:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = r.clientCAs
+	}
+	return config, nil
+}
+
+// Reload reads the files again if any of them changed since they were
+// loaded. On error, the files loaded before are kept
+func (r *TLSReloader) Reload() error {
+	r.mutex.RLock()
+	changed := false
+	for path, modTime := range r.modTimes {
+		info, err := os.Stat(path)
+		if err != nil {
+			r.mutex.RUnlock()
+			return err
+		}
+		changed = changed || !info.ModTime().Equal(modTime)
+	}
+	r.mutex.RUnlock()
+
+	if !changed {
+		return nil
+	}
+	if err := r.load(); err != nil {
+		return err
+	}
+	logger.Logger.Info("Reloaded the TLS certificates.")
+	return nil
+}
+
+// Watch calls Reload every interval, logging its errors
+func (r *TLSReloader) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := r.Reload(); err != nil {
+			logger.Logger.Errorf("Failed to reload the TLS certificates: %s", err.Error())
+		}
+	}
+}
+
+func (r *TLSReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	paths := []string{r.CertFile, r.KeyFile}
+	if r.ClientAuth != ClientAuthNone {
+		paths = append(paths, r.ClientCAFile)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if r.ClientAuth != ClientAuthNone {
+		bundle, err := ioutil.ReadFile(r.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("no certificate found in %s", r.ClientCAFile)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certificate = certificate
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+// ClientCertificateMiddleware rejects with 401 the requests made without a
+// client certificate verified by the CA bundle
+func ClientCertificateMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request, ok := c.Request().(*standard.Request)
+		if !ok || request.Request.TLS == nil || len(request.Request.TLS.VerifiedChains) == 0 {
+			logger.Logger.Warning("Operator request without a client certificate.")
+			return c.String(http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		}
+		return next(c)
+	}
+}
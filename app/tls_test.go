@@ -0,0 +1,241 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	"github.com/labstack/echo/engine/standard"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/app"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+// newTestCertificate returns a certificate for the name signed by the
+// parent, or a self-signed CA certificate if the parent is nil
+func newTestCertificate(name string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	Expect(err).To(BeNil())
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).To(BeNil())
+	certificate, err := x509.ParseCertificate(der)
+	Expect(err).To(BeNil())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).To(BeNil())
+
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCertificate) write(certFile, keyFile string) {
+	Expect(ioutil.WriteFile(certFile, c.certPEM, 0600)).To(BeNil())
+	Expect(ioutil.WriteFile(keyFile, c.keyPEM, 0600)).To(BeNil())
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	certificate, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	Expect(err).To(BeNil())
+	return certificate
+}
+
+// serveTLS serves the app on a TLS listener and returns its URL
+func serveTLS(a *app.App) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	a.Engine.SetHandler(a.API)
+	server := &http.Server{Handler: a.Engine.(*standard.Server)}
+	go server.Serve(tls.NewListener(listener, a.TLS.Config()))
+	return fmt.Sprintf("https://%s", listener.Addr().String()), func() { server.Close() }
+}
+
+func tlsClient(ca *testCertificate, client *testCertificate, maxVersion uint16) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	config := &tls.Config{RootCAs: roots, MaxVersion: maxVersion}
+	if client != nil {
+		config.Certificates = []tls.Certificate{client.tlsCertificate()}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+}
+
+func TestTLS(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	var ca, server, client *testCertificate
+
+	g.Describe("TLS", func() {
+		g.BeforeEach(func() {
+			ca = newTestCertificate("test-ca", nil)
+			server = newTestCertificate("server", ca)
+			client = newTestCertificate("support-tooling", ca)
+			server.write(certFile, keyFile)
+			Expect(ioutil.WriteFile(caFile, ca.certPEM, 0600)).To(BeNil())
+
+			viper.Set("tls.enabled", true)
+			viper.Set("tls.certFile", certFile)
+			viper.Set("tls.keyFile", keyFile)
+		})
+
+		g.AfterEach(func() {
+			viper.Set("tls.enabled", false)
+			viper.Set("tls.clientCAFile", "")
+			viper.Set("tls.clientAuth", "")
+			viper.Set("tls.minVersion", "1.2")
+		})
+
+		g.It("should serve the API over TLS and reject the older versions", func() {
+			a := GetDefaultTestApp()
+			url, stop := serveTLS(a)
+			defer stop()
+
+			response, err := tlsClient(ca, nil, 0).Get(url + "/healthcheck")
+			Expect(err).To(BeNil())
+			g.Assert(response.StatusCode).Equal(http.StatusOK)
+			g.Assert(response.TLS.PeerCertificates[0].Subject.CommonName).Equal("server")
+
+			_, err = tlsClient(ca, nil, tls.VersionTLS11).Get(url + "/healthcheck")
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should serve the rotated certificate after a reload", func() {
+			a := GetDefaultTestApp()
+			url, stop := serveTLS(a)
+			defer stop()
+
+			rotated := newTestCertificate("rotated", ca)
+			rotated.write(certFile, keyFile)
+			later := time.Now().Add(time.Minute)
+			Expect(osChtimes(certFile, later)).To(BeNil())
+
+			err := a.TLS.Reload()
+			Expect(err).To(BeNil())
+
+			response, err := tlsClient(ca, nil, 0).Get(url + "/healthcheck")
+			Expect(err).To(BeNil())
+			g.Assert(response.TLS.PeerCertificates[0].Subject.CommonName).Equal("rotated")
+		})
+
+		g.It("should keep the loaded certificate if the new files are invalid", func() {
+			a := GetDefaultTestApp()
+
+			Expect(ioutil.WriteFile(certFile, []byte("invalid"), 0600)).To(BeNil())
+			Expect(osChtimes(certFile, time.Now().Add(time.Minute))).To(BeNil())
+			err := a.TLS.Reload()
+			Expect(err).NotTo(BeNil())
+
+			url, stop := serveTLS(a)
+			defer stop()
+			response, err := tlsClient(ca, nil, 0).Get(url + "/healthcheck")
+			Expect(err).To(BeNil())
+			g.Assert(response.TLS.PeerCertificates[0].Subject.CommonName).Equal("server")
+		})
+
+		g.It("should require a client certificate on every connection", func() {
+			viper.Set("tls.clientCAFile", caFile)
+			a := GetDefaultTestApp()
+			url, stop := serveTLS(a)
+			defer stop()
+
+			_, err := tlsClient(ca, nil, 0).Get(url + "/healthcheck")
+			Expect(err).NotTo(BeNil())
+
+			response, err := tlsClient(ca, client, 0).Get(url + "/healthcheck")
+			Expect(err).To(BeNil())
+			g.Assert(response.StatusCode).Equal(http.StatusOK)
+		})
+
+		g.It("should require a client certificate on the operator routes only", func() {
+			viper.Set("tls.clientCAFile", caFile)
+			viper.Set("tls.clientAuth", app.ClientAuthOperators)
+			a := GetDefaultTestApp()
+			url, stop := serveTLS(a)
+			defer stop()
+
+			response, err := tlsClient(ca, nil, 0).Get(url + "/healthcheck")
+			Expect(err).To(BeNil())
+			g.Assert(response.StatusCode).Equal(http.StatusOK)
+
+			request, err := http.NewRequest("GET", url+"/ps/v2/audit", nil)
+			Expect(err).To(BeNil())
+			request.Header.Set("X-API-Key", SupportAuditAPIKey)
+
+			response, err = tlsClient(ca, nil, 0).Do(request)
+			Expect(err).To(BeNil())
+			g.Assert(response.StatusCode).Equal(http.StatusUnauthorized)
+
+			response, err = tlsClient(ca, client, 0).Do(request)
+			Expect(err).To(BeNil())
+			g.Assert(response.StatusCode).Equal(http.StatusOK)
+
+			// a certificate from another CA is not verified
+			other := newTestCertificate("other-ca", nil)
+			response, err = tlsClient(ca, newTestCertificate("intruder", other), 0).Do(request)
+			Expect(err).To(BeNil())
+			g.Assert(response.StatusCode).Equal(http.StatusUnauthorized)
+		})
+	})
+}
+
+func osChtimes(path string, modTime time.Time) error {
+	return os.Chtimes(path, modTime, modTime)
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	"golang.org/x/sync/errgroup"
+)
+
+// UnreadV2Handler is the handler responsible for sending the number of
+// messages sent after the player's markers on each room
+func UnreadV2Handler(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Set("route", "UnreadV2")
+		topicPrefix := c.ParamValues()[0]
+		userID := UserID(c)
+		topicsSuffix, _, _ := ParseHistoriesQueryParams(c, app.Defaults.LimitOfMessages)
+		markers, err := ParseSinceQueryParam(c, len(topicsSuffix))
+		if err != nil {
+			logger.Logger.Warningf("Error: %s", err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, "Error getting since parameter.")
+		}
+
+		topics := make([]string, len(topicsSuffix))
+		topicsSince := make(map[string]int64, len(topicsSuffix))
+		for i, topicSuffix := range topicsSuffix {
+			topics[i] = topicPrefix + "/" + topicSuffix
+			topicsSince[topics[i]] = markers[i]
+		}
+		if tooManyUnreadTopics(app, topics) {
+			logger.Logger.Warningf("Error: user %s asked the unread counts of %d topics", userID, len(topics))
+			return c.JSON(http.StatusUnprocessableEntity, "Error: too many topics.")
+		}
+
+		logger.Logger.Debugf("user %s is asking for unread v2 for topicPrefix %s with args topics=%s", userID, topicPrefix, topics)
+		authenticated, authorizedTopics, err := IsAuthorized(c.StdContext(), app, userID, topics...)
+		if err != nil {
+			return err
+		}
+
+		if !authenticated {
+			return c.String(echo.ErrUnauthorized.Code, echo.ErrUnauthorized.Message)
+		}
+
+		counts, err := countUnread(c, app, userID, authorizedTopics, topicsSince)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, counts)
+	}
+}
+
+// tooManyUnreadTopics tells whether there are more topics than the
+// unread.maxTopics counted at once
+func tooManyUnreadTopics(app *App, topics []string) bool {
+	maxTopics := app.Config.GetInt("unread.maxTopics")
+	return maxTopics > 0 && len(topics) > maxTopics
+}
+
+// countUnread returns, for each topic, how many unblocked messages were sent
+// after its since marker, leaving out those of the players muted by the user.
+// Up to unread.concurrency topics are counted at a time
+func countUnread(c echo.Context, app *App, userID string, topics []string, topicsSince map[string]int64) (map[string]int64, error) {
+	collection := app.TenantDefaults(c).MongoMessagesCollection
+	excludedPlayerIDs, err := mutedPlayerIDs(c, app, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	counts := make(map[string]int64, len(topics))
+	group, ctx := errgroup.WithContext(c)
+	if concurrency := app.Config.GetInt("unread.concurrency"); concurrency > 0 {
+		group.SetLimit(concurrency)
+	}
+	for _, topic := range topics {
+		topic := topic
+		group.Go(func() error {
+			count, err := app.MessageStore.CountMessagesV2(
+				ctx,
+				mongoclient.QueryParameters{
+					Topic:             topic,
+					Since:             topicsSince[topic],
+					Collection:        collection,
+					GameID:            GameID(c),
+					ExcludedPlayerIDs: excludedPlayerIDs,
+				},
+			)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			counts[topic] = count
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
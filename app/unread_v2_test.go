@@ -0,0 +1,180 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	. "github.com/topfreegames/mqtt-history/testing"
+)
+
+// slowCountMessageStore records how many counts run at once
+type slowCountMessageStore struct {
+	fakeMessageStore
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *slowCountMessageStore) CountMessagesV2(ctx context.Context, queryParameters mongoclient.QueryParameters) (int64, error) {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+	return 0, nil
+}
+
+func TestUnreadV2Handler(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("UnreadV2", func() {
+		ctx := context.Background()
+		a := GetDefaultTestApp()
+
+		g.Describe("UnreadV2 Handler", func() {
+
+			g.It("It should return 401 if the user is not authorized into the topics", func() {
+				userID := fmt.Sprintf("test:%s", uuid.NewV4().String())
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				path := fmt.Sprintf("/v2/unread/chat/test?userid=%s&topics=%s&since=0", userID, testID)
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnauthorized)
+			})
+
+			g.It("It should return 422 if since does not match the topics", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				path := fmt.Sprintf("/v2/unread/chat/test?userid=test:test&topics=%s&since=1,2", testID)
+				status, _ := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusUnprocessableEntity)
+			})
+
+			g.It("It should count the unblocked messages after each topic marker", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				testID2 := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test/%s", testID)
+				topic2 := fmt.Sprintf("chat/test/%s", testID2)
+				now := time.Now().Unix()
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic, topic2})
+				Expect(err).To(BeNil())
+
+				err = InsertTestMessages(ctx, []*models.MessageV2{
+					{Id: "a", Topic: topic, Timestamp: now - 3},
+					{Id: "b", Topic: topic, Timestamp: now - 2},
+					{Id: "c", Topic: topic, Timestamp: now - 1},
+					{Id: "d", Topic: topic, Timestamp: now - 1, Blocked: true},
+					{Id: "e", Topic: topic2, Timestamp: now - 1},
+				})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/unread/chat/test?userid=test:test&topics=%s,%s&since=%d", testID, testID2, now-10)
+				status, body := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var counts map[string]int64
+				err = json.Unmarshal([]byte(body), &counts)
+				Expect(err).To(BeNil())
+				g.Assert(counts[topic]).Equal(int64(3))
+				g.Assert(counts[topic2]).Equal(int64(1))
+
+				path = fmt.Sprintf("/v2/unread/chat/test?userid=test:test&topics=%s,%s&since=%d,%d", testID, testID2, now-2, now-1)
+				status, body = Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				err = json.Unmarshal([]byte(body), &counts)
+				Expect(err).To(BeNil())
+				g.Assert(counts[topic]).Equal(int64(1))
+				g.Assert(counts[topic2]).Equal(int64(0))
+			})
+
+			g.It("It should only count the topics the user is authorized into", func() {
+				testID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				testID2 := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+				topic := fmt.Sprintf("chat/test/%s", testID)
+				topic2 := fmt.Sprintf("chat/test/%s", testID2)
+
+				err := AuthorizeTestUserInTopics(ctx, []string{topic})
+				Expect(err).To(BeNil())
+
+				err = InsertMongoMessages(ctx, []string{topic, topic2})
+				Expect(err).To(BeNil())
+
+				path := fmt.Sprintf("/v2/unread/chat/test?userid=test:test&topics=%s,%s", testID, testID2)
+				status, body := Get(a, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var counts map[string]int64
+				err = json.Unmarshal([]byte(body), &counts)
+				Expect(err).To(BeNil())
+				g.Assert(len(counts)).Equal(1)
+				g.Assert(counts[topic]).Equal(int64(1))
+			})
+
+			g.It("It should return 422 with more topics than unread.maxTopics", func() {
+				viper.Set("unread.maxTopics", 2)
+				defer viper.Set("unread.maxTopics", nil)
+
+				for _, route := range []string{"unread", "markers", "histories"} {
+					path := fmt.Sprintf("/v2/%s/chat/test?userid=test:test&topics=a,b,c&markers=true", route)
+					status, _ := Get(a, path, t)
+					g.Assert(status).Equal(http.StatusUnprocessableEntity)
+				}
+			})
+
+			g.It("It should count up to unread.concurrency topics at a time", func() {
+				viper.Set("unread.concurrency", 2)
+				defer viper.Set("unread.concurrency", nil)
+
+				topicsSuffix := make([]string, 6)
+				topics := make([]string, 6)
+				for i := range topics {
+					topicsSuffix[i] = strings.Replace(uuid.NewV4().String(), "-", "", -1)
+					topics[i] = "chat/test/" + topicsSuffix[i]
+				}
+				err := AuthorizeTestUserInTopics(ctx, topics)
+				Expect(err).To(BeNil())
+
+				store := &slowCountMessageStore{}
+				counting := GetDefaultTestApp()
+				counting.MessageStore = store
+				path := fmt.Sprintf("/v2/unread/chat/test?userid=test:test&topics=%s", strings.Join(topicsSuffix, ","))
+				status, body := Get(counting, path, t)
+				g.Assert(status).Equal(http.StatusOK)
+
+				var counts map[string]int64
+				err = json.Unmarshal([]byte(body), &counts)
+				Expect(err).To(BeNil())
+				g.Assert(len(counts)).Equal(6)
+				g.Assert(store.maxInFlight).Equal(2)
+			})
+		})
+	})
+}
@@ -0,0 +1,120 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+// Sink is implemented by the destinations of the audit log
+type Sink interface {
+	Record(ctx context.Context, entry models.AuditEntry) error
+}
+
+// Auditor appends the audit entries to every configured sink
+type Auditor struct {
+	Sinks []Sink
+}
+
+// NewAuditor returns an Auditor writing to the sinks listed in audit.sinks:
+// storage, the AuditStore, and log, the JSON lines file of audit.log.path
+func NewAuditor(config *viper.Viper, store storage.AuditStore) (*Auditor, error) {
+	auditor := &Auditor{}
+	for _, sink := range config.GetStringSlice("audit.sinks") {
+		switch sink {
+		case "storage":
+			auditor.Sinks = append(auditor.Sinks, &StoreSink{Store: store})
+		case "log":
+			logSink, err := NewLogSink(config.GetString("audit.log.path"))
+			if err != nil {
+				return nil, err
+			}
+			auditor.Sinks = append(auditor.Sinks, logSink)
+		default:
+			return nil, fmt.Errorf("unknown audit sink: %s", sink)
+		}
+	}
+	return auditor, nil
+}
+
+// Record fills the id and the time of the entry and appends it to every
+// sink. It returns the first error, after trying all the sinks
+func (a *Auditor) Record(ctx context.Context, entry models.AuditEntry) error {
+	if entry.Id == "" {
+		entry.Id = uuid.NewV4().String()
+	}
+	if entry.Timestamp == 0 {
+		entry.Timestamp = time.Now().Unix()
+	}
+
+	var firstErr error
+	for _, sink := range a.Sinks {
+		if err := sink.Record(ctx, entry); err != nil {
+			logger.Logger.Errorf("Failed to record audit entry %s: %s", entry.Id, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// StoreSink appends the audit entries to an AuditStore
+type StoreSink struct {
+	Store storage.AuditStore
+}
+
+// Record inserts the entry in the store
+func (s *StoreSink) Record(ctx context.Context, entry models.AuditEntry) error {
+	return s.Store.InsertAuditEntry(ctx, entry)
+}
+
+// LogSink writes the audit entries as JSON lines
+type LogSink struct {
+	mu     sync.Mutex
+	Writer io.Writer
+}
+
+// NewLogSink returns a LogSink appending to the file at path, or writing
+// to the standard output if path is empty
+func NewLogSink(path string) (*LogSink, error) {
+	if path == "" {
+		return &LogSink{Writer: os.Stdout}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &LogSink{Writer: file}, nil
+}
+
+// Record writes the entry as a single JSON line
+func (s *LogSink) Record(ctx context.Context, entry models.AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.Writer.Write(append(line, '\n'))
+	return err
+}
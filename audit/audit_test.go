@@ -0,0 +1,67 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/audit"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+func TestAuditor(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Auditor", func() {
+		ctx := context.Background()
+
+		g.It("should record the entries in every sink", func() {
+			store := storage.NewMemoryAuditStore()
+			var buffer bytes.Buffer
+			auditor := &audit.Auditor{Sinks: []audit.Sink{
+				&audit.StoreSink{Store: store},
+				&audit.LogSink{Writer: &buffer},
+			}}
+
+			err := auditor.Record(ctx, models.AuditEntry{Operator: "op", PlayerId: "p1", ResultCount: 3})
+			Expect(err).To(BeNil())
+			err = auditor.Record(ctx, models.AuditEntry{Operator: "op", PlayerId: "p2"})
+			Expect(err).To(BeNil())
+
+			entries, err := store.FindAuditEntries(ctx, storage.AuditFilter{PlayerID: "p1"})
+			Expect(err).To(BeNil())
+			g.Assert(len(entries)).Equal(1)
+			g.Assert(entries[0].Id != "").IsTrue()
+			g.Assert(entries[0].Timestamp > 0).IsTrue()
+
+			lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+			g.Assert(len(lines)).Equal(2)
+			var logged models.AuditEntry
+			err = json.Unmarshal([]byte(lines[0]), &logged)
+			Expect(err).To(BeNil())
+			g.Assert(logged).Equal(entries[0])
+		})
+
+		g.It("should reject unknown sinks", func() {
+			config := viper.New()
+			config.Set("audit.sinks", []string{"storage", "kafka"})
+			_, err := audit.NewAuditor(config, storage.NewMemoryAuditStore())
+			Expect(err).NotTo(BeNil())
+		})
+	})
+}
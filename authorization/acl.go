@@ -0,0 +1,62 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+	"github.com/topfreegames/mqtt-history/tenant"
+)
+
+// ACLAuthorizer grants the topics matching the topic filters of the
+// pubsub entries of the user's ACLs, in the mqtt_acl collection
+type ACLAuthorizer struct {
+	Store storage.ACLStore
+}
+
+// NewACLAuthorizer returns an ACLAuthorizer reading the ACLs from the store
+func NewACLAuthorizer(store storage.ACLStore) *ACLAuthorizer {
+	return &ACLAuthorizer{Store: store}
+}
+
+// Authorize returns the topics granted by the user's ACLs in the game of
+// the context. The requested topics must be concrete ones: a requested
+// filter is never granted
+func (a *ACLAuthorizer) Authorize(ctx context.Context, userID string, topics []string) ([]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "mongo_authorize")
+	defer span.Finish()
+
+	acls, err := a.Store.FindACLs(ctx, tenant.GameID(ctx), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make([]string, 0, len(acls))
+	for _, acl := range acls {
+		filters = append(filters, acl.Pubsub...)
+	}
+	return filterTopics(topics, func(topic string) bool {
+		return matchAny(filters, topic)
+	}), nil
+}
+
+// matchAny tells whether the concrete topic matches any of the topic filters
+func matchAny(filters []string, topic string) bool {
+	if models.IsTopicFilter(topic) {
+		return false
+	}
+	for _, filter := range filters {
+		if models.MatchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/storage"
+	"github.com/topfreegames/mqtt-history/tenant"
+)
+
+// Authorizer is implemented by the backends deciding which topics the users may read
+type Authorizer interface {
+	// Authorize returns, among topics, those userID may read
+	Authorize(ctx context.Context, userID string, topics []string) ([]string, error)
+}
+
+// Forgetter is implemented by the authorizers remembering decisions
+type Forgetter interface {
+	// Forget drops the decisions remembered for the user
+	Forget(userID string)
+}
+
+// Chain asks its authorizers in turn about the topics that the previous
+// ones did not grant. A topic is granted if any of them grants it
+type Chain []Authorizer
+
+// Authorize returns the topics granted by any authorizer of the chain, in
+// the requested order. It stops at the first error
+func (c Chain) Authorize(ctx context.Context, userID string, topics []string) ([]string, error) {
+	granted := make(map[string]bool, len(topics))
+	remaining := topics
+	for _, authorizer := range c {
+		if len(remaining) == 0 {
+			break
+		}
+
+		authorized, err := authorizer.Authorize(ctx, userID, remaining)
+		if err != nil {
+			return nil, err
+		}
+		for _, topic := range authorized {
+			granted[topic] = true
+		}
+		remaining = filterTopics(remaining, func(topic string) bool { return !granted[topic] })
+	}
+
+	return filterTopics(topics, func(topic string) bool { return granted[topic] }), nil
+}
+
+func filterTopics(topics []string, keep func(string) bool) []string {
+	filtered := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if keep(topic) {
+			filtered = append(filtered, topic)
+		}
+	}
+	return filtered
+}
+
+// NewAuthorizer returns the chain of the authorizers listed in
+// authorization.chain. Without it, the chain is derived from the
+// httpAuth.enabled flag: http, or else anonymous then mongo. The chain is
+// cached if authorization.cache.enabled is set
+func NewAuthorizer(config *viper.Viper, aclStore storage.ACLStore) (Authorizer, error) {
+	names := config.GetStringSlice("authorization.chain")
+	if len(names) == 0 {
+		names = []string{"anonymous", "mongo"}
+		if config.GetBool("httpAuth.enabled") {
+			names = []string{"http"}
+		}
+	}
+
+	chain := make(Chain, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case "static":
+			static, err := NewStaticAuthorizerFromFile(config.GetString("authorization.static.rulesFile"))
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, static)
+		case "anonymous":
+			chain = append(chain, NewAnonymousAuthorizer(config))
+		case "http":
+			chain = append(chain, NewHTTPAuthorizer(config))
+		case "mongo":
+			chain = append(chain, NewACLAuthorizer(aclStore))
+		default:
+			return nil, fmt.Errorf("unknown authorizer: %s", name)
+		}
+	}
+
+	if config.GetBool("authorization.cache.enabled") {
+		return NewCachedAuthorizerFromConfig(config, chain), nil
+	}
+	return chain, nil
+}
+
+// AnonymousAuthorizer grants every topic while mongo.allow_anonymous, or
+// the allowAnonymous override of the request's game, is enabled, and none
+// otherwise. The flag is read on every request
+type AnonymousAuthorizer struct {
+	Config *viper.Viper
+}
+
+// NewAnonymousAuthorizer returns an AnonymousAuthorizer reading the config
+func NewAnonymousAuthorizer(config *viper.Viper) *AnonymousAuthorizer {
+	return &AnonymousAuthorizer{Config: config}
+}
+
+// Authorize returns all the topics if anonymous access is allowed
+func (a *AnonymousAuthorizer) Authorize(ctx context.Context, userID string, topics []string) ([]string, error) {
+	if tenant.AllowAnonymous(a.Config, tenant.GameID(ctx)) {
+		return topics, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,195 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/authorization"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+type grantAuthorizer struct {
+	granted map[string]bool
+	asked   [][]string
+}
+
+func (a *grantAuthorizer) Authorize(ctx context.Context, userID string, topics []string) ([]string, error) {
+	a.asked = append(a.asked, topics)
+	authorized := make([]string, 0)
+	for _, topic := range topics {
+		if a.granted[topic] {
+			authorized = append(authorized, topic)
+		}
+	}
+	return authorized, nil
+}
+
+func TestAuthorizers(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	g.Describe("Chain", func() {
+		g.It("should only ask the next authorizers about the topics not granted yet", func() {
+			first := &grantAuthorizer{granted: map[string]bool{"chat/b": true}}
+			second := &grantAuthorizer{granted: map[string]bool{"chat/a": true, "chat/b": true}}
+			third := &grantAuthorizer{}
+
+			chain := authorization.Chain{first, second, third}
+			authorized, err := chain.Authorize(ctx, "user", []string{"chat/a", "chat/b", "chat/c"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a", "chat/b"})
+			g.Assert(second.asked).Equal([][]string{{"chat/a", "chat/c"}})
+			g.Assert(third.asked).Equal([][]string{{"chat/c"}})
+		})
+
+		g.It("should build the configured chain", func() {
+			config := viper.New()
+			config.Set("authorization.chain", []string{"anonymous", "unknown"})
+			_, err := authorization.NewAuthorizer(config, storage.NewMemoryACLStore())
+			Expect(err).NotTo(BeNil())
+
+			config.Set("authorization.chain", []string{"static"})
+			_, err = authorization.NewAuthorizer(config, storage.NewMemoryACLStore())
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	g.Describe("AnonymousAuthorizer", func() {
+		g.It("should grant every topic while anonymous access is allowed", func() {
+			config := viper.New()
+			authorizer := authorization.NewAnonymousAuthorizer(config)
+
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(len(authorized)).Equal(0)
+
+			config.Set("mongo.allow_anonymous", true)
+			authorized, err = authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+		})
+	})
+
+	g.Describe("ACLAuthorizer", func() {
+		g.It("should grant the topics matching the user's ACLs", func() {
+			store := storage.NewMemoryACLStore()
+			err := store.InsertACLs(ctx, []models.ACL{
+				{Username: "user", Pubsub: []string{"chat/#"}},
+				{Username: "other", Pubsub: []string{"clan/#"}},
+			})
+			Expect(err).To(BeNil())
+
+			authorizer := authorization.NewACLAuthorizer(store)
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a", "clan/a", "chat/+"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+		})
+	})
+
+	g.Describe("StaticAuthorizer", func() {
+		g.It("should apply the rules of a YAML file", func() {
+			path := filepath.Join(dir, "rules.yaml")
+			err := ioutil.WriteFile(path, []byte(`
+rules:
+  - users: ["test:*"]
+    topics: ["chat/#"]
+  - users: ["*"]
+    topics: ["private/{user}/+"]
+`), 0600)
+			Expect(err).To(BeNil())
+
+			authorizer, err := authorization.NewStaticAuthorizerFromFile(path)
+			Expect(err).To(BeNil())
+
+			authorized, err := authorizer.Authorize(ctx, "test:1", []string{"chat/a/b", "private/test:1/inbox", "private/other/inbox"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a/b", "private/test:1/inbox"})
+
+			authorized, err = authorizer.Authorize(ctx, "player", []string{"chat/a", "private/player/inbox"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"private/player/inbox"})
+
+			authorized, err = authorizer.Authorize(ctx, "#", []string{"private/a/inbox"})
+			Expect(err).To(BeNil())
+			g.Assert(len(authorized)).Equal(0)
+		})
+
+		g.It("should apply the rules of a JSON file", func() {
+			rules := map[string]interface{}{
+				"rules": []authorization.Rule{{Users: []string{"admin"}, Topics: []string{"#"}}},
+			}
+			encoded, err := json.Marshal(rules)
+			Expect(err).To(BeNil())
+			path := filepath.Join(dir, "rules.json")
+			err = ioutil.WriteFile(path, encoded, 0600)
+			Expect(err).To(BeNil())
+
+			authorizer, err := authorization.NewStaticAuthorizerFromFile(path)
+			Expect(err).To(BeNil())
+
+			authorized, err := authorizer.Authorize(ctx, "admin", []string{"chat/a", "$SYS/a"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+
+			authorized, err = authorizer.Authorize(ctx, "administrator", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(len(authorized)).Equal(0)
+		})
+
+		g.It("should reject invalid topic filters", func() {
+			_, err := authorization.NewStaticAuthorizer([]authorization.Rule{{Users: []string{"*"}, Topics: []string{"chat/#/a"}}})
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	g.Describe("HTTPAuthorizer", func() {
+		g.It("should grant the topics the auth API answers 200 for", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var request struct {
+					Username string `json:"username"`
+					Topic    string `json:"topic"`
+				}
+				_ = json.NewDecoder(r.Body).Decode(&request)
+				username, password, _ := r.BasicAuth()
+				if request.Topic == "chat/a" && request.Username == "user" && username == "iam" && password == "secret" {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				w.WriteHeader(http.StatusForbidden)
+			}))
+			defer server.Close()
+
+			config := viper.New()
+			config.Set("httpAuth.requestURL", server.URL)
+			config.Set("httpAuth.timeout", 1)
+			config.Set("httpAuth.iam.enabled", true)
+			config.Set("httpAuth.iam.credentials.username", "iam")
+			config.Set("httpAuth.iam.credentials.password", "secret")
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+		})
+	})
+}
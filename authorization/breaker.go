@@ -0,0 +1,83 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned while the circuit breaker fails fast
+var ErrCircuitOpen = errors.New("the auth API circuit breaker is open")
+
+// CircuitBreaker opens after Threshold consecutive failures and then fails
+// fast for OpenFor. After that, a single trial call is let through: its
+// success closes the breaker and its failure opens it again
+type CircuitBreaker struct {
+	Threshold int
+	OpenFor   time.Duration
+	// Now is the clock timing the open state
+	Now func() time.Time
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	trying    bool
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker. A threshold of zero
+// disables it
+func NewCircuitBreaker(threshold int, openFor time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, OpenFor: openFor, Now: time.Now}
+}
+
+// Allow returns ErrCircuitOpen if the call must not be made. Every allowed
+// call must be followed by Success, Failure or Abandon
+func (b *CircuitBreaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.Threshold <= 0 || b.failures < b.Threshold {
+		return nil
+	}
+	if b.trying || b.Now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+	b.trying = true
+	return nil
+}
+
+// Success records a call that reached the auth API
+func (b *CircuitBreaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures = 0
+	b.trying = false
+}
+
+// Failure records a call that did not reach the auth API
+func (b *CircuitBreaker) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.trying = false
+	if b.Threshold > 0 && b.failures >= b.Threshold {
+		b.openUntil = b.Now().Add(b.OpenFor)
+	}
+}
+
+// Abandon records a call given up before the auth API answered
+func (b *CircuitBreaker) Abandon() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.trying = false
+}
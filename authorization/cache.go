@@ -0,0 +1,195 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/tenant"
+)
+
+// CacheHits counts the authorization decisions served from the cache, by
+// result. Like the HTTP metrics, the collectors are registered once at init
+// so that the many authorizers created during tests do not collide
+var CacheHits = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "authorization_cache_hits_total",
+		Help: "Authorization decisions served from the cache.",
+	},
+	[]string{"result"},
+)
+
+// CacheMisses counts the authorization decisions missing from the cache
+var CacheMisses = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "authorization_cache_misses_total",
+		Help: "Authorization decisions missing from the cache.",
+	},
+)
+
+// pendingDecision is a lookup in flight, awaited by the concurrent misses
+// for the same key
+type pendingDecision struct {
+	done    chan struct{}
+	allowed bool
+	err     error
+}
+
+// CachedAuthorizer caches the decisions of another authorizer in an LRU
+// keyed by (game, user, topic), keeping allowed and denied topics for separate
+// TTLs. Errors are not cached. Concurrent misses for the same key share a
+// single lookup, and the misses of a request are looked up together
+type CachedAuthorizer struct {
+	Next     Authorizer
+	AllowTTL time.Duration
+	DenyTTL  time.Duration
+	// Now is the clock expiring the decisions
+	Now func() time.Time
+
+	mutex     sync.Mutex
+	decisions *decisions
+	pending   map[decisionKey]*pendingDecision
+}
+
+// NewCachedAuthorizer returns a CachedAuthorizer in front of next holding up
+// to size decisions
+func NewCachedAuthorizer(next Authorizer, size int, allowTTL, denyTTL time.Duration) *CachedAuthorizer {
+	return &CachedAuthorizer{
+		Next:      next,
+		AllowTTL:  allowTTL,
+		DenyTTL:   denyTTL,
+		Now:       time.Now,
+		decisions: newDecisions(size),
+		pending:   make(map[decisionKey]*pendingDecision),
+	}
+}
+
+// NewCachedAuthorizerFromConfig returns a CachedAuthorizer in front of next
+// configured by authorization.cache
+func NewCachedAuthorizerFromConfig(config *viper.Viper, next Authorizer) *CachedAuthorizer {
+	return NewCachedAuthorizer(
+		next,
+		config.GetInt("authorization.cache.size"),
+		time.Duration(config.GetInt("authorization.cache.allowTTL"))*time.Second,
+		time.Duration(config.GetInt("authorization.cache.denyTTL"))*time.Second,
+	)
+}
+
+// Authorize returns the cached decisions for the topics, asking the next
+// authorizer about the missing ones
+func (a *CachedAuthorizer) Authorize(ctx context.Context, userID string, topics []string) ([]string, error) {
+	allowed := make(map[string]bool, len(topics))
+	awaited := make(map[string]*pendingDecision)
+	led := make(map[string]*pendingDecision)
+	lookups := make([]string, 0)
+	gameID := tenant.GameID(ctx)
+
+	a.mutex.Lock()
+	for _, topic := range topics {
+		if _, ok := allowed[topic]; ok {
+			continue
+		}
+		if _, ok := awaited[topic]; ok {
+			continue
+		}
+		if _, ok := led[topic]; ok {
+			continue
+		}
+
+		key := decisionKey{gameID: gameID, userID: userID, topic: topic}
+		if decision, ok := a.decisions.get(key, a.Now()); ok {
+			CacheHits.WithLabelValues(resultLabel(decision)).Inc()
+			allowed[topic] = decision
+			continue
+		}
+		CacheMisses.Inc()
+
+		if pending, ok := a.pending[key]; ok {
+			awaited[topic] = pending
+			continue
+		}
+		pending := &pendingDecision{done: make(chan struct{})}
+		a.pending[key] = pending
+		led[topic] = pending
+		lookups = append(lookups, topic)
+	}
+	a.mutex.Unlock()
+
+	if len(lookups) > 0 {
+		authorized, err := a.Next.Authorize(ctx, userID, lookups)
+		granted := make(map[string]bool, len(authorized))
+		for _, topic := range authorized {
+			granted[topic] = true
+		}
+
+		a.mutex.Lock()
+		for topic, pending := range led {
+			key := decisionKey{gameID: gameID, userID: userID, topic: topic}
+			pending.allowed, pending.err = granted[topic], err
+			if err == nil {
+				a.add(key, pending.allowed)
+			}
+			delete(a.pending, key)
+			close(pending.done)
+		}
+		a.mutex.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		for _, topic := range lookups {
+			allowed[topic] = granted[topic]
+		}
+	}
+
+	for topic, pending := range awaited {
+		select {
+		case <-pending.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if pending.err != nil {
+			return nil, pending.err
+		}
+		allowed[topic] = pending.allowed
+	}
+
+	return filterTopics(topics, func(topic string) bool { return allowed[topic] }), nil
+}
+
+// add caches the decision for key for the TTL of its result. It must be
+// called with the mutex held
+func (a *CachedAuthorizer) add(key decisionKey, allowed bool) {
+	ttl := a.DenyTTL
+	if allowed {
+		ttl = a.AllowTTL
+	}
+	if ttl > 0 {
+		a.decisions.add(key, allowed, a.Now().Add(ttl))
+	}
+}
+
+// Forget drops the cached decisions of the user, for the next requests to
+// reflect a change of its permissions
+func (a *CachedAuthorizer) Forget(userID string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.decisions.removeUser(userID)
+}
+
+func resultLabel(allowed bool) string {
+	if allowed {
+		return "allow"
+	}
+	return "deny"
+}
@@ -0,0 +1,176 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/mqtt-history/authorization"
+)
+
+type countingAuthorizer struct {
+	granted map[string]bool
+	err     error
+	calls   int32
+	release chan struct{}
+	entered chan struct{}
+}
+
+func (a *countingAuthorizer) Authorize(ctx context.Context, userID string, topics []string) ([]string, error) {
+	atomic.AddInt32(&a.calls, 1)
+	if a.entered != nil {
+		a.entered <- struct{}{}
+	}
+	if a.release != nil {
+		<-a.release
+	}
+	if a.err != nil {
+		return nil, a.err
+	}
+	authorized := make([]string, 0)
+	for _, topic := range topics {
+		if a.granted[topic] {
+			authorized = append(authorized, topic)
+		}
+	}
+	return authorized, nil
+}
+
+func TestCachedAuthorizer(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	ctx := context.Background()
+
+	g.Describe("CachedAuthorizer", func() {
+		g.It("should keep allowed and denied topics for their own TTLs", func() {
+			now := time.Now()
+			next := &countingAuthorizer{granted: map[string]bool{"chat/a": true}}
+			authorizer := authorization.NewCachedAuthorizer(next, 10, time.Minute, 10*time.Second)
+			authorizer.Now = func() time.Time { return now }
+
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+			g.Assert(next.calls).Equal(int32(1))
+
+			authorized, err = authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+			g.Assert(next.calls).Equal(int32(1))
+
+			// the denial expired, the grant did not
+			now = now.Add(30 * time.Second)
+			next.granted["chat/b"] = true
+			authorized, err = authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a", "chat/b"})
+			g.Assert(next.calls).Equal(int32(2))
+
+			// the decisions are per user
+			_, err = authorizer.Authorize(ctx, "other", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(next.calls).Equal(int32(3))
+		})
+
+		g.It("should evict the least recently used decisions", func() {
+			next := &countingAuthorizer{granted: map[string]bool{}}
+			authorizer := authorization.NewCachedAuthorizer(next, 2, time.Minute, time.Minute)
+
+			_, err := authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b"})
+			Expect(err).To(BeNil())
+			_, err = authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			_, err = authorizer.Authorize(ctx, "user", []string{"chat/c"})
+			Expect(err).To(BeNil())
+			g.Assert(next.calls).Equal(int32(2))
+
+			_, err = authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(next.calls).Equal(int32(2))
+
+			_, err = authorizer.Authorize(ctx, "user", []string{"chat/b"})
+			Expect(err).To(BeNil())
+			g.Assert(next.calls).Equal(int32(3))
+		})
+
+		g.It("should forget the decisions of a user", func() {
+			next := &countingAuthorizer{granted: map[string]bool{"chat/a": true}}
+			authorizer := authorization.NewCachedAuthorizer(next, 10, time.Minute, time.Minute)
+
+			_, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			_, err = authorizer.Authorize(ctx, "other", []string{"chat/a"})
+			Expect(err).To(BeNil())
+
+			authorizer.Forget("user")
+			next.granted["chat/a"] = false
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(len(authorized)).Equal(0)
+			authorized, err = authorizer.Authorize(ctx, "other", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+			g.Assert(next.calls).Equal(int32(3))
+		})
+
+		g.It("should not cache errors", func() {
+			next := &countingAuthorizer{err: errors.New("unavailable")}
+			authorizer := authorization.NewCachedAuthorizer(next, 10, time.Minute, time.Minute)
+
+			_, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).NotTo(BeNil())
+
+			next.err = nil
+			next.granted = map[string]bool{"chat/a": true}
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+			g.Assert(next.calls).Equal(int32(2))
+		})
+
+		g.It("should coalesce concurrent misses for the same key", func() {
+			next := &countingAuthorizer{
+				granted: map[string]bool{"chat/a": true},
+				release: make(chan struct{}),
+				entered: make(chan struct{}, 10),
+			}
+			authorizer := authorization.NewCachedAuthorizer(next, 10, time.Minute, time.Minute)
+
+			var wg sync.WaitGroup
+			results := make([][]string, 5)
+			authorize := func(i int) {
+				defer wg.Done()
+				results[i], _ = authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			}
+
+			wg.Add(len(results))
+			go authorize(0)
+			<-next.entered
+			for i := 1; i < len(results); i++ {
+				go authorize(i)
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(next.release)
+			wg.Wait()
+
+			g.Assert(next.calls).Equal(int32(1))
+			for _, result := range results {
+				g.Assert(result).Equal([]string{"chat/a"})
+			}
+		})
+	})
+}
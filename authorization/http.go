@@ -0,0 +1,337 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/tenant"
+	"golang.org/x/sync/errgroup"
+)
+
+// The answers to give while the auth API is unavailable
+const (
+	// OutageError fails the requests
+	OutageError = "error"
+	// OutageDeny denies every topic
+	OutageDeny = "deny"
+	// OutageCachedAllow grants the topics the auth API last granted to the
+	// user within CachedAllowTTL, and denies the others
+	OutageCachedAllow = "cachedAllow"
+)
+
+// errBatchUnsupported is returned when the auth API has no batch endpoint
+var errBatchUnsupported = errors.New("the auth API does not support batches")
+
+type authRequest struct {
+	Username string `json:"username"`
+	Topic    string `json:"topic"`
+	GameID   string `json:"game_id,omitempty"`
+}
+
+type batchAuthRequest struct {
+	Username string   `json:"username"`
+	Topics   []string `json:"topics"`
+	GameID   string   `json:"game_id,omitempty"`
+}
+
+type batchAuthResponse struct {
+	Topics []string `json:"topics"`
+}
+
+// HTTPAuthorizer asks an auth API about the topics. With the batch protocol,
+// the topics are sent together to BatchURL, which answers the granted ones.
+// Otherwise, or if BatchURL answers 404, 405 or 501, each topic is posted to
+// RequestURL, up to Concurrency at a time, and a 200 answer grants it. After
+// such an answer, BatchURL is tried again once Breaker.OpenFor has passed.
+//
+// Transport errors and 502, 503 and 504 answers make the auth API
+// unavailable: as the requests only read decisions, they are retried up to
+// Retries times, and they open the circuit breaker. The topics are then
+// answered according to Outage
+type HTTPAuthorizer struct {
+	RequestURL string
+	Client     *http.Client
+	// Username and Password, when set, are sent as Basic Auth credentials
+	Username string
+	Password string
+
+	// BatchURL, when set, enables the batch protocol
+	BatchURL       string
+	BatchSize      int
+	Concurrency    int
+	Retries        int
+	RetryBackoff   time.Duration
+	Breaker        *CircuitBreaker
+	Outage         string
+	CachedAllowTTL time.Duration
+
+	// batchUnsupportedUntil is the Unix time, in nanoseconds, until which
+	// the topics are sent one by one
+	batchUnsupportedUntil int64
+	mutex                 sync.Mutex
+	granted               *decisions
+}
+
+// NewHTTPAuthorizer returns the HTTPAuthorizer configured in httpAuth
+func NewHTTPAuthorizer(config *viper.Viper) *HTTPAuthorizer {
+	authorizer := &HTTPAuthorizer{
+		RequestURL: config.GetString("httpAuth.requestURL"),
+		Client: &http.Client{
+			Timeout: time.Duration(config.GetInt("httpAuth.timeout")) * time.Second,
+		},
+		BatchSize:    config.GetInt("httpAuth.batch.size"),
+		Concurrency:  config.GetInt("httpAuth.concurrency"),
+		Retries:      config.GetInt("httpAuth.retries"),
+		RetryBackoff: time.Duration(config.GetInt("httpAuth.retryBackoff")) * time.Millisecond,
+		Breaker: NewCircuitBreaker(
+			config.GetInt("httpAuth.circuitBreaker.failures"),
+			time.Duration(config.GetInt("httpAuth.circuitBreaker.openFor"))*time.Second,
+		),
+		Outage:         config.GetString("httpAuth.outage.answer"),
+		CachedAllowTTL: time.Duration(config.GetInt("httpAuth.outage.cachedAllowTTL")) * time.Second,
+		granted:        newDecisions(config.GetInt("httpAuth.outage.cacheSize")),
+	}
+	if config.GetBool("httpAuth.iam.enabled") {
+		authorizer.Username = config.GetString("httpAuth.iam.credentials.username")
+		authorizer.Password = config.GetString("httpAuth.iam.credentials.password")
+	}
+	if config.GetBool("httpAuth.batch.enabled") {
+		authorizer.BatchURL = config.GetString("httpAuth.batch.requestURL")
+		if authorizer.BatchURL == "" {
+			authorizer.BatchURL = authorizer.RequestURL
+		}
+	}
+	return authorizer
+}
+
+// Authorize returns the topics the auth API granted to the user
+func (a *HTTPAuthorizer) Authorize(ctx context.Context, userID string, topics []string) ([]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "http_authorize")
+	defer span.Finish()
+
+	var authorized []string
+	err := errBatchUnsupported
+	now := a.Breaker.Now()
+	if a.BatchURL != "" && now.UnixNano() >= atomic.LoadInt64(&a.batchUnsupportedUntil) {
+		authorized, err = a.authorizeBatches(ctx, userID, topics)
+		if err == errBatchUnsupported {
+			logger.Logger.Warningf(
+				"The auth API does not support batches, falling back to single topic requests for %s",
+				a.Breaker.OpenFor,
+			)
+			atomic.StoreInt64(&a.batchUnsupportedUntil, now.Add(a.Breaker.OpenFor).UnixNano())
+		}
+	}
+	if err == errBatchUnsupported {
+		authorized, err = a.authorizeEach(ctx, userID, topics)
+	}
+
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error authorizing user"))
+		return a.outage(ctx, userID, topics, err)
+	}
+	a.remember(tenant.GameID(ctx), userID, topics, authorized)
+	return authorized, nil
+}
+
+func (a *HTTPAuthorizer) authorizeBatches(ctx context.Context, userID string, topics []string) ([]string, error) {
+	size := a.BatchSize
+	if size <= 0 {
+		size = len(topics)
+	}
+
+	granted := make(map[string]bool, len(topics))
+	for start := 0; start < len(topics); start += size {
+		end := start + size
+		if end > len(topics) {
+			end = len(topics)
+		}
+
+		status, body, err := a.post(ctx, a.BatchURL, batchAuthRequest{Username: userID, Topics: topics[start:end], GameID: tenant.GameID(ctx)})
+		if err != nil {
+			return nil, err
+		}
+		switch status {
+		case http.StatusOK:
+		case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			return nil, errBatchUnsupported
+		default:
+			return nil, fmt.Errorf("the auth API answered %d to a batch", status)
+		}
+
+		var response batchAuthResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			return nil, fmt.Errorf("invalid batch answer from the auth API: %s", err)
+		}
+		for _, topic := range response.Topics {
+			granted[topic] = true
+		}
+	}
+
+	return filterTopics(topics, func(topic string) bool { return granted[topic] }), nil
+}
+
+func (a *HTTPAuthorizer) authorizeEach(ctx context.Context, userID string, topics []string) ([]string, error) {
+	granted := make([]bool, len(topics))
+	group, groupCtx := errgroup.WithContext(ctx)
+	if a.Concurrency > 0 {
+		group.SetLimit(a.Concurrency)
+	}
+	for i, topic := range topics {
+		i, topic := i, topic
+		group.Go(func() error {
+			status, _, err := a.post(groupCtx, a.RequestURL, authRequest{Username: userID, Topic: topic, GameID: tenant.GameID(ctx)})
+			granted[i] = status == http.StatusOK
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	authorized := make([]string, 0, len(topics))
+	for i, topic := range topics {
+		if granted[i] {
+			authorized = append(authorized, topic)
+		}
+	}
+	return authorized, nil
+}
+
+// post sends the payload to url, retrying while the auth API is
+// unavailable, and returns the status and body of the answer
+func (a *HTTPAuthorizer) post(ctx context.Context, url string, payload interface{}) (int, []byte, error) {
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := a.Breaker.Allow(); err != nil {
+			return 0, nil, err
+		}
+
+		status, body, err := a.send(ctx, url, jsonPayload)
+		if ctx.Err() != nil {
+			a.Breaker.Abandon()
+			return 0, nil, ctx.Err()
+		}
+		if err == nil && !unavailable(status) {
+			a.Breaker.Success()
+			return status, body, nil
+		}
+		a.Breaker.Failure()
+
+		if err == nil {
+			err = fmt.Errorf("the auth API answered %d", status)
+		}
+		if attempt >= a.Retries {
+			return 0, nil, err
+		}
+
+		select {
+		case <-time.After(a.RetryBackoff << uint(attempt)):
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+	}
+}
+
+func (a *HTTPAuthorizer) send(ctx context.Context, url string, jsonPayload []byte) (int, []byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonPayload))
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if a.Username != "" || a.Password != "" {
+		request.SetBasicAuth(a.Username, a.Password)
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		opentracing.GlobalTracer().Inject(
+			span.Context(),
+			opentracing.HTTPHeaders,
+			opentracing.HTTPHeadersCarrier(request.Header))
+	}
+
+	response, err := a.Client.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return response.StatusCode, body, nil
+}
+
+func unavailable(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// outage answers the topics while the auth API is unavailable
+func (a *HTTPAuthorizer) outage(ctx context.Context, userID string, topics []string, err error) ([]string, error) {
+	if ctx.Err() != nil {
+		return nil, err
+	}
+
+	switch a.Outage {
+	case OutageDeny:
+		logger.Logger.Warningf("The auth API is unavailable, denying the topics: %s", err.Error())
+		return []string{}, nil
+	case OutageCachedAllow:
+		logger.Logger.Warningf("The auth API is unavailable, granting the recently granted topics: %s", err.Error())
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		now := time.Now()
+		gameID := tenant.GameID(ctx)
+		return filterTopics(topics, func(topic string) bool {
+			allowed, ok := a.granted.get(decisionKey{gameID: gameID, userID: userID, topic: topic}, now)
+			return ok && allowed
+		}), nil
+	default:
+		return nil, err
+	}
+}
+
+// remember keeps the decisions to answer with during an outage
+func (a *HTTPAuthorizer) remember(gameID, userID string, topics, authorized []string) {
+	if a.Outage != OutageCachedAllow || a.CachedAllowTTL <= 0 {
+		return
+	}
+
+	allowed := make(map[string]bool, len(authorized))
+	for _, topic := range authorized {
+		allowed[topic] = true
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	expiresAt := time.Now().Add(a.CachedAllowTTL)
+	for _, topic := range topics {
+		a.granted.add(decisionKey{gameID: gameID, userID: userID, topic: topic}, allowed[topic], expiresAt)
+	}
+}
@@ -0,0 +1,269 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/authorization"
+)
+
+// authAPI is a fake auth API granting the topics of granted, with a batch
+// endpoint at /batch when batch is set
+type authAPI struct {
+	mutex    sync.Mutex
+	granted  map[string]bool
+	batch    bool
+	status   int
+	failures int
+	delay    time.Duration
+	requests map[string]int
+	inFlight int
+	maxFlux  int
+}
+
+func (a *authAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mutex.Lock()
+	a.requests[r.URL.Path]++
+	a.inFlight++
+	if a.inFlight > a.maxFlux {
+		a.maxFlux = a.inFlight
+	}
+	failing := a.failures > 0
+	if failing {
+		a.failures--
+	}
+	a.mutex.Unlock()
+
+	defer func() {
+		a.mutex.Lock()
+		a.inFlight--
+		a.mutex.Unlock()
+	}()
+
+	time.Sleep(a.delay)
+	if failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if a.status != 0 {
+		w.WriteHeader(a.status)
+		return
+	}
+
+	if r.URL.Path == "/batch" {
+		if !a.batch {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var request struct {
+			Topics []string `json:"topics"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		granted := make([]string, 0)
+		for _, topic := range request.Topics {
+			if a.granted[topic] {
+				granted = append(granted, topic)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string][]string{"topics": granted})
+		return
+	}
+
+	var request struct {
+		Topic string `json:"topic"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&request)
+	if a.granted[request.Topic] {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusForbidden)
+}
+
+func (a *authAPI) count(path string) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.requests[path]
+}
+
+func TestHTTPAuthorizer(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	ctx := context.Background()
+	var api *authAPI
+	var server *httptest.Server
+	var config *viper.Viper
+
+	g.Describe("HTTPAuthorizer", func() {
+		g.BeforeEach(func() {
+			api = &authAPI{
+				granted:  map[string]bool{"chat/a": true, "chat/c": true},
+				requests: map[string]int{},
+			}
+			server = httptest.NewServer(api)
+			config = viper.New()
+			config.Set("httpAuth.requestURL", server.URL+"/auth")
+			config.Set("httpAuth.timeout", 1)
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("should send the topics in batches", func() {
+			api.batch = true
+			config.Set("httpAuth.batch.enabled", true)
+			config.Set("httpAuth.batch.requestURL", server.URL+"/batch")
+			config.Set("httpAuth.batch.size", 2)
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b", "chat/c"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a", "chat/c"})
+			g.Assert(api.count("/batch")).Equal(2)
+			g.Assert(api.count("/auth")).Equal(0)
+		})
+
+		g.It("should fall back to single topic requests without a batch endpoint", func() {
+			config.Set("httpAuth.batch.enabled", true)
+			config.Set("httpAuth.batch.requestURL", server.URL+"/batch")
+			config.Set("httpAuth.circuitBreaker.openFor", 60)
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			for i := 0; i < 2; i++ {
+				authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b", "chat/c"})
+				Expect(err).To(BeNil())
+				g.Assert(authorized).Equal([]string{"chat/a", "chat/c"})
+			}
+			g.Assert(api.count("/batch")).Equal(1)
+			g.Assert(api.count("/auth")).Equal(6)
+		})
+
+		g.It("should try the batch endpoint again once the circuit breaker open time has passed", func() {
+			config.Set("httpAuth.batch.enabled", true)
+			config.Set("httpAuth.batch.requestURL", server.URL+"/batch")
+			config.Set("httpAuth.circuitBreaker.openFor", 60)
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			_, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(api.count("/batch")).Equal(1)
+
+			api.batch = true
+			_, err = authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(api.count("/batch")).Equal(1)
+
+			authorizer.Breaker.Now = func() time.Time { return time.Now().Add(time.Minute) }
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+			g.Assert(api.count("/batch")).Equal(2)
+			g.Assert(api.count("/auth")).Equal(2)
+		})
+
+		g.It("should bound the concurrent single topic requests", func() {
+			api.delay = 20 * time.Millisecond
+			config.Set("httpAuth.concurrency", 2)
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			topics := []string{"chat/a", "chat/b", "chat/c", "chat/d", "chat/e", "chat/f"}
+			authorized, err := authorizer.Authorize(ctx, "user", topics)
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a", "chat/c"})
+			g.Assert(api.count("/auth")).Equal(6)
+			g.Assert(api.maxFlux <= 2).IsTrue()
+		})
+
+		g.It("should retry while the auth API is unavailable", func() {
+			api.failures = 2
+			config.Set("httpAuth.retries", 2)
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+			g.Assert(api.count("/auth")).Equal(3)
+		})
+
+		g.It("should not retry the other answers", func() {
+			api.status = http.StatusInternalServerError
+			config.Set("httpAuth.retries", 2)
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(len(authorized)).Equal(0)
+			g.Assert(api.count("/auth")).Equal(1)
+		})
+
+		g.It("should fail fast once the circuit breaker opens", func() {
+			api.failures = 100
+			config.Set("httpAuth.circuitBreaker.failures", 2)
+			config.Set("httpAuth.circuitBreaker.openFor", 60)
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			for i := 0; i < 2; i++ {
+				_, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+				Expect(err).NotTo(BeNil())
+			}
+			_, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			g.Assert(err).Equal(authorization.ErrCircuitOpen)
+			g.Assert(api.count("/auth")).Equal(2)
+
+			// a successful trial closes it after the open period
+			api.failures = 0
+			authorizer.Breaker.Now = func() time.Time { return time.Now().Add(time.Minute) }
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+		})
+
+		g.It("should deny the topics during an outage if configured", func() {
+			api.failures = 100
+			config.Set("httpAuth.outage.answer", authorization.OutageDeny)
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(len(authorized)).Equal(0)
+		})
+
+		g.It("should grant the recently granted topics during an outage if configured", func() {
+			config.Set("httpAuth.outage.answer", authorization.OutageCachedAllow)
+			config.Set("httpAuth.outage.cachedAllowTTL", 60)
+			config.Set("httpAuth.outage.cacheSize", 10)
+
+			authorizer := authorization.NewHTTPAuthorizer(config)
+			authorized, err := authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+
+			api.failures = 100
+			authorized, err = authorizer.Authorize(ctx, "user", []string{"chat/a", "chat/b", "chat/c"})
+			Expect(err).To(BeNil())
+			g.Assert(authorized).Equal([]string{"chat/a"})
+
+			authorized, err = authorizer.Authorize(ctx, "other", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(len(authorized)).Equal(0)
+		})
+	})
+}
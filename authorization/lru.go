@@ -0,0 +1,90 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization
+
+import (
+	"container/list"
+	"time"
+)
+
+// decisionKey scopes the decisions to the game of the request, since the
+// authorizers may answer differently for each game
+type decisionKey struct {
+	gameID string
+	userID string
+	topic  string
+}
+
+type decision struct {
+	key       decisionKey
+	allowed   bool
+	expiresAt time.Time
+}
+
+// decisions is an LRU of expiring authorization decisions. It is not safe
+// for concurrent use
+type decisions struct {
+	size    int
+	entries map[decisionKey]*list.Element
+	recency *list.List
+}
+
+func newDecisions(size int) *decisions {
+	return &decisions{
+		size:    size,
+		entries: make(map[decisionKey]*list.Element),
+		recency: list.New(),
+	}
+}
+
+// get returns the decision kept for key if it has not expired at now
+func (d *decisions) get(key decisionKey, now time.Time) (bool, bool) {
+	element, ok := d.entries[key]
+	if !ok {
+		return false, false
+	}
+	entry := element.Value.(*decision)
+	if !now.Before(entry.expiresAt) {
+		d.recency.Remove(element)
+		delete(d.entries, key)
+		return false, false
+	}
+	d.recency.MoveToFront(element)
+	return entry.allowed, true
+}
+
+// add keeps the decision for key until expiresAt, evicting the least
+// recently used decisions beyond the size
+func (d *decisions) add(key decisionKey, allowed bool, expiresAt time.Time) {
+	if d.size <= 0 {
+		return
+	}
+
+	entry := &decision{key: key, allowed: allowed, expiresAt: expiresAt}
+	if element, ok := d.entries[key]; ok {
+		element.Value = entry
+		d.recency.MoveToFront(element)
+		return
+	}
+	d.entries[key] = d.recency.PushFront(entry)
+	for d.recency.Len() > d.size {
+		oldest := d.recency.Back()
+		d.recency.Remove(oldest)
+		delete(d.entries, oldest.Value.(*decision).key)
+	}
+}
+
+// removeUser drops the decisions of the user
+func (d *decisions) removeUser(userID string) {
+	for key, element := range d.entries {
+		if key.userID == userID {
+			d.recency.Remove(element)
+			delete(d.entries, key)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package authorization
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/models"
+)
+
+// userPlaceholder is replaced by the user ID in the topic filters of the rules
+const userPlaceholder = "{user}"
+
+// Rule grants the users matching any of Users the topics matching any of
+// Topics. The user patterns may hold * wildcards, matching any characters,
+// and the topic filters the {user} placeholder
+type Rule struct {
+	Users  []string `mapstructure:"users"`
+	Topics []string `mapstructure:"topics"`
+}
+
+type compiledRule struct {
+	users  []*regexp.Regexp
+	topics []string
+}
+
+// StaticAuthorizer grants the topics according to a fixed list of rules
+type StaticAuthorizer struct {
+	rules []compiledRule
+}
+
+// NewStaticAuthorizer validates the rules and returns a StaticAuthorizer applying them
+func NewStaticAuthorizer(rules []Rule) (*StaticAuthorizer, error) {
+	authorizer := &StaticAuthorizer{rules: make([]compiledRule, 0, len(rules))}
+	for i, rule := range rules {
+		compiled := compiledRule{topics: rule.Topics}
+		for _, user := range rule.Users {
+			pattern := "^" + strings.Replace(regexp.QuoteMeta(user), `\*`, ".*", -1) + "$"
+			compiled.users = append(compiled.users, regexp.MustCompile(pattern))
+		}
+		for _, topic := range rule.Topics {
+			if err := models.ValidateTopicFilter(topic); err != nil {
+				return nil, fmt.Errorf("rule %d: %s", i, err)
+			}
+		}
+		authorizer.rules = append(authorizer.rules, compiled)
+	}
+	return authorizer, nil
+}
+
+// NewStaticAuthorizerFromFile returns a StaticAuthorizer applying the rules
+// of a YAML or JSON file, told apart by its extension, holding a rules list
+func NewStaticAuthorizerFromFile(path string) (*StaticAuthorizer, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the static authorizer needs authorization.static.rulesFile")
+	}
+
+	file := viper.New()
+	file.SetConfigFile(path)
+	if err := file.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("could not read rules file %s: %s", path, err)
+	}
+
+	var rules []Rule
+	if err := file.UnmarshalKey("rules", &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %s", path, err)
+	}
+	return NewStaticAuthorizer(rules)
+}
+
+// Authorize returns the topics granted to the user by any rule
+func (a *StaticAuthorizer) Authorize(ctx context.Context, userID string, topics []string) ([]string, error) {
+	filters := make([]string, 0)
+	for _, rule := range a.rules {
+		if !matchUser(rule.users, userID) {
+			continue
+		}
+		for _, filter := range rule.topics {
+			if strings.Contains(filter, userPlaceholder) {
+				// a user ID holding wildcards or levels would widen the filter
+				if userID == "" || strings.ContainsAny(userID, "+#/") {
+					continue
+				}
+				filter = strings.Replace(filter, userPlaceholder, userID, -1)
+			}
+			filters = append(filters, filter)
+		}
+	}
+
+	return filterTopics(topics, func(topic string) bool {
+		return matchAny(filters, topic)
+	}), nil
+}
+
+func matchUser(patterns []*regexp.Regexp, userID string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(userID) {
+			return true
+		}
+	}
+	return false
+}
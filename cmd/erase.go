@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/logger"
+)
+
+var erasureMode string
+var erasureGameID string
+
+// eraseCmd represents the erase command
+var eraseCmd = &cobra.Command{
+	Use:   "erase PLAYER_ID",
+	Short: "erases all the messages of a player",
+	Long: `Deletes all the messages of a player, or redacts their message, original_payload and metadata
+while keeping tombstones, in the messages collections of every game, or of the game given by --game.
+Prints how many messages were erased in each collection, which is also recorded in the audit log.
+You can use environment variables to override configuration keys.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if erasureMode != app.ErasureRedact && erasureMode != app.ErasureDelete {
+			logger.Logger.Fatalf("Unknown erasure mode: %s", erasureMode)
+		}
+
+		options := app.ErasureOptions{
+			GameID:    erasureGameID,
+			PlayerID:  args[0],
+			Mode:      erasureMode,
+			Operator:  cliOperator(),
+			RequestID: uuid.NewV4().String(),
+		}
+		app := app.GetApp(
+			host,
+			port,
+			debug,
+			CfgFile,
+		)
+		report, err := app.ErasePlayerMessages(context.Background(), options)
+		if err != nil {
+			logger.Logger.Fatalf("Could not erase the messages, err: %s", err.Error())
+		}
+
+		output, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(output))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(eraseCmd)
+
+	eraseCmd.Flags().StringVarP(&erasureMode, "mode", "m", app.ErasureRedact, "Erasure mode: redact or delete")
+	eraseCmd.Flags().StringVarP(&erasureGameID, "game", "g", "", "Game whose messages are erased, every game if empty")
+	eraseCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug mode")
+}
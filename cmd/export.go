@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/logger"
+)
+
+var exportFormat string
+var exportZip bool
+var exportOutput string
+var exportGameID string
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export PLAYER_ID",
+	Short: "exports all the messages of a player",
+	Long: `Writes all the messages of a player, of every topic and date, as NDJSON or JSON, optionally in a zip
+archive along with a manifest, from the messages collections of every game, or of the game given by --game.
+The progress is reported on the standard error and the export is recorded in the audit log.
+You can use environment variables to override configuration keys.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if exportFormat != app.ExportNDJSON && exportFormat != app.ExportJSON {
+			logger.Logger.Fatalf("Unknown export format: %s", exportFormat)
+		}
+
+		options := app.ExportOptions{
+			GameID:   exportGameID,
+			PlayerID: args[0],
+			Format:   exportFormat,
+			Zip:      exportZip,
+			Progress: func(exported int64) {
+				fmt.Fprintf(os.Stderr, "Exported %d messages\n", exported)
+			},
+			Operator:  cliOperator(),
+			RequestID: uuid.NewV4().String(),
+		}
+		app := app.GetApp(
+			host,
+			port,
+			debug,
+			CfgFile,
+		)
+
+		var output io.Writer = os.Stdout
+		if exportOutput != "-" {
+			file, err := os.Create(exportOutput)
+			if err != nil {
+				logger.Logger.Fatalf("Could not create the output file, err: %s", err.Error())
+			}
+			defer file.Close()
+			output = file
+		}
+
+		manifest, err := app.ExportPlayerMessages(context.Background(), output, options)
+		if err != nil {
+			logger.Logger.Fatalf("Could not export the messages, err: %s", err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d messages of player %s\n", manifest.Messages, manifest.PlayerId)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", app.ExportNDJSON, "Export format: ndjson or json")
+	exportCmd.Flags().BoolVarP(&exportZip, "zip", "z", false, "Write the messages and a manifest in a zip archive")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "-", "Output file, the standard output if -")
+	exportCmd.Flags().StringVarP(&exportGameID, "game", "g", "", "Game whose messages are exported, every game if empty")
+	exportCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug mode")
+}
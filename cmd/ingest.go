@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/topfreegames/mqtt-history/app"
+	"github.com/topfreegames/mqtt-history/ingest"
+	"github.com/topfreegames/mqtt-history/logger"
+)
+
+// ingestCmd represents the ingest command
+var ingestCmd = &cobra.Command{
+	Use:   "ingest",
+	Short: "persists the messages of the mqtt topics",
+	Long: `Subscribes to the mqtt topics set in ingest.topics and persists every message published on them.
+You can use environment variables to override configuration keys.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		app := app.GetApp(
+			host,
+			port,
+			debug,
+			CfgFile,
+		)
+		ingestor := ingest.NewIngestor(app.Config, app.MessageStore)
+		if err := ingestor.Start(); err != nil {
+			logger.Logger.Fatalf("Could not start ingesting messages, err: %s", err.Error())
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		ingestor.Stop()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ingestCmd)
+
+	ingestCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug mode")
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"os/user"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -53,3 +54,13 @@ func initConfig() {
 		fmt.Printf("Error loading config: %v", err)
 	}
 }
+
+// cliOperator returns the operator recorded in the audit log by the
+// commands: the system user running them
+func cliOperator() string {
+	current, err := user.Current()
+	if err != nil {
+		return "cli"
+	}
+	return fmt.Sprintf("cli:%s", current.Username)
+}
@@ -3,8 +3,10 @@ module github.com/topfreegames/mqtt-history
 go 1.18
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
 	github.com/franela/goblin v0.0.0-20180407132755-cd5d08fb4ede
 	github.com/getsentry/raven-go v0.0.0-20160805001729-c9d3cc542ad1
+	github.com/golang-jwt/jwt/v4 v4.5.0
 	github.com/labstack/echo v2.0.3-0.20160926051323-04e6901d05b5+incompatible
 	github.com/newrelic/go-agent v1.4.0
 	github.com/onsi/gomega v1.10.1
@@ -18,6 +20,7 @@ require (
 	github.com/uber-go/zap v0.0.0-20160809182253-d11d2851fcab
 	github.com/uber/jaeger-client-go v2.30.0+incompatible
 	go.mongodb.org/mongo-driver v1.7.0
+	golang.org/x/sync v0.2.0
 )
 
 require (
@@ -29,6 +32,7 @@ require (
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/hashicorp/hcl v0.0.0-20160822214145-baeb59c71071 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/klauspost/compress v1.9.5 // indirect
@@ -61,10 +65,9 @@ require (
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
 	go.uber.org/atomic v1.10.0 // indirect
 	golang.org/x/crypto v0.0.0-20200302210943-78000ba7a073 // indirect
-	golang.org/x/net v0.7.0 // indirect
-	golang.org/x/sync v0.2.0 // indirect
+	golang.org/x/net v0.8.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
-	golang.org/x/text v0.7.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
@@ -0,0 +1,187 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+	"github.com/topfreegames/mqtt-history/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Ingestor subscribes to the configured MQTT topic filters and
+// persists every message published on them in the message store
+type Ingestor struct {
+	Config       *viper.Viper
+	MessageStore storage.MessageStore
+	Collection   string
+	client       mqtt.Client
+}
+
+// NewIngestor returns an Ingestor persisting the messages in messageStore
+func NewIngestor(config *viper.Viper, messageStore storage.MessageStore) *Ingestor {
+	config.SetDefault("mqttserver.host", "localhost")
+	config.SetDefault("mqttserver.port", 1883)
+	config.SetDefault("mqttserver.clientId", "mqtt-history-ingest")
+	config.SetDefault("mqttserver.cleanSession", false)
+	config.SetDefault("mqttserver.connectionTimeout", 10)
+	config.SetDefault("ingest.topics", []string{"chat/#"})
+	config.SetDefault("ingest.qos", 1)
+	config.SetDefault("ingest.fields.id", "id")
+	config.SetDefault("ingest.fields.playerId", "player_id")
+	config.SetDefault("ingest.fields.gameId", "game_id")
+	config.SetDefault("ingest.fields.message", "message")
+
+	return &Ingestor{
+		Config:       config,
+		MessageStore: messageStore,
+		Collection:   config.GetString("mongo.messages.collection"),
+	}
+}
+
+// Start connects to the MQTT broker and subscribes to the topic filters.
+// The subscriptions are renewed every time the client reconnects
+func (i *Ingestor) Start() error {
+	broker := fmt.Sprintf("tcp://%s:%d", i.Config.GetString("mqttserver.host"), i.Config.GetInt("mqttserver.port"))
+	timeout := time.Duration(i.Config.GetInt("mqttserver.connectionTimeout")) * time.Second
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(i.ClientID()).
+		SetUsername(i.Config.GetString("mqttserver.user")).
+		SetPassword(i.Config.GetString("mqttserver.pass")).
+		SetConnectTimeout(timeout).
+		SetCleanSession(i.Config.GetBool("mqttserver.cleanSession")).
+		// the messages are acked once stored, the broker sending again
+		// those left unacked when the client reconnects
+		SetAutoAckDisabled(true).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(i.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Logger.Warningf("Lost connection to the MQTT broker: %s", err.Error())
+		})
+
+	logger.Logger.Infof("Connecting to the MQTT broker at '%s'", broker)
+	i.client = mqtt.NewClient(opts)
+	token := i.client.Connect()
+	if !token.WaitTimeout(timeout) {
+		return fmt.Errorf("timed out connecting to the MQTT broker at %s", broker)
+	}
+	return token.Error()
+}
+
+// ClientID returns mqttserver.clientId suffixed by the instance, from
+// mqttserver.instanceId or else the host name, as the broker disconnects
+// the clients sharing an ID
+func (i *Ingestor) ClientID() string {
+	instanceID := i.Config.GetString("mqttserver.instanceId")
+	if instanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = uuid.NewV4().String()
+		}
+		instanceID = hostname
+	}
+	return fmt.Sprintf("%s-%s", i.Config.GetString("mqttserver.clientId"), instanceID)
+}
+
+// Stop disconnects from the MQTT broker
+func (i *Ingestor) Stop() {
+	if i.client != nil {
+		i.client.Disconnect(250)
+	}
+}
+
+func (i *Ingestor) subscribe(client mqtt.Client) {
+	qos := byte(i.Config.GetInt("ingest.qos"))
+	filters := make(map[string]byte)
+	for _, topic := range i.Config.GetStringSlice("ingest.topics") {
+		filters[topic] = qos
+	}
+
+	token := client.SubscribeMultiple(filters, func(_ mqtt.Client, message mqtt.Message) {
+		if err := i.HandleMessage(context.Background(), message.Topic(), message.Payload()); err != nil {
+			logger.Logger.Warningf("Error persisting message from topic %s: %s", message.Topic(), err.Error())
+			return
+		}
+		message.Ack()
+	})
+	if token.Wait() && token.Error() != nil {
+		logger.Logger.Errorf("Error subscribing to the topics %v: %s", filters, token.Error().Error())
+		return
+	}
+	logger.Logger.Infof("Subscribed to the topics %v", filters)
+}
+
+// HandleMessage converts a published message and inserts it in the message
+// store, in the collection of its game if it has one. The messages delivered
+// again are stored once, their id being derived from their topic and payload
+func (i *Ingestor) HandleMessage(ctx context.Context, topic string, payload []byte) error {
+	message, err := i.ToMessageV2(topic, payload, time.Now())
+	if err != nil {
+		return err
+	}
+
+	collection := i.Collection
+	if message.GameId != "" {
+		collection = tenant.Collection(i.Config, message.GameId)
+	}
+	return i.MessageStore.InsertMessages(ctx, collection, []*models.MessageV2{message})
+}
+
+// ToMessageV2 maps a message published at the given time into the
+// stored MessageV2 format. The payload must be a JSON object, the id,
+// player, game and message fields are read from the keys set in
+// ingest.fields. Without an id in the payload, a random one is given
+func (i *Ingestor) ToMessageV2(topic string, payload []byte, publishedAt time.Time) (*models.MessageV2, error) {
+	originalPayload := bson.M{}
+	if err := json.Unmarshal(payload, &originalPayload); err != nil {
+		return nil, fmt.Errorf("payload is not a JSON object: %s", err.Error())
+	}
+
+	id := payloadField(originalPayload, i.Config.GetString("ingest.fields.id"))
+	if id == "" {
+		id = uuid.NewV4().String()
+	}
+
+	return &models.MessageV2{
+		Id:        id,
+		Timestamp: publishedAt.Unix(),
+		Payload:   originalPayload,
+		Topic:     topic,
+		PlayerId:  payloadField(originalPayload, i.Config.GetString("ingest.fields.playerId")),
+		GameId:    payloadField(originalPayload, i.Config.GetString("ingest.fields.gameId")),
+		Message:   payloadField(originalPayload, i.Config.GetString("ingest.fields.message")),
+	}, nil
+}
+
+// payloadField returns the payload value of key as a string,
+// numeric player ids included
+func payloadField(payload bson.M, key string) string {
+	switch value := payload[key].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
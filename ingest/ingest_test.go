@@ -0,0 +1,208 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package ingest_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/ingest"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+func newTestIngestor() (*ingest.Ingestor, *storage.MemoryMessageStore) {
+	config := viper.New()
+	config.Set("mongo.messages.collection", "messages")
+	store := storage.NewMemoryMessageStore()
+	return ingest.NewIngestor(config, store), store
+}
+
+func TestIngestor(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	logger.SetupLogger("ERROR")
+
+	g.Describe("Ingestor", func() {
+		ctx := context.Background()
+
+		g.It("should map a published message into a MessageV2", func() {
+			ingestor, _ := newTestIngestor()
+			publishedAt := time.Unix(1600000000, 0)
+			payload := []byte(`{"player_id": 123, "game_id": "game", "message": "hello", "extra": true}`)
+
+			message, err := ingestor.ToMessageV2("chat/room", payload, publishedAt)
+			Expect(err).To(BeNil())
+			g.Assert(message.Id != "").IsTrue()
+			g.Assert(message.Timestamp).Equal(int64(1600000000))
+			g.Assert(message.Topic).Equal("chat/room")
+			g.Assert(message.PlayerId).Equal("123")
+			g.Assert(message.GameId).Equal("game")
+			g.Assert(message.Message).Equal("hello")
+			g.Assert(message.Payload["extra"]).Equal(true)
+			g.Assert(message.Blocked).IsFalse()
+		})
+
+		g.It("should read the fields from the configured payload keys", func() {
+			ingestor, _ := newTestIngestor()
+			ingestor.Config.Set("ingest.fields.playerId", "from")
+
+			message, err := ingestor.ToMessageV2("chat/room", []byte(`{"from": "player"}`), time.Now())
+			Expect(err).To(BeNil())
+			g.Assert(message.PlayerId).Equal("player")
+			g.Assert(message.GameId).Equal("")
+		})
+
+		g.It("should fail if the payload is not a JSON object", func() {
+			ingestor, _ := newTestIngestor()
+			_, err := ingestor.ToMessageV2("chat/room", []byte("not json"), time.Now())
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should persist the handled message in the messages collection", func() {
+			ingestor, store := newTestIngestor()
+			err := ingestor.HandleMessage(ctx, "chat/room", []byte(`{"player_id": "p1"}`))
+			Expect(err).To(BeNil())
+
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: "messages",
+				Topic:      "chat/room",
+				From:       time.Now().Unix(),
+				Limit:      10,
+			})
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].PlayerId).Equal("p1")
+		})
+
+		g.It("should store every publish of the same payload", func() {
+			ingestor, store := newTestIngestor()
+			payload := []byte(`{"player_id": "p1", "message": "gg"}`)
+			first, err := ingestor.ToMessageV2("chat/room", payload, time.Now())
+			Expect(err).To(BeNil())
+			second, err := ingestor.ToMessageV2("chat/room", payload, time.Now())
+			Expect(err).To(BeNil())
+			g.Assert(second.Id == first.Id).IsFalse()
+
+			Expect(ingestor.HandleMessage(ctx, "chat/room", payload)).To(BeNil())
+			Expect(ingestor.HandleMessage(ctx, "chat/room", payload)).To(BeNil())
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: "messages",
+				Topic:      "chat/room",
+				From:       time.Now().Unix(),
+				Limit:      10,
+			})
+			g.Assert(len(messages)).Equal(2)
+		})
+
+		g.It("should store once the messages delivered again with the id of the publisher", func() {
+			ingestor, store := newTestIngestor()
+			payload := []byte(`{"id": "m1", "player_id": "p1", "message": "gg"}`)
+			message, err := ingestor.ToMessageV2("chat/room", payload, time.Now())
+			Expect(err).To(BeNil())
+			g.Assert(message.Id).Equal("m1")
+
+			Expect(ingestor.HandleMessage(ctx, "chat/room", payload)).To(BeNil())
+			Expect(ingestor.HandleMessage(ctx, "chat/room", payload)).To(BeNil())
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: "messages",
+				Topic:      "chat/room",
+				From:       time.Now().Unix(),
+				Limit:      10,
+			})
+			g.Assert(len(messages)).Equal(1)
+		})
+
+		g.It("should suffix the client id by instance", func() {
+			ingestor, _ := newTestIngestor()
+			hostname, err := os.Hostname()
+			Expect(err).To(BeNil())
+			g.Assert(ingestor.ClientID()).Equal("mqtt-history-ingest-" + hostname)
+
+			ingestor.Config.Set("mqttserver.instanceId", "pod-1")
+			g.Assert(ingestor.ClientID()).Equal("mqtt-history-ingest-pod-1")
+		})
+
+		g.It("should persist the message in the collection of its game", func() {
+			ingestor, store := newTestIngestor()
+			ingestor.Config.Set("tenant.games.game1.collection", "game1_messages")
+			err := ingestor.HandleMessage(ctx, "chat/room", []byte(`{"player_id": "p1", "game_id": "game1"}`))
+			Expect(err).To(BeNil())
+
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: "game1_messages",
+				Topic:      "chat/room",
+				From:       time.Now().Unix(),
+				Limit:      10,
+				GameID:     "game1",
+			})
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].GameId).Equal("game1")
+		})
+	})
+}
+
+func TestIngestorBroker(t *testing.T) {
+	conn, err := net.DialTimeout("tcp", "localhost:1883", time.Second)
+	if err != nil {
+		t.Skip("no MQTT broker listening on localhost:1883, run make run-containers")
+	}
+	conn.Close()
+
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	logger.SetupLogger("ERROR")
+
+	g.Describe("Ingestor with a broker", func() {
+		ctx := context.Background()
+
+		g.It("should persist the messages published on the broker", func() {
+			ingestor, store := newTestIngestor()
+			topic := fmt.Sprintf("chat/ingest_%s", uuid.NewV4().String())
+			ingestor.Config.Set("ingest.topics", []string{topic})
+			ingestor.Config.Set("mqttserver.clientId", uuid.NewV4().String())
+			Expect(ingestor.Start()).To(BeNil())
+			defer ingestor.Stop()
+
+			publisher := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://localhost:1883"))
+			token := publisher.Connect()
+			token.Wait()
+			Expect(token.Error()).To(BeNil())
+			defer publisher.Disconnect(250)
+
+			// give the ingestor time to subscribe before publishing
+			time.Sleep(500 * time.Millisecond)
+			token = publisher.Publish(topic, 1, false, `{"player_id": "p1", "message": "hi"}`)
+			token.Wait()
+			Expect(token.Error()).To(BeNil())
+
+			Eventually(func() int {
+				return len(store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+					Collection: "messages",
+					Topic:      topic,
+					From:       time.Now().Unix(),
+					Limit:      10,
+				}))
+			}, 5*time.Second).Should(Equal(1))
+		})
+	})
+}
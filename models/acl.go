@@ -0,0 +1,13 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ACL is an entry of the mqtt_acl collection, granting the user
+// access to the topics (or topic filters) in Pubsub. With multi-tenancy,
+// it only grants them in the game of GameId
+type ACL struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	GameId   string             `bson:"game_id,omitempty"`
+	Username string             `bson:"username"`
+	Pubsub   []string           `bson:"pubsub"`
+}
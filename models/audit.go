@@ -0,0 +1,23 @@
+package models
+
+// AuditEntry records an access to the players' messages by an operator
+type AuditEntry struct {
+	Id       string `json:"id" bson:"id"`
+	Operator string `json:"operator" bson:"operator"`
+	// Action names the route or command that was used, e.g. ps_history
+	Action    string `json:"action" bson:"action"`
+	PlayerId  string `json:"player_id" bson:"player_id"`
+	GameId    string `json:"game_id,omitempty" bson:"game_id,omitempty"`
+	Topic     string `json:"topic" bson:"topic"`
+	From      int64  `json:"from" bson:"from"`
+	To        int64  `json:"to" bson:"to"`
+	IsBlocked bool   `json:"is_blocked" bson:"is_blocked"`
+	// Mode is the erasure mode of the gdpr_erase entries
+	Mode string `json:"mode,omitempty" bson:"mode,omitempty"`
+	// ResultCount is the number of messages returned to, or erased by, the operator
+	ResultCount int `json:"result_count" bson:"result_count"`
+	// Collections counts the messages of ResultCount in each collection
+	Collections map[string]int64 `json:"collections,omitempty" bson:"collections,omitempty"`
+	RequestId   string           `json:"request_id" bson:"request_id"`
+	Timestamp   int64            `json:"timestamp" bson:"timestamp"`
+}
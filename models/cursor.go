@@ -0,0 +1,63 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// Cursor is the position of a message in a history. Queries made with
+// a cursor resume strictly after the message it points to
+type Cursor struct {
+	Timestamp int64  `json:"ts"`
+	Id        string `json:"id"`
+}
+
+// TopicsCursor holds the cursor of every topic of a histories query
+// that still has messages to be paginated
+type TopicsCursor map[string]Cursor
+
+// CursorOf returns the cursor pointing to the message
+func CursorOf(message *MessageV2) Cursor {
+	return Cursor{Timestamp: message.Timestamp, Id: message.Id}
+}
+
+// Encode returns the opaque representation of the cursor sent to clients
+func (c Cursor) Encode() string {
+	return encodeCursor(c)
+}
+
+// Encode returns the opaque representation of the cursors sent to clients
+func (c TopicsCursor) Encode() string {
+	return encodeCursor(c)
+}
+
+// DecodeCursor parses a cursor encoded by Cursor.Encode
+func DecodeCursor(encoded string) (*Cursor, error) {
+	cursor := &Cursor{}
+	if err := decodeCursor(encoded, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// DecodeTopicsCursor parses a cursor encoded by TopicsCursor.Encode
+func DecodeTopicsCursor(encoded string) (TopicsCursor, error) {
+	cursor := TopicsCursor{}
+	if err := decodeCursor(encoded, &cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+func encodeCursor(cursor interface{}) string {
+	cursorBytes, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(cursorBytes)
+}
+
+func decodeCursor(encoded string, cursor interface{}) error {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(cursorBytes, cursor)
+}
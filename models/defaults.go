@@ -3,5 +3,7 @@ package models
 // Defaults saves the default configs
 type Defaults struct {
 	LimitOfMessages         int64
+	ForwardLimitOfMessages  int64
 	MongoMessagesCollection string
+	LimitOfWildcardTopics   int64
 }
@@ -27,4 +27,7 @@ type MessageV2 struct {
 	Blocked        bool   `json:"blocked" bson:"blocked"`
 	ShouldModerate bool   `json:"should_moderate" bson:"should_moderate"`
 	Metadata       bson.M `json:"metadata" bson:"metadata"`
+	// Redacted marks the tombstones of the messages erased at the
+	// request of their player, whose contents were dropped
+	Redacted bool `json:"redacted,omitempty" bson:"redacted,omitempty"`
 }
@@ -0,0 +1,37 @@
+package models
+
+// The actions of the moderation events
+const (
+	ModerationBlock   = "block"
+	ModerationUnblock = "unblock"
+)
+
+// ModerationEvent records the review of a message by a moderator
+type ModerationEvent struct {
+	Id        string `json:"id" bson:"id"`
+	MessageId string `json:"message_id" bson:"message_id"`
+	GameId    string `json:"game_id" bson:"game_id"`
+	Topic     string `json:"topic" bson:"topic"`
+	PlayerId  string `json:"player_id" bson:"player_id"`
+	// Action is ModerationBlock or ModerationUnblock
+	Action string `json:"action" bson:"action"`
+	Reason string `json:"reason" bson:"reason"`
+	// Moderator is the moderator who reviewed the message, and Operator
+	// the operator whose API key made the change
+	Moderator string `json:"moderator" bson:"moderator"`
+	Operator  string `json:"operator" bson:"operator"`
+	RequestId string `json:"request_id" bson:"request_id"`
+	Timestamp int64  `json:"timestamp" bson:"timestamp"`
+}
+
+// ModerationClaim is the lease of a message of the moderation queue to an
+// operator, so that no other operator reviews it until it expires
+type ModerationClaim struct {
+	GameId    string `json:"game_id,omitempty" bson:"game_id,omitempty"`
+	MessageId string `json:"message_id" bson:"message_id"`
+	// Operator is the operator whose API key holds the lease, and
+	// Moderator the moderator the operator named when claiming it
+	Operator  string `json:"operator" bson:"operator"`
+	Moderator string `json:"moderator,omitempty" bson:"moderator,omitempty"`
+	ExpiresAt int64  `json:"expires_at" bson:"expires_at"`
+}
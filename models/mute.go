@@ -0,0 +1,10 @@
+package models
+
+// MutedPlayer is a player muted by another, whose messages are left out of
+// the history of the player who muted them
+type MutedPlayer struct {
+	GameId        string `json:"game_id,omitempty" bson:"game_id,omitempty"`
+	PlayerId      string `json:"player_id" bson:"player_id"`
+	MutedPlayerId string `json:"muted_player_id" bson:"muted_player_id"`
+	CreatedAt     int64  `json:"created_at" bson:"created_at"`
+}
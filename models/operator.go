@@ -0,0 +1,47 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	// RoleSupportRead grants reading the players' unblocked messages
+	RoleSupportRead = "support:read"
+	// RoleSupportBlocked grants reading the players' blocked messages
+	RoleSupportBlocked = "support:blocked"
+	// RoleSupportAudit grants reading the audit log of the player support reads
+	RoleSupportAudit = "support:audit"
+	// RoleACLAdmin grants managing the users' topic permissions
+	RoleACLAdmin = "acl:admin"
+	// RoleModerationWrite grants blocking and unblocking the players' messages
+	RoleModerationWrite = "moderation:write"
+	// RoleGDPRErase grants erasing all the messages of a player
+	RoleGDPRErase = "gdpr:erase"
+	// RoleGDPRExport grants exporting all the messages of a player
+	RoleGDPRExport = "gdpr:export"
+)
+
+// Operator is a player support operator, authenticated by an API key.
+// Only the SHA-256 of the key is stored
+type Operator struct {
+	Name      string   `json:"name" bson:"name" mapstructure:"name"`
+	KeySha256 string   `json:"-" bson:"key_sha256" mapstructure:"keySha256"`
+	Roles     []string `json:"roles" bson:"roles" mapstructure:"roles"`
+}
+
+// HasRole tells whether the operator was granted the role
+func (o *Operator) HasRole(role string) bool {
+	for _, granted := range o.Roles {
+		if granted == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HashAPIKey returns the hex encoded SHA-256 of the API key, as stored in the operators
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,12 @@
+package models
+
+// ReadMarker is the position up to which a player has read a topic.
+// Timestamp is inclusive: the messages sent after it are unread
+type ReadMarker struct {
+	GameId    string `json:"game_id,omitempty" bson:"game_id,omitempty"`
+	PlayerId  string `json:"player_id" bson:"player_id"`
+	Topic     string `json:"topic" bson:"topic"`
+	Timestamp int64  `json:"timestamp" bson:"timestamp"`
+	MessageId string `json:"message_id,omitempty" bson:"message_id,omitempty"`
+	UpdatedAt int64  `json:"updated_at" bson:"updated_at"`
+}
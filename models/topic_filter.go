@@ -0,0 +1,96 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	singleLevelWildcard = "+"
+	multiLevelWildcard  = "#"
+)
+
+// IsTopicFilter tells whether the topic holds MQTT wildcards
+func IsTopicFilter(topic string) bool {
+	return strings.ContainsAny(topic, singleLevelWildcard+multiLevelWildcard)
+}
+
+// ValidateTopicFilter checks that the wildcards of filter follow the MQTT
+// spec: each one takes a whole level and # can only be the last level
+func ValidateTopicFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("empty topic filter")
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if level == multiLevelWildcard && i != len(levels)-1 {
+			return fmt.Errorf("invalid topic filter %s: # must be the last level", filter)
+		}
+		if level != singleLevelWildcard && level != multiLevelWildcard && IsTopicFilter(level) {
+			return fmt.Errorf("invalid topic filter %s: wildcards must take a whole level", filter)
+		}
+	}
+	return nil
+}
+
+// TopicFilterRegex returns the anchored regular expression matching the
+// topics of a valid filter. Its literal prefix lets MongoDB answer it
+// with the topic index. As in MQTT, wildcards on the first level do not
+// match the topics starting with $
+func TopicFilterRegex(filter string) string {
+	levels := strings.Split(filter, "/")
+	var regex strings.Builder
+	regex.WriteString("^")
+	for i, level := range levels {
+		switch {
+		case level == multiLevelWildcard && i == 0:
+			// # also matches the parent level, which is empty here
+			regex.WriteString(`([^$].*)?`)
+		case level == multiLevelWildcard:
+			// # also matches the parent level, so chat/# matches chat
+			regex.WriteString(`(/.*)?`)
+		default:
+			if i > 0 {
+				regex.WriteString("/")
+			}
+			if level == singleLevelWildcard && i == 0 {
+				regex.WriteString(`([^/$][^/]*)?`)
+			} else if level == singleLevelWildcard {
+				regex.WriteString(`[^/]*`)
+			} else {
+				regex.WriteString(regexp.QuoteMeta(level))
+			}
+		}
+	}
+	regex.WriteString("$")
+	return regex.String()
+}
+
+// MatchTopic tells whether the topic matches the MQTT topic filter.
+// + matches exactly one level, possibly empty, and # any number of
+// levels, including the parent one: chat/# matches chat. Wildcards on
+// the first level do not match the topics starting with $
+func MatchTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	if strings.HasPrefix(topic, "$") &&
+		(filterLevels[0] == singleLevelWildcard || filterLevels[0] == multiLevelWildcard) {
+		return false
+	}
+
+	for i, filterLevel := range filterLevels {
+		if filterLevel == multiLevelWildcard {
+			return i == len(filterLevels)-1
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if filterLevel != singleLevelWildcard && filterLevel != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
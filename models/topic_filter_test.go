@@ -0,0 +1,107 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package models_test
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	goblin "github.com/franela/goblin"
+	"github.com/topfreegames/mqtt-history/models"
+)
+
+func TestTopicFilter(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	matchTable := []struct {
+		filter  string
+		topic   string
+		matches bool
+	}{
+		// literal filters
+		{"chat/a", "chat/a", true},
+		{"chat/a", "chat/b", false},
+		{"chat/a", "chat/a/b", false},
+		{"chat/a/b", "chat/a", false},
+		{"chat/a", "chat/a/", false},
+		// single level wildcard
+		{"chat/+", "chat/a", true},
+		{"chat/+", "chat/", true},
+		{"chat/+", "chat", false},
+		{"chat/+", "chat/a/b", false},
+		{"chat/+/room", "chat/clan/room", true},
+		{"chat/+/room", "chat/clan/other", false},
+		{"chat/+/+", "chat/clan/room", true},
+		{"+/+", "/a", true},
+		{"+", "chat", true},
+		{"+", "chat/a", false},
+		// multi level wildcard
+		{"chat/#", "chat", true},
+		{"chat/#", "chat/a", true},
+		{"chat/#", "chat/a/b/c", true},
+		{"chat/#", "chats/a", false},
+		{"chat/+/#", "chat/clan/room/1", true},
+		{"chat/+/#", "chat/clan", true},
+		{"chat/+/#", "chat", false},
+		{"#", "chat/a", true},
+		{"#", "/chat", true},
+		// topics starting with $ are not matched by leading wildcards
+		{"#", "$SYS/broker", false},
+		{"+/broker", "$SYS/broker", false},
+		{"$SYS/#", "$SYS/broker", true},
+		{"$SYS/+", "$SYS/broker", true},
+		// regex characters are literal
+		{"chat/a.b", "chat/aXb", false},
+		{"chat/a.b", "chat/a.b", true},
+	}
+
+	g.Describe("MatchTopic", func() {
+		for _, test := range matchTable {
+			test := test
+			g.It(fmt.Sprintf("%s matching %s should be %v", test.filter, test.topic, test.matches), func() {
+				g.Assert(models.MatchTopic(test.filter, test.topic)).Equal(test.matches)
+			})
+		}
+	})
+
+	g.Describe("TopicFilterRegex", func() {
+		for _, test := range matchTable {
+			test := test
+			g.It(fmt.Sprintf("%s matching %s should be %v", test.filter, test.topic, test.matches), func() {
+				regex := regexp.MustCompile(models.TopicFilterRegex(test.filter))
+				g.Assert(regex.MatchString(test.topic)).Equal(test.matches)
+			})
+		}
+	})
+
+	g.Describe("ValidateTopicFilter", func() {
+		validationTable := []struct {
+			filter string
+			valid  bool
+		}{
+			{"chat/a", true},
+			{"chat/+/room", true},
+			{"chat/#", true},
+			{"#", true},
+			{"+", true},
+			{"", false},
+			{"chat/#/room", false},
+			{"chat/a#", false},
+			{"chat/a+", false},
+			{"chat/+a/room", false},
+		}
+
+		for _, test := range validationTable {
+			test := test
+			g.It(fmt.Sprintf("%q should be valid: %v", test.filter, test.valid), func() {
+				err := models.ValidateTopicFilter(test.filter)
+				g.Assert(err == nil).Equal(test.valid)
+			})
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package mongoclient
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CountMessagesV2 returns how many messages of queryParameters.Topic were
+// sent after queryParameters.Since. It is answered by the topic_timestamp
+// index, without fetching the documents
+func CountMessagesV2(ctx context.Context, queryParameters QueryParameters) (int64, error) {
+	mongoCollection, err := GetCollection(ctx, queryParameters.Collection)
+	if err != nil {
+		return 0, err
+	}
+
+	query := bson.M{
+		"topic": queryParameters.Topic,
+		"timestamp": bson.M{
+			"$gt": queryParameters.Since,
+		},
+		"blocked": queryParameters.IsBlocked,
+	}
+	scopeToGame(query, queryParameters)
+	excludePlayers(query, queryParameters)
+
+	statement := ExtractStatementForTrace(query, nil, 0)
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"count_messages_v2",
+		opentracing.Tags{
+			string(ext.DBStatement): statement,
+			string(ext.DBType):      "mongo",
+			string(ext.DBInstance):  mongoCollection.Database().Name(),
+			string(ext.DBUser):      user,
+			"collection":            mongoCollection.Name(),
+		},
+	)
+	defer span.Finish()
+
+	count, err := mongoCollection.CountDocuments(ctx, query)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error counting messages in MongoDB"))
+		return 0, err
+	}
+	return count, nil
+}
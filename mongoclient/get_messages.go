@@ -11,6 +11,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/topfreegames/mqtt-history/logger"
@@ -37,16 +38,35 @@ type MongoMessage struct {
 	Blocked        bool        `json:"blocked" bson:"blocked"`
 	ShouldModerate bool        `json:"should_moderate" bson:"should_moderate"`
 	Metadata       bson.M      `json:"metadata" bson:"metadata"`
+	Redacted       bool        `json:"redacted" bson:"redacted"`
 }
 
 type QueryParameters struct {
 	Collection string
 	Topic      string
-	From       int64
-	To         int64
-	Limit      int64
-	PlayerID   string
-	IsBlocked  bool
+	// Topics, when set, replaces Topic: the messages of all
+	// these topics are merged in a single timeline
+	Topics    []string
+	From      int64
+	To        int64
+	Limit     int64
+	PlayerID  string
+	IsBlocked bool
+	// Cursor, when set, replaces From: only the messages
+	// older than the one it points to are returned
+	Cursor *models.Cursor
+	// Forward queries return the messages sent after Since, or after
+	// Cursor when it is set, oldest first
+	Forward bool
+	Since   int64
+	// GameID, when set, scopes the queries to the messages of the game
+	GameID string
+	// ExcludedPlayerIDs leaves the messages of these players, muted by
+	// the user, out of the history queries
+	ExcludedPlayerIDs []string
+	// TopicsAfter pages the topics returned by GetTopicsV2: only
+	// those sorted after it are returned
+	TopicsAfter string
 }
 
 // GetMessages returns messages stored in MongoDB by topic
@@ -75,6 +95,20 @@ func ConvertMessageV2ToMessage(messagev2 *models.MessageV2) *models.Message {
 	}
 }
 
+// ConvertRawMessages converts the messages read from MongoDB to the
+// MessageV2 model, whose player ids are strings
+func ConvertRawMessages(rawMessages []MongoMessage) ([]*models.MessageV2, error) {
+	messages := make([]*models.MessageV2, len(rawMessages))
+	for i, rawMessage := range rawMessages {
+		message, err := convertRawMessageToModelMessage(rawMessage)
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = message
+	}
+	return messages, nil
+}
+
 func convertRawMessageToModelMessage(rawMessage MongoMessage) (*models.MessageV2, error) {
 	playerIdAsString, err := convertPlayerIdToString(rawMessage.PlayerId)
 	if err != nil {
@@ -92,6 +126,7 @@ func convertRawMessageToModelMessage(rawMessage MongoMessage) (*models.MessageV2
 		Blocked:        rawMessage.Blocked,
 		ShouldModerate: rawMessage.ShouldModerate,
 		Metadata:       rawMessage.Metadata,
+		Redacted:       rawMessage.Redacted,
 	}, nil
 }
 
@@ -127,6 +162,24 @@ func convertPlayerIdToString(playerID interface{}) (string, error) {
 	return "", fmt.Errorf("error converting player id to float64 or string. player id raw value: %s", playerID)
 }
 
+// PlayerIDFilter returns the filter matching the player id whether it was
+// stored as a string or as a number. MongoDB compares numbers regardless of
+// their type, so a single numeric value matches the int32, int64 and double ids
+func PlayerIDFilter(playerID string) bson.M {
+	return bson.M{"$in": PlayerIDValues(playerID)}
+}
+
+// PlayerIDValues returns the values the player id may have been stored as
+func PlayerIDValues(playerID string) bson.A {
+	values := bson.A{playerID}
+	if id, err := strconv.ParseInt(playerID, 10, 64); err == nil {
+		values = append(values, id)
+	} else if id, err := strconv.ParseFloat(playerID, 64); err == nil {
+		values = append(values, id)
+	}
+	return values
+}
+
 func GetMessagesPlayerSupportV2WithParameter(ctx context.Context, queryParameters QueryParameters) []*models.MessageV2 {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "get_messages_player_support_v2_with_parameter")
 	defer span.Finish()
@@ -168,8 +221,8 @@ func getMessagesPlayerSupportFromCollection(
 ) ([]MongoMessage, error) {
 	query := resolveQuery(queryParameters)
 	sort := bson.D{
-		{"topic", 1},
-		{"timestamp", -1},
+		{Key: "topic", Value: 1},
+		{Key: "timestamp", Value: -1},
 	}
 
 	statement := ExtractStatementForTrace(query, sort, queryParameters.Limit)
@@ -219,12 +272,34 @@ func resolveQuery(queryParameters QueryParameters) bson.M {
 	}
 
 	if queryParameters.PlayerID != "" {
-		query["player_id"] = queryParameters.PlayerID
+		// the former writers stored some player ids as numbers
+		query["player_id"] = PlayerIDFilter(queryParameters.PlayerID)
 	}
 
+	scopeToGame(query, queryParameters)
 	return query
 }
 
+// scopeToGame restricts the query to the messages of queryParameters.GameID
+func scopeToGame(query bson.M, queryParameters QueryParameters) {
+	if queryParameters.GameID != "" {
+		query["game_id"] = queryParameters.GameID
+	}
+}
+
+// excludePlayers leaves the messages of queryParameters.ExcludedPlayerIDs
+// out of the query, whether their player_id was stored as a string or a number
+func excludePlayers(query bson.M, queryParameters QueryParameters) {
+	if len(queryParameters.ExcludedPlayerIDs) == 0 {
+		return
+	}
+	values := bson.A{}
+	for _, playerID := range queryParameters.ExcludedPlayerIDs {
+		values = append(values, PlayerIDValues(playerID)...)
+	}
+	query["player_id"] = bson.M{"$nin": values}
+}
+
 func ExtractStatementForTrace(query bson.M, sort bson.D, limit int64) string {
 	queryCopy := make(map[string]interface{}, len(query))
 	for k, v := range query {
@@ -64,10 +64,35 @@ func getMessagesFromCollection(
 		},
 		"blocked": queryParameters.IsBlocked,
 	}
+	order := -1
+	if queryParameters.Forward {
+		order = 1
+		query["timestamp"] = bson.M{"$gt": queryParameters.Since}
+	}
+	if cursor := queryParameters.Cursor; cursor != nil {
+		inclusive, exclusive := "$lte", "$lt"
+		if queryParameters.Forward {
+			inclusive, exclusive = "$gte", "$gt"
+		}
+		query["timestamp"] = bson.M{inclusive: cursor.Timestamp}
+		query["$or"] = bson.A{
+			bson.M{"timestamp": bson.M{exclusive: cursor.Timestamp}},
+			bson.M{"timestamp": cursor.Timestamp, "id": bson.M{exclusive: cursor.Id}},
+		}
+	}
+	// id breaks the ties between messages sent in the same second,
+	// so that the cursors have a stable order to resume from
 	sort := bson.D{
-		{"topic", 1},
-		{"timestamp", -1},
+		{Key: "topic", Value: 1},
+		{Key: "timestamp", Value: order},
+		{Key: "id", Value: order},
+	}
+	if len(queryParameters.Topics) > 0 {
+		query["topic"] = bson.M{"$in": queryParameters.Topics}
+		sort = sort[1:]
 	}
+	scopeToGame(query, queryParameters)
+	excludePlayers(query, queryParameters)
 
 	statement := ExtractStatementForTrace(query, sort, queryParameters.Limit)
 	span, ctx := opentracing.StartSpanFromContext(
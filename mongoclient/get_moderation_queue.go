@@ -0,0 +1,78 @@
+package mongoclient
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/topfreegames/mqtt-history/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetModerationQueue returns the messages flagged by should_moderate sent
+// between queryParameters.From and queryParameters.To, or after
+// queryParameters.Cursor when it is set, oldest first. They are optionally
+// filtered by topic and player. To is ignored when zero
+func GetModerationQueue(ctx context.Context, queryParameters QueryParameters) ([]*models.MessageV2, error) {
+	mongoCollection, err := GetCollection(ctx, queryParameters.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	query := bson.M{"should_moderate": true}
+	timestamp := bson.M{"$gte": queryParameters.From}
+	if queryParameters.To != 0 {
+		timestamp["$lte"] = queryParameters.To
+	}
+	query["timestamp"] = timestamp
+	if cursor := queryParameters.Cursor; cursor != nil {
+		query["$or"] = bson.A{
+			bson.M{"timestamp": bson.M{"$gt": cursor.Timestamp}},
+			bson.M{"timestamp": cursor.Timestamp, "id": bson.M{"$gt": cursor.Id}},
+		}
+	}
+	if queryParameters.Topic != "" {
+		query["topic"] = queryParameters.Topic
+	}
+	if queryParameters.PlayerID != "" {
+		query["player_id"] = PlayerIDFilter(queryParameters.PlayerID)
+	}
+	scopeToGame(query, queryParameters)
+	sort := bson.D{
+		{Key: "timestamp", Value: 1},
+		{Key: "id", Value: 1},
+	}
+
+	statement := ExtractStatementForTrace(query, sort, queryParameters.Limit)
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"get_moderation_queue",
+		opentracing.Tags{
+			string(ext.DBStatement): statement,
+			string(ext.DBType):      "mongo",
+			string(ext.DBInstance):  mongoCollection.Database().Name(),
+			string(ext.DBUser):      user,
+			"collection":            mongoCollection.Name(),
+		},
+	)
+	defer span.Finish()
+
+	opts := options.Find()
+	opts.SetSort(sort)
+	opts.SetLimit(queryParameters.Limit)
+
+	cursor, err := mongoCollection.Find(ctx, query, opts)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding messages in MongoDB"))
+		return nil, err
+	}
+
+	rawResults := make([]MongoMessage, 0)
+	if err = cursor.All(ctx, &rawResults); err != nil {
+		ext.LogError(span, err, log.Message("Error decoding messages of a cursor from MongoDB"))
+		return nil, err
+	}
+	return ConvertRawMessages(rawResults)
+}
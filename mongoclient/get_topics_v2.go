@@ -0,0 +1,79 @@
+package mongoclient
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/topfreegames/mqtt-history/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetTopicsV2 returns, sorted, up to queryParameters.Limit distinct topics
+// after queryParameters.TopicsAfter with messages matching the MQTT topic
+// filter in queryParameters.Topic. Only the messages sent after
+// queryParameters.Since and, unless queryParameters.Forward, until
+// queryParameters.From are aggregated
+func GetTopicsV2(ctx context.Context, queryParameters QueryParameters) ([]string, error) {
+	mongoCollection, err := GetCollection(ctx, queryParameters.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	topic := bson.M{
+		"$regex": models.TopicFilterRegex(queryParameters.Topic),
+	}
+	if queryParameters.TopicsAfter != "" {
+		topic["$gt"] = queryParameters.TopicsAfter
+	}
+	timestamp := bson.M{"$gt": queryParameters.Since}
+	if !queryParameters.Forward {
+		timestamp["$lte"] = queryParameters.From
+	}
+	query := bson.M{
+		"topic":     topic,
+		"timestamp": timestamp,
+	}
+	scopeToGame(query, queryParameters)
+	pipeline := bson.A{
+		bson.M{"$match": query},
+		bson.M{"$group": bson.M{"_id": "$topic"}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$limit": queryParameters.Limit},
+	}
+
+	statement := ExtractStatementForTrace(query, nil, queryParameters.Limit)
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"get_topics_v2",
+		opentracing.Tags{
+			string(ext.DBStatement): statement,
+			string(ext.DBType):      "mongo",
+			string(ext.DBInstance):  mongoCollection.Database().Name(),
+			string(ext.DBUser):      user,
+			"collection":            mongoCollection.Name(),
+		},
+	)
+	defer span.Finish()
+
+	cursor, err := mongoCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error aggregating topics in MongoDB"))
+		return nil, err
+	}
+
+	var results []struct {
+		Topic string `bson:"_id"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		ext.LogError(span, err, log.Message("Error decoding topics of a cursor from MongoDB"))
+		return nil, err
+	}
+
+	topics := make([]string, len(results))
+	for i, result := range results {
+		topics[i] = result.Topic
+	}
+	return topics, nil
+}
@@ -0,0 +1,114 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Limit is a token bucket refilled with Rate tokens per second and holding
+// up to Burst tokens. Each request takes a token
+type Limit struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// Limiter is implemented by the backends holding the token buckets
+type Limiter interface {
+	// Allow takes a token from the bucket of key. When it is empty, it
+	// returns false and how long to wait for the next token
+	Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// NewLimiter returns the Limiter selected by rateLimit.backend. Only the
+// memory backend, local to the process, exists for now
+func NewLimiter(config *viper.Viper) (Limiter, error) {
+	backend := config.GetString("rateLimit.backend")
+	switch backend {
+	case "", "memory":
+		return NewMemoryLimiter(config.GetInt("rateLimit.maxKeys")), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend: %s", backend)
+	}
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// MemoryLimiter keeps the token buckets in memory. When it holds MaxKeys
+// buckets, the full ones are dropped: they are the same as new buckets
+type MemoryLimiter struct {
+	MaxKeys int
+	// Now is the clock refilling the buckets
+	Now func() time.Time
+
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryLimiter returns an empty MemoryLimiter
+func NewMemoryLimiter(maxKeys int) *MemoryLimiter {
+	return &MemoryLimiter{
+		MaxKeys: maxKeys,
+		Now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of key
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if l.MaxKeys > 0 && len(l.buckets) >= l.MaxKeys {
+			l.dropFull(now)
+		}
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+
+	b.limit = limit
+	b.tokens = refill(b, now)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	if limit.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64), nil
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// dropFull drops the buckets refilled up to their burst
+func (l *MemoryLimiter) dropFull(now time.Time) {
+	for key, b := range l.buckets {
+		if refill(b, now) >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// refill returns the tokens of the bucket at now
+func refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.updated).Seconds()*b.limit.Rate
+	return math.Min(tokens, float64(b.limit.Burst))
+}
@@ -0,0 +1,87 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/ratelimit"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	ctx := context.Background()
+
+	g.Describe("MemoryLimiter", func() {
+		g.It("should allow the burst and then refill the bucket at the rate", func() {
+			now := time.Now()
+			limiter := ratelimit.NewMemoryLimiter(0)
+			limiter.Now = func() time.Time { return now }
+			limit := ratelimit.Limit{Rate: 2, Burst: 3}
+
+			for i := 0; i < 3; i++ {
+				allowed, _, err := limiter.Allow(ctx, "user", limit)
+				Expect(err).To(BeNil())
+				g.Assert(allowed).IsTrue()
+			}
+			allowed, retryAfter, err := limiter.Allow(ctx, "user", limit)
+			Expect(err).To(BeNil())
+			g.Assert(allowed).IsFalse()
+			g.Assert(retryAfter).Equal(500 * time.Millisecond)
+
+			// the buckets are per key
+			allowed, _, err = limiter.Allow(ctx, "other", limit)
+			Expect(err).To(BeNil())
+			g.Assert(allowed).IsTrue()
+
+			now = now.Add(500 * time.Millisecond)
+			allowed, _, err = limiter.Allow(ctx, "user", limit)
+			Expect(err).To(BeNil())
+			g.Assert(allowed).IsTrue()
+			allowed, _, err = limiter.Allow(ctx, "user", limit)
+			Expect(err).To(BeNil())
+			g.Assert(allowed).IsFalse()
+		})
+
+		g.It("should drop the full buckets when holding too many keys", func() {
+			now := time.Now()
+			limiter := ratelimit.NewMemoryLimiter(2)
+			limiter.Now = func() time.Time { return now }
+			limit := ratelimit.Limit{Rate: 1, Burst: 1}
+
+			for _, key := range []string{"a", "b"} {
+				allowed, _, err := limiter.Allow(ctx, key, limit)
+				Expect(err).To(BeNil())
+				g.Assert(allowed).IsTrue()
+			}
+
+			// a and b are not full yet, so they are kept
+			allowed, _, err := limiter.Allow(ctx, "c", limit)
+			Expect(err).To(BeNil())
+			g.Assert(allowed).IsTrue()
+			allowed, _, err = limiter.Allow(ctx, "a", limit)
+			Expect(err).To(BeNil())
+			g.Assert(allowed).IsFalse()
+		})
+
+		g.It("should reject unknown backends", func() {
+			config := viper.New()
+			config.Set("rateLimit.backend", "redis")
+			_, err := ratelimit.NewLimiter(config)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+}
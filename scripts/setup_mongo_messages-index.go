@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/x/bsonx"
@@ -16,7 +18,15 @@ const (
 	databaseEnvVar   = "MONGO_DATABASE"
 	collectionEnvVar = "MONGO_COLLECTION"
 
+	readMarkersCollectionEnvVar      = "MONGO_READ_MARKERS_COLLECTION"
+	moderationClaimsCollectionEnvVar = "MONGO_MODERATION_CLAIMS_COLLECTION"
+	mutesCollectionEnvVar            = "MONGO_MUTES_COLLECTION"
+
 	TTL = 6 * 31 * 24 * time.Hour // 6 months
+
+	// the codes of the errors of dropping an index that does not exist
+	namespaceNotFoundCode = 26
+	indexNotFoundCode     = 27
 )
 
 var (
@@ -28,6 +38,9 @@ func main() {
 	address := getConfig(addressEnvVar, "mongodb://localhost:27017")
 	database := getConfig(databaseEnvVar, "chat")
 	collection := getConfig(collectionEnvVar, "messages")
+	readMarkersCollection := getConfig(readMarkersCollectionEnvVar, "read_markers")
+	moderationClaimsCollection := getConfig(moderationClaimsCollectionEnvVar, "moderation_claims")
+	mutesCollection := getConfig(mutesCollectionEnvVar, "muted_players")
 
 	const defaultTimeout = 10
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout*time.Second)
@@ -47,12 +60,30 @@ func main() {
 	}
 	fmt.Println("Created 'topic' index")
 
+	err = createGameIndex(coll)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Created 'game_topic' index")
+
 	err = createUserIndex(coll)
 	if err != nil {
 		panic(err)
 	}
 	fmt.Println("Created 'user_id' index")
 
+	err = createModerationQueueIndex(coll)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Created 'moderation_queue' index")
+
+	err = createIDIndex(coll)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Created 'topic_id' index")
+
 	err = createTTLIndex(coll, "timestamp", "messages_TTL")
 	if err != nil {
 		panic(err)
@@ -64,6 +95,38 @@ func main() {
 		panic(err)
 	}
 	fmt.Println("Created 'created_at_TTL' index")
+
+	// the markers and mute lists are now kept apart by game
+	err = dropIndex(db.Collection(readMarkersCollection), "player_topic")
+	if err != nil {
+		panic(err)
+	}
+	err = createReadMarkerIndex(db.Collection(readMarkersCollection))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Created 'game_player_topic' index")
+
+	// the claims are kept apart by game too
+	err = dropIndex(db.Collection(moderationClaimsCollection), "message_id")
+	if err != nil {
+		panic(err)
+	}
+	err = createModerationClaimIndex(db.Collection(moderationClaimsCollection))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Created 'game_message_id' index")
+
+	err = dropIndex(db.Collection(mutesCollection), "player_muted_player")
+	if err != nil {
+		panic(err)
+	}
+	err = createMuteIndex(db.Collection(mutesCollection))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Created 'game_player_muted_player' index")
 }
 
 func getConfig(envVar, fallback string) string {
@@ -97,6 +160,31 @@ func createTopicIndex(coll *mongo.Collection) error {
 	return createIndex(index, coll)
 }
 
+func createGameIndex(coll *mongo.Collection) error {
+	opts := options.Index()
+	opts.SetName("game_topic_timestamp")
+
+	index := mongo.IndexModel{
+		Keys: bsonx.Doc{
+			{
+				Key:   "game_id",
+				Value: ascending,
+			},
+			{
+				Key:   "topic",
+				Value: ascending,
+			},
+			{
+				Key:   "timestamp",
+				Value: descending,
+			},
+		},
+		Options: opts,
+	}
+
+	return createIndex(index, coll)
+}
+
 func createUserIndex(coll *mongo.Collection) error {
 	opts := options.Index()
 	opts.SetName("user_timestamp")
@@ -118,6 +206,130 @@ func createUserIndex(coll *mongo.Collection) error {
 	return createIndex(index, coll)
 }
 
+func createModerationQueueIndex(coll *mongo.Collection) error {
+	opts := options.Index()
+	opts.SetName("moderation_queue")
+
+	index := mongo.IndexModel{
+		Keys: bsonx.Doc{
+			{
+				Key:   "should_moderate",
+				Value: ascending,
+			},
+			{
+				Key:   "timestamp",
+				Value: ascending,
+			},
+			{
+				Key:   "id",
+				Value: ascending,
+			},
+		},
+		Options: opts,
+	}
+
+	return createIndex(index, coll)
+}
+
+// createIDIndex keeps the messages delivered again by the broker from being
+// stored twice, the messages stored without an id being left out
+func createIDIndex(coll *mongo.Collection) error {
+	opts := options.Index()
+	opts.SetName("topic_id")
+	opts.SetUnique(true)
+	opts.SetPartialFilterExpression(bson.M{"id": bson.M{"$type": "string"}})
+
+	index := mongo.IndexModel{
+		Keys: bsonx.Doc{
+			{
+				Key:   "topic",
+				Value: ascending,
+			},
+			{
+				Key:   "id",
+				Value: ascending,
+			},
+		},
+		Options: opts,
+	}
+
+	return createIndex(index, coll)
+}
+
+func createReadMarkerIndex(coll *mongo.Collection) error {
+	opts := options.Index()
+	opts.SetName("game_player_topic")
+	opts.SetUnique(true)
+
+	index := mongo.IndexModel{
+		Keys: bsonx.Doc{
+			{
+				Key:   "game_id",
+				Value: ascending,
+			},
+			{
+				Key:   "player_id",
+				Value: ascending,
+			},
+			{
+				Key:   "topic",
+				Value: ascending,
+			},
+		},
+		Options: opts,
+	}
+
+	return createIndex(index, coll)
+}
+
+func createModerationClaimIndex(coll *mongo.Collection) error {
+	opts := options.Index()
+	opts.SetName("game_message_id")
+	opts.SetUnique(true)
+
+	index := mongo.IndexModel{
+		Keys: bsonx.Doc{
+			{
+				Key:   "game_id",
+				Value: ascending,
+			},
+			{
+				Key:   "message_id",
+				Value: ascending,
+			},
+		},
+		Options: opts,
+	}
+
+	return createIndex(index, coll)
+}
+
+func createMuteIndex(coll *mongo.Collection) error {
+	opts := options.Index()
+	opts.SetName("game_player_muted_player")
+	opts.SetUnique(true)
+
+	index := mongo.IndexModel{
+		Keys: bsonx.Doc{
+			{
+				Key:   "game_id",
+				Value: ascending,
+			},
+			{
+				Key:   "player_id",
+				Value: ascending,
+			},
+			{
+				Key:   "muted_player_id",
+				Value: ascending,
+			},
+		},
+		Options: opts,
+	}
+
+	return createIndex(index, coll)
+}
+
 func createTTLIndex(coll *mongo.Collection, key, name string) error {
 	opts := options.Index()
 	opts.SetExpireAfterSeconds(int32(TTL / time.Second))
@@ -136,6 +348,16 @@ func createTTLIndex(coll *mongo.Collection, key, name string) error {
 	return createIndex(index, coll)
 }
 
+// dropIndex drops the index replaced by another one, if it exists
+func dropIndex(coll *mongo.Collection, name string) error {
+	_, err := coll.Indexes().DropOne(context.Background(), name)
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && (commandErr.Code == namespaceNotFoundCode || commandErr.Code == indexNotFoundCode) {
+		return nil
+	}
+	return err
+}
+
 func createIndex(index mongo.IndexModel, coll *mongo.Collection) error {
 	indexes := coll.Indexes()
 
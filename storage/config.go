@@ -0,0 +1,35 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/models"
+)
+
+// NewConfigOperatorStore returns a MemoryOperatorStore holding the
+// operators listed in the operatorAuth.operators config
+func NewConfigOperatorStore(config *viper.Viper) (*MemoryOperatorStore, error) {
+	var operators []models.Operator
+	if err := config.UnmarshalKey("operatorAuth.operators", &operators); err != nil {
+		return nil, err
+	}
+
+	store := NewMemoryOperatorStore()
+	for i := range operators {
+		if operators[i].Name == "" || len(operators[i].KeySha256) != 64 {
+			return nil, fmt.Errorf("operator %d needs a name and the hex SHA-256 of its key", i)
+		}
+		operators[i].KeySha256 = strings.ToLower(operators[i].KeySha256)
+	}
+	return store, store.InsertOperators(context.Background(), operators)
+}
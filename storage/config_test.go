@@ -0,0 +1,60 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package storage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+func TestConfigOperatorStore(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("ConfigOperatorStore", func() {
+		ctx := context.Background()
+
+		g.It("should find the configured operators by the hash of their key", func() {
+			config := viper.New()
+			config.Set("operatorAuth.operators", []map[string]interface{}{
+				{"name": "reader", "keySha256": strings.ToUpper(models.HashAPIKey("key")), "roles": []string{models.RoleSupportRead}},
+			})
+
+			store, err := storage.NewConfigOperatorStore(config)
+			Expect(err).To(BeNil())
+
+			operator, err := store.FindOperator(ctx, models.HashAPIKey("key"))
+			Expect(err).To(BeNil())
+			g.Assert(operator.Name).Equal("reader")
+			g.Assert(operator.HasRole(models.RoleSupportRead)).IsTrue()
+			g.Assert(operator.HasRole(models.RoleSupportBlocked)).IsFalse()
+
+			operator, err = store.FindOperator(ctx, models.HashAPIKey("other"))
+			Expect(err).To(BeNil())
+			g.Assert(operator == nil).IsTrue()
+		})
+
+		g.It("should reject the operators without the hash of their key", func() {
+			config := viper.New()
+			config.Set("operatorAuth.operators", []map[string]interface{}{
+				{"name": "reader", "keySha256": "key", "roles": []string{models.RoleSupportRead}},
+			})
+
+			_, err := storage.NewConfigOperatorStore(config)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+}
@@ -0,0 +1,840 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package storage
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	sharedMemoryMessageStore        *MemoryMessageStore
+	sharedMemoryMessageStoreOnce    sync.Once
+	sharedMemoryACLStore            *MemoryACLStore
+	sharedMemoryACLStoreOnce        sync.Once
+	sharedMemoryReadMarkerStore     *MemoryReadMarkerStore
+	sharedMemoryReadMarkerStoreOnce sync.Once
+	sharedMemoryMuteStore           *MemoryMuteStore
+	sharedMemoryMuteStoreOnce       sync.Once
+	sharedMemoryOperatorStore       *MemoryOperatorStore
+	sharedMemoryOperatorStoreOnce   sync.Once
+	sharedMemoryAuditStore          *MemoryAuditStore
+	sharedMemoryAuditStoreOnce      sync.Once
+	sharedMemoryModerationStore     *MemoryModerationStore
+	sharedMemoryModerationStoreOnce sync.Once
+)
+
+// SharedMemoryMessageStore returns the process wide MemoryMessageStore.
+// Like a MongoDB database, it is shared by every App of the process
+func SharedMemoryMessageStore() *MemoryMessageStore {
+	sharedMemoryMessageStoreOnce.Do(func() {
+		sharedMemoryMessageStore = NewMemoryMessageStore()
+	})
+	return sharedMemoryMessageStore
+}
+
+// SharedMemoryACLStore returns the process wide MemoryACLStore
+func SharedMemoryACLStore() *MemoryACLStore {
+	sharedMemoryACLStoreOnce.Do(func() {
+		sharedMemoryACLStore = NewMemoryACLStore()
+	})
+	return sharedMemoryACLStore
+}
+
+// SharedMemoryReadMarkerStore returns the process wide MemoryReadMarkerStore
+func SharedMemoryReadMarkerStore() *MemoryReadMarkerStore {
+	sharedMemoryReadMarkerStoreOnce.Do(func() {
+		sharedMemoryReadMarkerStore = NewMemoryReadMarkerStore()
+	})
+	return sharedMemoryReadMarkerStore
+}
+
+// SharedMemoryMuteStore returns the process wide MemoryMuteStore
+func SharedMemoryMuteStore() *MemoryMuteStore {
+	sharedMemoryMuteStoreOnce.Do(func() {
+		sharedMemoryMuteStore = NewMemoryMuteStore()
+	})
+	return sharedMemoryMuteStore
+}
+
+// SharedMemoryOperatorStore returns the process wide MemoryOperatorStore
+func SharedMemoryOperatorStore() *MemoryOperatorStore {
+	sharedMemoryOperatorStoreOnce.Do(func() {
+		sharedMemoryOperatorStore = NewMemoryOperatorStore()
+	})
+	return sharedMemoryOperatorStore
+}
+
+// SharedMemoryAuditStore returns the process wide MemoryAuditStore
+func SharedMemoryAuditStore() *MemoryAuditStore {
+	sharedMemoryAuditStoreOnce.Do(func() {
+		sharedMemoryAuditStore = NewMemoryAuditStore()
+	})
+	return sharedMemoryAuditStore
+}
+
+// SharedMemoryModerationStore returns the process wide MemoryModerationStore
+func SharedMemoryModerationStore() *MemoryModerationStore {
+	sharedMemoryModerationStoreOnce.Do(func() {
+		sharedMemoryModerationStore = NewMemoryModerationStore()
+	})
+	return sharedMemoryModerationStore
+}
+
+// MemoryMessageStore is a MessageStore that keeps the messages in memory.
+// It mimics the queries made to MongoDB and is meant for tests and local development
+type MemoryMessageStore struct {
+	mu          sync.RWMutex
+	collections map[string][]memoryMessage
+}
+
+// memoryMessage is a stored message along with its player_id as stored,
+// which is a number for some of the messages of the former writers
+type memoryMessage struct {
+	models.MessageV2
+	playerID interface{}
+}
+
+// NewMemoryMessageStore returns an empty MemoryMessageStore
+func NewMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{
+		collections: make(map[string][]memoryMessage),
+	}
+}
+
+// GetMessagesV2 returns the messages of a topic until queryParameters.From, or
+// after queryParameters.Since for forward queries. When queryParameters.Topics
+// is set, the messages of these topics are merged in a single timeline
+func (s *MemoryMessageStore) GetMessagesV2(ctx context.Context, queryParameters mongoclient.QueryParameters) []*models.MessageV2 {
+	less := newestFirst
+	inRange := isBefore
+	if queryParameters.Forward {
+		less = oldestFirst
+		inRange = isAfter
+	}
+
+	topics := map[string]bool{queryParameters.Topic: true}
+	if len(queryParameters.Topics) > 0 {
+		topics = make(map[string]bool, len(queryParameters.Topics))
+		for _, topic := range queryParameters.Topics {
+			topics[topic] = true
+		}
+	} else {
+		less = byTopic(less)
+	}
+
+	return s.find(queryParameters.Collection, queryParameters.Limit, less, func(message *memoryMessage) bool {
+		return topics[message.Topic] &&
+			inRange(message, queryParameters) &&
+			message.Blocked == queryParameters.IsBlocked &&
+			inGame(message, queryParameters) &&
+			!isExcluded(message, queryParameters)
+	})
+}
+
+// GetMessagesPlayerSupportV2 returns the messages between queryParameters.From and
+// queryParameters.To, filtered by topic and player when they are set
+func (s *MemoryMessageStore) GetMessagesPlayerSupportV2(ctx context.Context, queryParameters mongoclient.QueryParameters) []*models.MessageV2 {
+	return s.find(queryParameters.Collection, queryParameters.Limit, byTopic(newestFirst), func(message *memoryMessage) bool {
+		return message.Timestamp >= queryParameters.From &&
+			message.Timestamp <= queryParameters.To &&
+			message.Blocked == queryParameters.IsBlocked &&
+			(queryParameters.Topic == "" || message.Topic == queryParameters.Topic) &&
+			(queryParameters.PlayerID == "" || message.sentBy(queryParameters.PlayerID)) &&
+			inGame(message, queryParameters)
+	})
+}
+
+// CountMessagesV2 returns how many messages of a topic were sent after queryParameters.Since
+func (s *MemoryMessageStore) CountMessagesV2(ctx context.Context, queryParameters mongoclient.QueryParameters) (int64, error) {
+	messages := s.find(queryParameters.Collection, 0, newestFirst, func(message *memoryMessage) bool {
+		return message.Topic == queryParameters.Topic &&
+			message.Timestamp > queryParameters.Since &&
+			message.Blocked == queryParameters.IsBlocked &&
+			inGame(message, queryParameters) &&
+			!isExcluded(message, queryParameters)
+	})
+	return int64(len(messages)), nil
+}
+
+// GetTopicsV2 returns, sorted, up to queryParameters.Limit topics after
+// queryParameters.TopicsAfter matching the topic filter queryParameters.Topic,
+// with messages sent after queryParameters.Since and, unless
+// queryParameters.Forward, until queryParameters.From
+func (s *MemoryMessageStore) GetTopicsV2(ctx context.Context, queryParameters mongoclient.QueryParameters) ([]string, error) {
+	regex, err := regexp.Compile(models.TopicFilterRegex(queryParameters.Topic))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	matched := make(map[string]bool)
+	for i := range s.collections[queryParameters.Collection] {
+		message := &s.collections[queryParameters.Collection][i]
+		if regex.MatchString(message.Topic) &&
+			message.Topic > queryParameters.TopicsAfter &&
+			message.Timestamp > queryParameters.Since &&
+			(queryParameters.Forward || message.Timestamp <= queryParameters.From) &&
+			inGame(message, queryParameters) {
+			matched[message.Topic] = true
+		}
+	}
+	s.mu.RUnlock()
+
+	topics := make([]string, 0, len(matched))
+	for topic := range matched {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	if int64(len(topics)) > queryParameters.Limit {
+		topics = topics[:queryParameters.Limit]
+	}
+	return topics, nil
+}
+
+// InsertMessages stores a copy of the messages in the collection, skipping
+// those whose topic and id are already stored
+func (s *MemoryMessageStore) InsertMessages(ctx context.Context, collection string, messages []*models.MessageV2) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := make(map[string]bool, len(s.collections[collection]))
+	for _, message := range s.collections[collection] {
+		stored[message.Topic+"\n"+message.Id] = true
+	}
+	for _, message := range messages {
+		key := message.Topic + "\n" + message.Id
+		if message.Id != "" && stored[key] {
+			continue
+		}
+		stored[key] = true
+		s.collections[collection] = append(s.collections[collection], memoryMessage{
+			MessageV2: *message,
+			playerID:  message.PlayerId,
+		})
+	}
+	return nil
+}
+
+// InsertRawMessages stores the messages as written by the former writers of
+// the collection, keeping their numeric player_ids numeric
+func (s *MemoryMessageStore) InsertRawMessages(ctx context.Context, collection string, rawMessages []mongoclient.MongoMessage) error {
+	messages, err := mongoclient.ConvertRawMessages(rawMessages)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, message := range messages {
+		s.collections[collection] = append(s.collections[collection], memoryMessage{
+			MessageV2: *message,
+			playerID:  rawMessages[i].PlayerId,
+		})
+	}
+	return nil
+}
+
+// ModerateMessages updates the blocked and should_moderate fields of the
+// messages and returns a copy of them
+func (s *MemoryMessageStore) ModerateMessages(ctx context.Context, moderation Moderation) ([]*models.MessageV2, error) {
+	ids := make(map[string]bool, len(moderation.IDs))
+	for _, id := range moderation.IDs {
+		ids[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := make([]*models.MessageV2, 0)
+	stored := s.collections[moderation.Collection]
+	for i := range stored {
+		if !ids[stored[i].Id] || (moderation.GameID != "" && stored[i].GameId != moderation.GameID) {
+			continue
+		}
+		stored[i].Blocked = moderation.Blocked
+		stored[i].ShouldModerate = false
+		message := stored[i].MessageV2
+		messages = append(messages, &message)
+	}
+	return messages, nil
+}
+
+// GetModerationQueue returns the messages flagged by should_moderate, oldest first
+func (s *MemoryMessageStore) GetModerationQueue(ctx context.Context, queryParameters mongoclient.QueryParameters) ([]*models.MessageV2, error) {
+	return s.find(queryParameters.Collection, queryParameters.Limit, oldestFirst, func(message *memoryMessage) bool {
+		return message.ShouldModerate &&
+			message.Timestamp >= queryParameters.From &&
+			(queryParameters.To == 0 || message.Timestamp <= queryParameters.To) &&
+			(queryParameters.Cursor == nil || isAfter(message, queryParameters)) &&
+			(queryParameters.Topic == "" || message.Topic == queryParameters.Topic) &&
+			(queryParameters.PlayerID == "" || message.sentBy(queryParameters.PlayerID)) &&
+			inGame(message, queryParameters)
+	}), nil
+}
+
+// ErasePlayerMessages deletes the messages of the player, or redacts them
+// into tombstones
+func (s *MemoryMessageStore) ErasePlayerMessages(ctx context.Context, erasure Erasure) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var affected int64
+	stored := s.collections[erasure.Collection]
+	kept := stored[:0]
+	for _, message := range stored {
+		if !message.sentBy(erasure.PlayerID) ||
+			(erasure.GameID != "" && message.GameId != erasure.GameID) ||
+			(erasure.Redact && message.Redacted) {
+			kept = append(kept, message)
+			continue
+		}
+		affected++
+		if erasure.Redact {
+			message.Message = ""
+			message.Payload = bson.M{}
+			message.Metadata = bson.M{}
+			message.Redacted = true
+			kept = append(kept, message)
+		}
+	}
+	s.collections[erasure.Collection] = kept
+	return affected, nil
+}
+
+// StreamPlayerMessages calls emit with the messages of the player, oldest first
+func (s *MemoryMessageStore) StreamPlayerMessages(
+	ctx context.Context,
+	queryParameters mongoclient.QueryParameters,
+	emit func(*models.MessageV2) error,
+) error {
+	messages := s.find(queryParameters.Collection, 0, oldestFirst, func(message *memoryMessage) bool {
+		return message.sentBy(queryParameters.PlayerID) && inGame(message, queryParameters)
+	})
+	for _, message := range messages {
+		if err := emit(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inGame tells whether the message belongs to queryParameters.GameID, when set
+func inGame(message *memoryMessage, queryParameters mongoclient.QueryParameters) bool {
+	return queryParameters.GameID == "" || message.GameId == queryParameters.GameID
+}
+
+// isExcluded tells whether the message was sent by one of
+// queryParameters.ExcludedPlayerIDs
+func isExcluded(message *memoryMessage, queryParameters mongoclient.QueryParameters) bool {
+	for _, playerID := range queryParameters.ExcludedPlayerIDs {
+		if message.sentBy(playerID) {
+			return true
+		}
+	}
+	return false
+}
+
+// sentBy tells whether the message was sent by the player. As in MongoDB,
+// the numeric player_ids match the player IDs holding the same number
+func (message *memoryMessage) sentBy(playerID string) bool {
+	for _, value := range mongoclient.PlayerIDValues(playerID) {
+		if value == message.playerID {
+			return true
+		}
+		number, isNumber := numericValue(value)
+		storedNumber, isStoredNumber := numericValue(message.playerID)
+		if isNumber && isStoredNumber && number == storedNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// numericValue returns the value of the BSON numbers
+func numericValue(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case int32:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case float64:
+		return number, true
+	}
+	return 0, false
+}
+
+// isBefore tells whether the message comes before queryParameters.Cursor
+// or, when there is no cursor, was sent until queryParameters.From
+func isBefore(message *memoryMessage, queryParameters mongoclient.QueryParameters) bool {
+	cursor := queryParameters.Cursor
+	if cursor == nil {
+		return message.Timestamp <= queryParameters.From
+	}
+	return message.Timestamp < cursor.Timestamp ||
+		(message.Timestamp == cursor.Timestamp && message.Id < cursor.Id)
+}
+
+// isAfter tells whether the message comes after queryParameters.Cursor
+// or, when there is no cursor, was sent after queryParameters.Since
+func isAfter(message *memoryMessage, queryParameters mongoclient.QueryParameters) bool {
+	cursor := queryParameters.Cursor
+	if cursor == nil {
+		return message.Timestamp > queryParameters.Since
+	}
+	return message.Timestamp > cursor.Timestamp ||
+		(message.Timestamp == cursor.Timestamp && message.Id > cursor.Id)
+}
+
+// newestFirst orders the messages of a timeline, ties broken by id
+func newestFirst(a, b *models.MessageV2) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp > b.Timestamp
+	}
+	return a.Id > b.Id
+}
+
+// oldestFirst orders the messages of a forward timeline, ties broken by id
+func oldestFirst(a, b *models.MessageV2) bool {
+	return newestFirst(b, a)
+}
+
+// byTopic orders the messages by topic first, like the topic_timestamp index
+func byTopic(less func(a, b *models.MessageV2) bool) func(a, b *models.MessageV2) bool {
+	return func(a, b *models.MessageV2) bool {
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return less(a, b)
+	}
+}
+
+// find returns up to limit messages of the collection matching the filter,
+// sorted by less. A limit of zero means no limit
+func (s *MemoryMessageStore) find(
+	collection string,
+	limit int64,
+	less func(a, b *models.MessageV2) bool,
+	filter func(*memoryMessage) bool,
+) []*models.MessageV2 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]*models.MessageV2, 0)
+	for i := range s.collections[collection] {
+		if filter(&s.collections[collection][i]) {
+			message := s.collections[collection][i].MessageV2
+			results = append(results, &message)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return less(results[i], results[j])
+	})
+
+	if limit > 0 && int64(len(results)) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// MemoryACLStore is an ACLStore that keeps the ACLs in memory
+type MemoryACLStore struct {
+	mu   sync.RWMutex
+	acls []models.ACL
+}
+
+// NewMemoryACLStore returns an empty MemoryACLStore
+func NewMemoryACLStore() *MemoryACLStore {
+	return &MemoryACLStore{}
+}
+
+// FindACLs returns the ACLs of username in the game
+func (s *MemoryACLStore) FindACLs(ctx context.Context, gameID, username string) ([]models.ACL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]models.ACL, 0)
+	for _, acl := range s.acls {
+		if acl.GameId == gameID && acl.Username == username {
+			results = append(results, copyACL(acl))
+		}
+	}
+	return results, nil
+}
+
+// InsertACLs stores a copy of the ACLs
+func (s *MemoryACLStore) InsertACLs(ctx context.Context, acls []models.ACL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, acl := range acls {
+		s.acls = append(s.acls, copyACL(acl))
+	}
+	return nil
+}
+
+// GrantTopics adds the topic filters missing from the ACLs of username in the game
+func (s *MemoryACLStore) GrantTopics(ctx context.Context, gameID, username string, topics []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	granted := make(map[string]bool)
+	first := -1
+	for i, acl := range s.acls {
+		if acl.GameId != gameID || acl.Username != username {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		for _, topic := range acl.Pubsub {
+			granted[topic] = true
+		}
+	}
+	if first < 0 {
+		s.acls = append(s.acls, models.ACL{GameId: gameID, Username: username})
+		first = len(s.acls) - 1
+	}
+
+	for _, topic := range topics {
+		if !granted[topic] {
+			granted[topic] = true
+			s.acls[first].Pubsub = append(s.acls[first].Pubsub, topic)
+		}
+	}
+	return nil
+}
+
+// RevokeTopics removes the topic filters from the ACLs of username in the game
+func (s *MemoryACLStore) RevokeTopics(ctx context.Context, gameID, username string, topics []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		revoked[topic] = true
+	}
+
+	acls := s.acls[:0]
+	for _, acl := range s.acls {
+		if acl.GameId == gameID && acl.Username == username {
+			pubsub := make([]string, 0, len(acl.Pubsub))
+			for _, topic := range acl.Pubsub {
+				if !revoked[topic] {
+					pubsub = append(pubsub, topic)
+				}
+			}
+			if len(pubsub) == 0 {
+				continue
+			}
+			acl.Pubsub = pubsub
+		}
+		acls = append(acls, acl)
+	}
+	s.acls = acls
+	return nil
+}
+
+func copyACL(acl models.ACL) models.ACL {
+	acl.Pubsub = append([]string(nil), acl.Pubsub...)
+	return acl
+}
+
+// MemoryReadMarkerStore is a ReadMarkerStore that keeps the markers in memory
+type MemoryReadMarkerStore struct {
+	mu      sync.RWMutex
+	markers map[readMarkerKey]models.ReadMarker
+}
+
+type readMarkerKey struct {
+	gameID   string
+	playerID string
+	topic    string
+}
+
+// NewMemoryReadMarkerStore returns an empty MemoryReadMarkerStore
+func NewMemoryReadMarkerStore() *MemoryReadMarkerStore {
+	return &MemoryReadMarkerStore{
+		markers: make(map[readMarkerKey]models.ReadMarker),
+	}
+}
+
+// SetReadMarker replaces the marker of the player on the topic in its game
+func (s *MemoryReadMarkerStore) SetReadMarker(ctx context.Context, marker models.ReadMarker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markers[readMarkerKey{gameID: marker.GameId, playerID: marker.PlayerId, topic: marker.Topic}] = marker
+	return nil
+}
+
+// GetReadMarkers returns the markers of the player on the topics of the game
+func (s *MemoryReadMarkerStore) GetReadMarkers(ctx context.Context, gameID, playerID string, topics []string) ([]models.ReadMarker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	markers := make([]models.ReadMarker, 0, len(topics))
+	for _, topic := range topics {
+		if marker, ok := s.markers[readMarkerKey{gameID: gameID, playerID: playerID, topic: topic}]; ok {
+			markers = append(markers, marker)
+		}
+	}
+	return markers, nil
+}
+
+// MemoryMuteStore is a MuteStore that keeps the mute lists in memory
+type MemoryMuteStore struct {
+	mu    sync.RWMutex
+	mutes map[muteListKey]map[string]models.MutedPlayer
+}
+
+type muteListKey struct {
+	gameID   string
+	playerID string
+}
+
+// NewMemoryMuteStore returns an empty MemoryMuteStore
+func NewMemoryMuteStore() *MemoryMuteStore {
+	return &MemoryMuteStore{
+		mutes: make(map[muteListKey]map[string]models.MutedPlayer),
+	}
+}
+
+// MutePlayer adds the muted player to the mute list of the player in its game
+func (s *MemoryMuteStore) MutePlayer(ctx context.Context, mute models.MutedPlayer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := muteListKey{gameID: mute.GameId, playerID: mute.PlayerId}
+	if s.mutes[key] == nil {
+		s.mutes[key] = make(map[string]models.MutedPlayer)
+	}
+	s.mutes[key][mute.MutedPlayerId] = mute
+	return nil
+}
+
+// UnmutePlayer removes the muted player from the mute list of the player in the game
+func (s *MemoryMuteStore) UnmutePlayer(ctx context.Context, gameID, playerID, mutedPlayerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mutes[muteListKey{gameID: gameID, playerID: playerID}], mutedPlayerID)
+	return nil
+}
+
+// GetMutedPlayers returns the mute list of the player in the game, sorted by muted player
+func (s *MemoryMuteStore) GetMutedPlayers(ctx context.Context, gameID, playerID string) ([]models.MutedPlayer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := s.mutes[muteListKey{gameID: gameID, playerID: playerID}]
+	mutes := make([]models.MutedPlayer, 0, len(list))
+	for _, mute := range list {
+		mutes = append(mutes, mute)
+	}
+	sort.Slice(mutes, func(i, j int) bool {
+		return mutes[i].MutedPlayerId < mutes[j].MutedPlayerId
+	})
+	return mutes, nil
+}
+
+// MemoryOperatorStore is an OperatorStore that keeps the operators in memory
+type MemoryOperatorStore struct {
+	mu        sync.RWMutex
+	operators map[string]models.Operator
+}
+
+// NewMemoryOperatorStore returns an empty MemoryOperatorStore
+func NewMemoryOperatorStore() *MemoryOperatorStore {
+	return &MemoryOperatorStore{
+		operators: make(map[string]models.Operator),
+	}
+}
+
+// FindOperator returns the operator whose API key has the given SHA-256
+func (s *MemoryOperatorStore) FindOperator(ctx context.Context, keySha256 string) (*models.Operator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	operator, ok := s.operators[keySha256]
+	if !ok {
+		return nil, nil
+	}
+	operator.Roles = append([]string{}, operator.Roles...)
+	return &operator, nil
+}
+
+// InsertOperators stores the operators, replacing those with the same API key
+func (s *MemoryOperatorStore) InsertOperators(ctx context.Context, operators []models.Operator) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, operator := range operators {
+		operator.Roles = append([]string{}, operator.Roles...)
+		s.operators[operator.KeySha256] = operator
+	}
+	return nil
+}
+
+// MemoryAuditStore is an AuditStore that keeps the audit log in memory
+type MemoryAuditStore struct {
+	mu      sync.RWMutex
+	entries []models.AuditEntry
+}
+
+// NewMemoryAuditStore returns an empty MemoryAuditStore
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{}
+}
+
+// InsertAuditEntry appends the entry to the audit log
+func (s *MemoryAuditStore) InsertAuditEntry(ctx context.Context, entry models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// FindAuditEntries returns the entries matching the filter, newest first
+func (s *MemoryAuditStore) FindAuditEntries(ctx context.Context, filter AuditFilter) ([]models.AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]models.AuditEntry, 0)
+	// the latest entries come first among those of the same second
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		entry := s.entries[i]
+		if (filter.Operator == "" || entry.Operator == filter.Operator) &&
+			(filter.PlayerID == "" || entry.PlayerId == filter.PlayerID) &&
+			(filter.Topic == "" || entry.Topic == filter.Topic) &&
+			entry.Timestamp >= filter.From &&
+			(filter.To == 0 || entry.Timestamp <= filter.To) {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp > entries[j].Timestamp
+	})
+	if filter.Limit > 0 && int64(len(entries)) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// MemoryModerationStore is a ModerationStore that keeps the history and
+// the claims in memory
+type MemoryModerationStore struct {
+	mu     sync.RWMutex
+	events []models.ModerationEvent
+	claims map[claimKey]models.ModerationClaim
+}
+
+// claimKey identifies the claims, the messages of two games possibly
+// sharing an id
+type claimKey struct {
+	gameID    string
+	messageID string
+}
+
+// NewMemoryModerationStore returns an empty MemoryModerationStore
+func NewMemoryModerationStore() *MemoryModerationStore {
+	return &MemoryModerationStore{
+		claims: make(map[claimKey]models.ModerationClaim),
+	}
+}
+
+// InsertModerationEvents appends the events to the history
+func (s *MemoryModerationStore) InsertModerationEvents(ctx context.Context, events []models.ModerationEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// FindModerationEvents returns the events of the message, newest first
+func (s *MemoryModerationStore) FindModerationEvents(ctx context.Context, gameID, messageID string, limit int64) ([]models.ModerationEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]models.ModerationEvent, 0)
+	// the latest events come first among those of the same second
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if s.events[i].MessageId == messageID && (gameID == "" || s.events[i].GameId == gameID) {
+			events = append(events, s.events[i])
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp > events[j].Timestamp
+	})
+	if limit > 0 && int64(len(events)) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// ClaimMessages leases the messages of the game that are not leased to
+// another operator
+func (s *MemoryModerationStore) ClaimMessages(ctx context.Context, gameID, operator, moderator string, ids []string, now, expiresAt int64) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	claimed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		key := claimKey{gameID: gameID, messageID: id}
+		claim, ok := s.claims[key]
+		if ok && claim.Operator != operator && claim.ExpiresAt > now {
+			continue
+		}
+		s.claims[key] = models.ModerationClaim{
+			GameId:    gameID,
+			MessageId: id,
+			Operator:  operator,
+			Moderator: moderator,
+			ExpiresAt: expiresAt,
+		}
+		claimed = append(claimed, id)
+	}
+	return claimed, nil
+}
+
+// ReleaseMessages drops the claims on the messages, of every game when
+// gameID is empty
+func (s *MemoryModerationStore) ReleaseMessages(ctx context.Context, gameID, operator string, ids []string) error {
+	released := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		released[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, claim := range s.claims {
+		if released[key.messageID] &&
+			(gameID == "" || key.gameID == gameID) &&
+			(operator == "" || claim.Operator == operator) {
+			delete(s.claims, key)
+		}
+	}
+	return nil
+}
+
+// FindClaims returns the claims on the messages of the game expiring after now
+func (s *MemoryModerationStore) FindClaims(ctx context.Context, gameID string, ids []string, now int64) ([]models.ModerationClaim, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	claims := make([]models.ModerationClaim, 0)
+	for _, id := range ids {
+		if claim, ok := s.claims[claimKey{gameID: gameID, messageID: id}]; ok && claim.ExpiresAt > now {
+			claims = append(claims, claim)
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,513 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+func TestMemoryStore(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("MemoryMessageStore", func() {
+		ctx := context.Background()
+		collection := "messages"
+		var store *storage.MemoryMessageStore
+
+		g.BeforeEach(func() {
+			store = storage.NewMemoryMessageStore()
+			err := store.InsertMessages(ctx, collection, []*models.MessageV2{
+				{Id: "1", Topic: "chat/a", Timestamp: 10, PlayerId: "p1"},
+				{Id: "2", Topic: "chat/a", Timestamp: 30, PlayerId: "p2"},
+				{Id: "3", Topic: "chat/a", Timestamp: 20, PlayerId: "p1", Blocked: true},
+				{Id: "4", Topic: "chat/b", Timestamp: 15, PlayerId: "p1"},
+				{Id: "5", Topic: "chat/a", Timestamp: 40, PlayerId: "p1"},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		g.It("should return the unblocked messages of the topic until from, newest first", func() {
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/a",
+				From:       30,
+				Limit:      10,
+			})
+			g.Assert(len(messages)).Equal(2)
+			g.Assert(messages[0].Id).Equal("2")
+			g.Assert(messages[1].Id).Equal("1")
+		})
+
+		g.It("should return only blocked messages when asked to", func() {
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/a",
+				From:       100,
+				Limit:      10,
+				IsBlocked:  true,
+			})
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Id).Equal("3")
+		})
+
+		g.It("should apply the limit", func() {
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/a",
+				From:       100,
+				Limit:      1,
+			})
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Id).Equal("5")
+		})
+
+		g.It("should leave the messages of the excluded players out", func() {
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection:        collection,
+				Topic:             "chat/a",
+				From:              100,
+				Limit:             2,
+				ExcludedPlayerIDs: []string{"p1"},
+			})
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Id).Equal("2")
+
+			count, err := store.CountMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection:        collection,
+				Topic:             "chat/a",
+				ExcludedPlayerIDs: []string{"p2"},
+			})
+			Expect(err).To(BeNil())
+			g.Assert(count).Equal(int64(2))
+		})
+
+		g.It("should return the messages after since oldest first when reading forward", func() {
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/a",
+				Since:      10,
+				Limit:      10,
+				Forward:    true,
+			})
+			g.Assert(len(messages)).Equal(2)
+			g.Assert(messages[0].Id).Equal("2")
+			g.Assert(messages[1].Id).Equal("5")
+		})
+
+		g.It("should return the player support messages in range sorted by topic", func() {
+			messages := store.GetMessagesPlayerSupportV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				PlayerID:   "p1",
+				From:       10,
+				To:         39,
+				Limit:      10,
+			})
+			g.Assert(len(messages)).Equal(2)
+			g.Assert(messages[0].Id).Equal("1")
+			g.Assert(messages[1].Id).Equal("4")
+		})
+
+		g.It("should return the sorted topics matching a topic filter up to the limit", func() {
+			topics, err := store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/+",
+				From:       100,
+				Limit:      10,
+			})
+			Expect(err).To(BeNil())
+			g.Assert(topics).Equal([]string{"chat/a", "chat/b"})
+
+			topics, err = store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "#",
+				From:       100,
+				Limit:      1,
+			})
+			Expect(err).To(BeNil())
+			g.Assert(topics).Equal([]string{"chat/a"})
+
+			topics, err = store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+				Collection:  collection,
+				Topic:       "#",
+				From:        100,
+				Limit:       1,
+				TopicsAfter: "chat/a",
+			})
+			Expect(err).To(BeNil())
+			g.Assert(topics).Equal([]string{"chat/b"})
+		})
+
+		g.It("should only return the topics with messages in the range", func() {
+			topics, err := store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/+",
+				From:       14,
+				Limit:      10,
+			})
+			Expect(err).To(BeNil())
+			g.Assert(topics).Equal([]string{"chat/a"})
+
+			topics, err = store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/+",
+				Since:      15,
+				Forward:    true,
+				Limit:      10,
+			})
+			Expect(err).To(BeNil())
+			g.Assert(topics).Equal([]string{"chat/a"})
+
+			topics, err = store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/+",
+				From:       15,
+				Since:      10,
+				Limit:      10,
+			})
+			Expect(err).To(BeNil())
+			g.Assert(topics).Equal([]string{"chat/b"})
+		})
+
+		g.It("should not share the messages between collections", func() {
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: "other",
+				Topic:      "chat/a",
+				From:       100,
+				Limit:      10,
+			})
+			g.Assert(len(messages)).Equal(0)
+		})
+
+		g.It("should scope the queries to the game", func() {
+			err := store.InsertMessages(ctx, collection, []*models.MessageV2{
+				{Id: "6", Topic: "chat/a", Timestamp: 50, PlayerId: "p1", GameId: "game1"},
+				{Id: "7", Topic: "chat/c", Timestamp: 60, PlayerId: "p1", GameId: "game2"},
+			})
+			Expect(err).To(BeNil())
+
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/a",
+				From:       100,
+				Limit:      10,
+				GameID:     "game1",
+			})
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Id).Equal("6")
+
+			count, err := store.CountMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/a",
+				GameID:     "game2",
+			})
+			Expect(err).To(BeNil())
+			g.Assert(count).Equal(int64(0))
+
+			topics, err := store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/+",
+				From:       100,
+				Limit:      10,
+				GameID:     "game2",
+			})
+			Expect(err).To(BeNil())
+			g.Assert(topics).Equal([]string{"chat/c"})
+		})
+
+		g.It("should moderate the messages of the game and mark them as reviewed", func() {
+			err := store.InsertMessages(ctx, collection, []*models.MessageV2{
+				{Id: "6", Topic: "chat/a", Timestamp: 50, GameId: "game1", ShouldModerate: true},
+				{Id: "7", Topic: "chat/a", Timestamp: 60, GameId: "game2", ShouldModerate: true},
+			})
+			Expect(err).To(BeNil())
+
+			messages, err := store.ModerateMessages(ctx, storage.Moderation{
+				Collection: collection,
+				GameID:     "game1",
+				IDs:        []string{"6", "7"},
+				Blocked:    true,
+			})
+			Expect(err).To(BeNil())
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Id).Equal("6")
+			g.Assert(messages[0].Blocked).IsTrue()
+			g.Assert(messages[0].ShouldModerate).IsFalse()
+
+			blocked := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/a",
+				From:       100,
+				Limit:      10,
+				IsBlocked:  true,
+			})
+			g.Assert(len(blocked)).Equal(2)
+			g.Assert(blocked[0].Id).Equal("6")
+		})
+
+		g.It("should return the messages to moderate after the cursor, oldest first", func() {
+			err := store.InsertMessages(ctx, collection, []*models.MessageV2{
+				{Id: "6", Topic: "chat/a", Timestamp: 60, PlayerId: "p1", ShouldModerate: true},
+				{Id: "7", Topic: "chat/b", Timestamp: 50, PlayerId: "p2", ShouldModerate: true},
+				{Id: "8", Topic: "chat/a", Timestamp: 50, PlayerId: "p1", ShouldModerate: true},
+			})
+			Expect(err).To(BeNil())
+
+			messages, err := store.GetModerationQueue(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Limit:      2,
+			})
+			Expect(err).To(BeNil())
+			g.Assert(len(messages)).Equal(2)
+			g.Assert(messages[0].Id).Equal("7")
+			g.Assert(messages[1].Id).Equal("8")
+
+			cursor := models.CursorOf(messages[1])
+			messages, err = store.GetModerationQueue(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Limit:      2,
+				Cursor:     &cursor,
+			})
+			Expect(err).To(BeNil())
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Id).Equal("6")
+
+			messages, err = store.GetModerationQueue(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/a",
+				PlayerID:   "p1",
+				To:         55,
+				Limit:      10,
+			})
+			Expect(err).To(BeNil())
+			g.Assert(len(messages)).Equal(1)
+			g.Assert(messages[0].Id).Equal("8")
+		})
+	})
+
+	g.Describe("MemoryMessageStore erasure", func() {
+		ctx := context.Background()
+		collection := "messages"
+		var store *storage.MemoryMessageStore
+
+		g.BeforeEach(func() {
+			store = storage.NewMemoryMessageStore()
+			err := store.InsertMessages(ctx, collection, []*models.MessageV2{
+				{Id: "1", Topic: "chat/a", Timestamp: 10, PlayerId: "p1", GameId: "game1", Message: "hi"},
+				{Id: "2", Topic: "chat/b", Timestamp: 20, PlayerId: "p1", GameId: "game2", Message: "hi"},
+				{Id: "3", Topic: "chat/a", Timestamp: 30, PlayerId: "p2", GameId: "game1", Message: "hi"},
+			})
+			Expect(err).To(BeNil())
+		})
+
+		g.It("should redact the messages of the player once", func() {
+			erasure := storage.Erasure{Collection: collection, PlayerID: "p1", Redact: true}
+			affected, err := store.ErasePlayerMessages(ctx, erasure)
+			Expect(err).To(BeNil())
+			g.Assert(affected).Equal(int64(2))
+			affected, err = store.ErasePlayerMessages(ctx, erasure)
+			Expect(err).To(BeNil())
+			g.Assert(affected).Equal(int64(0))
+
+			messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/a",
+				From:       100,
+				Limit:      10,
+			})
+			g.Assert(len(messages)).Equal(2)
+			g.Assert(messages[0].Message).Equal("hi")
+			g.Assert(messages[1].Message).Equal("")
+			g.Assert(messages[1].Redacted).IsTrue()
+		})
+
+		g.It("should delete the messages of the player in the game", func() {
+			affected, err := store.ErasePlayerMessages(ctx, storage.Erasure{
+				Collection: collection,
+				GameID:     "game2",
+				PlayerID:   "p1",
+			})
+			Expect(err).To(BeNil())
+			g.Assert(affected).Equal(int64(1))
+
+			count, err := store.CountMessagesV2(ctx, mongoclient.QueryParameters{
+				Collection: collection,
+				Topic:      "chat/b",
+			})
+			Expect(err).To(BeNil())
+			g.Assert(count).Equal(int64(0))
+		})
+	})
+
+	g.Describe("MemoryMessageStore export", func() {
+		ctx := context.Background()
+
+		g.It("should stream the messages of the player of every topic, oldest first", func() {
+			store := storage.NewMemoryMessageStore()
+			err := store.InsertMessages(ctx, "messages", []*models.MessageV2{
+				{Id: "1", Topic: "chat/b", Timestamp: 20, PlayerId: "p1"},
+				{Id: "2", Topic: "chat/a", Timestamp: 10, PlayerId: "p1"},
+				{Id: "3", Topic: "chat/a", Timestamp: 30, PlayerId: "p2"},
+			})
+			Expect(err).To(BeNil())
+
+			ids := make([]string, 0)
+			err = store.StreamPlayerMessages(ctx, mongoclient.QueryParameters{
+				Collection: "messages",
+				PlayerID:   "p1",
+			}, func(message *models.MessageV2) error {
+				ids = append(ids, message.Id)
+				return nil
+			})
+			Expect(err).To(BeNil())
+			g.Assert(ids).Equal([]string{"2", "1"})
+		})
+	})
+
+	g.Describe("MemoryMessageStore with the messages of the former writers", func() {
+		describeMessageStore(g, storage.NewMemoryMessageStore(), func() string {
+			return "messages_" + uuid.NewV4().String()
+		})
+	})
+
+	g.Describe("MemoryModerationStore", func() {
+		describeModerationStore(g, func() storage.ModerationStore {
+			return storage.NewMemoryModerationStore()
+		})
+	})
+
+	g.Describe("MemoryACLStore", func() {
+		ctx := context.Background()
+
+		g.It("should return the ACLs of the user", func() {
+			store := storage.NewMemoryACLStore()
+			err := store.InsertACLs(ctx, []models.ACL{
+				{Username: "user", Pubsub: []string{"chat/a"}},
+				{Username: "user", Pubsub: []string{"chat/+"}},
+				{Username: "other", Pubsub: []string{"chat/b"}},
+				{GameId: "game1", Username: "user", Pubsub: []string{"clan/#"}},
+			})
+			Expect(err).To(BeNil())
+
+			acls, err := store.FindACLs(ctx, "", "user")
+			Expect(err).To(BeNil())
+			g.Assert(len(acls)).Equal(2)
+			g.Assert(acls[0].Pubsub[0]).Equal("chat/a")
+			g.Assert(acls[1].Pubsub[0]).Equal("chat/+")
+
+			acls, err = store.FindACLs(ctx, "game1", "user")
+			Expect(err).To(BeNil())
+			g.Assert(len(acls)).Equal(1)
+			g.Assert(acls[0].Pubsub[0]).Equal("clan/#")
+		})
+
+		g.It("should grant the missing topics and revoke them from every ACL", func() {
+			store := storage.NewMemoryACLStore()
+			err := store.InsertACLs(ctx, []models.ACL{
+				{Username: "user", Pubsub: []string{"chat/a"}},
+				{Username: "user", Pubsub: []string{"chat/+"}},
+			})
+			Expect(err).To(BeNil())
+
+			err = store.GrantTopics(ctx, "", "user", []string{"chat/+", "clan/#"})
+			Expect(err).To(BeNil())
+			err = store.GrantTopics(ctx, "game1", "new", []string{"chat/b"})
+			Expect(err).To(BeNil())
+
+			acls, err := store.FindACLs(ctx, "", "user")
+			Expect(err).To(BeNil())
+			g.Assert(acls[0].Pubsub).Equal([]string{"chat/a", "clan/#"})
+			g.Assert(acls[1].Pubsub).Equal([]string{"chat/+"})
+			acls, err = store.FindACLs(ctx, "", "new")
+			Expect(err).To(BeNil())
+			g.Assert(len(acls)).Equal(0)
+			acls, err = store.FindACLs(ctx, "game1", "new")
+			Expect(err).To(BeNil())
+			g.Assert(acls[0].Pubsub).Equal([]string{"chat/b"})
+
+			err = store.RevokeTopics(ctx, "game1", "user", []string{"chat/+", "chat/a"})
+			Expect(err).To(BeNil())
+			acls, err = store.FindACLs(ctx, "", "user")
+			Expect(err).To(BeNil())
+			g.Assert(len(acls)).Equal(2)
+
+			err = store.RevokeTopics(ctx, "", "user", []string{"chat/+", "chat/a"})
+			Expect(err).To(BeNil())
+			acls, err = store.FindACLs(ctx, "", "user")
+			Expect(err).To(BeNil())
+			g.Assert(len(acls)).Equal(1)
+			g.Assert(acls[0].Pubsub).Equal([]string{"clan/#"})
+		})
+	})
+
+	g.Describe("MemoryMuteStore", func() {
+		ctx := context.Background()
+
+		g.It("should keep the mute list of each player in each game", func() {
+			store := storage.NewMemoryMuteStore()
+			for _, mute := range []models.MutedPlayer{
+				{PlayerId: "p1", MutedPlayerId: "p3"},
+				{PlayerId: "p1", MutedPlayerId: "p2"},
+				{PlayerId: "p2", MutedPlayerId: "p1"},
+				{GameId: "game1", PlayerId: "p1", MutedPlayerId: "p4"},
+			} {
+				Expect(store.MutePlayer(ctx, mute)).To(BeNil())
+			}
+			Expect(store.UnmutePlayer(ctx, "", "p2", "p1")).To(BeNil())
+
+			mutes, err := store.GetMutedPlayers(ctx, "", "p1")
+			Expect(err).To(BeNil())
+			g.Assert(mutes).Equal([]models.MutedPlayer{
+				{PlayerId: "p1", MutedPlayerId: "p2"},
+				{PlayerId: "p1", MutedPlayerId: "p3"},
+			})
+			mutes, err = store.GetMutedPlayers(ctx, "", "p2")
+			Expect(err).To(BeNil())
+			g.Assert(len(mutes)).Equal(0)
+			mutes, err = store.GetMutedPlayers(ctx, "game1", "p1")
+			Expect(err).To(BeNil())
+			g.Assert(mutes).Equal([]models.MutedPlayer{{GameId: "game1", PlayerId: "p1", MutedPlayerId: "p4"}})
+		})
+	})
+
+	g.Describe("MemoryReadMarkerStore", func() {
+		ctx := context.Background()
+
+		g.It("should keep the last marker of the player on each topic of each game", func() {
+			store := storage.NewMemoryReadMarkerStore()
+			for _, marker := range []models.ReadMarker{
+				{PlayerId: "p1", Topic: "chat/a", Timestamp: 10},
+				{PlayerId: "p1", Topic: "chat/a", Timestamp: 20},
+				{PlayerId: "p2", Topic: "chat/a", Timestamp: 30},
+				{GameId: "game1", PlayerId: "p1", Topic: "chat/a", Timestamp: 40},
+			} {
+				err := store.SetReadMarker(ctx, marker)
+				Expect(err).To(BeNil())
+			}
+
+			markers, err := store.GetReadMarkers(ctx, "", "p1", []string{"chat/a", "chat/b"})
+			Expect(err).To(BeNil())
+			g.Assert(len(markers)).Equal(1)
+			g.Assert(markers[0].Timestamp).Equal(int64(20))
+
+			markers, err = store.GetReadMarkers(ctx, "game1", "p1", []string{"chat/a"})
+			Expect(err).To(BeNil())
+			g.Assert(len(markers)).Equal(1)
+			g.Assert(markers[0].Timestamp).Equal(int64(40))
+		})
+	})
+}
@@ -0,0 +1,927 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const aclCollection = "mqtt_acl"
+
+// duplicateKeyCode is the code of the MongoDB duplicate key errors
+const duplicateKeyCode = 11000
+
+// MongoMessageStore is the MessageStore backed by MongoDB
+type MongoMessageStore struct{}
+
+// NewMongoMessageStore returns a new MongoMessageStore
+func NewMongoMessageStore() *MongoMessageStore {
+	return &MongoMessageStore{}
+}
+
+// GetMessagesV2 returns the messages of a topic stored in MongoDB
+func (s *MongoMessageStore) GetMessagesV2(ctx context.Context, queryParameters mongoclient.QueryParameters) []*models.MessageV2 {
+	return mongoclient.GetMessagesV2WithParameter(ctx, queryParameters)
+}
+
+// GetMessagesPlayerSupportV2 returns the messages stored in MongoDB for the player support queries
+func (s *MongoMessageStore) GetMessagesPlayerSupportV2(ctx context.Context, queryParameters mongoclient.QueryParameters) []*models.MessageV2 {
+	return mongoclient.GetMessagesPlayerSupportV2WithParameter(ctx, queryParameters)
+}
+
+// CountMessagesV2 counts the messages of a topic stored in MongoDB
+func (s *MongoMessageStore) CountMessagesV2(ctx context.Context, queryParameters mongoclient.QueryParameters) (int64, error) {
+	return mongoclient.CountMessagesV2(ctx, queryParameters)
+}
+
+// GetTopicsV2 returns the topics stored in MongoDB matching a topic filter
+func (s *MongoMessageStore) GetTopicsV2(ctx context.Context, queryParameters mongoclient.QueryParameters) ([]string, error) {
+	return mongoclient.GetTopicsV2(ctx, queryParameters)
+}
+
+// InsertMessages upserts the messages in the MongoDB collection by topic and
+// id, the messages already stored being left untouched
+func (s *MongoMessageStore) InsertMessages(ctx context.Context, collection string, messages []*models.MessageV2) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	writes := make([]mongo.WriteModel, len(messages))
+	for i, message := range messages {
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"topic": message.Topic, "id": message.Id}).
+			SetUpdate(bson.M{"$setOnInsert": message}).
+			SetUpsert(true)
+	}
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, collection)
+	if err != nil {
+		return err
+	}
+	_, err = mongoCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if onlyDuplicateKeyErrors(err) {
+		// concurrent upserts of the same messages, caught by the unique topic_id index
+		return nil
+	}
+	return err
+}
+
+// InsertRawMessages stores the messages as written by the former writers of
+// the collection, keeping their numeric player_ids numeric
+func (s *MongoMessageStore) InsertRawMessages(ctx context.Context, collection string, rawMessages []mongoclient.MongoMessage) error {
+	if len(rawMessages) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(rawMessages))
+	for i, message := range rawMessages {
+		documents[i] = message
+	}
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, collection)
+	if err != nil {
+		return err
+	}
+	_, err = mongoCollection.InsertMany(ctx, documents)
+	return err
+}
+
+// onlyDuplicateKeyErrors tells whether every write of the bulk write failed
+// on a duplicate key
+func onlyDuplicateKeyErrors(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != duplicateKeyCode {
+			return false
+		}
+	}
+	return true
+}
+
+// ModerateMessages updates the blocked and should_moderate fields of the
+// messages in the MongoDB collection and returns them
+func (s *MongoMessageStore) ModerateMessages(ctx context.Context, moderation Moderation) ([]*models.MessageV2, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"moderate_messages",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       moderation.Collection,
+		},
+	)
+	defer span.Finish()
+
+	messages := make([]*models.MessageV2, 0)
+	mongoCollection, err := mongoclient.GetCollection(ctx, moderation.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return messages, err
+	}
+
+	query := bson.M{"id": bson.M{"$in": moderation.IDs}}
+	if moderation.GameID != "" {
+		query["game_id"] = moderation.GameID
+	}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, nil, 0))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	update := bson.M{"$set": bson.M{"blocked": moderation.Blocked, "should_moderate": false}}
+	if _, err = mongoCollection.UpdateMany(ctx, query, update); err != nil {
+		ext.LogError(span, err, log.Message("Error moderating messages in MongoDB"))
+		return messages, err
+	}
+
+	cursor, err := mongoCollection.Find(ctx, query)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding messages in MongoDB"))
+		return messages, err
+	}
+	rawMessages := make([]mongoclient.MongoMessage, 0)
+	if err = cursor.All(ctx, &rawMessages); err != nil {
+		ext.LogError(span, err, log.Message("Error decoding messages of a cursor from MongoDB"))
+		return messages, err
+	}
+	return mongoclient.ConvertRawMessages(rawMessages)
+}
+
+// GetModerationQueue returns the messages flagged by should_moderate stored in MongoDB
+func (s *MongoMessageStore) GetModerationQueue(ctx context.Context, queryParameters mongoclient.QueryParameters) ([]*models.MessageV2, error) {
+	return mongoclient.GetModerationQueue(ctx, queryParameters)
+}
+
+// ErasePlayerMessages deletes or redacts the messages of the player in the
+// MongoDB collection, whether their player_id was stored as a string or a number
+func (s *MongoMessageStore) ErasePlayerMessages(ctx context.Context, erasure Erasure) (int64, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"erase_player_messages",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       erasure.Collection,
+		},
+	)
+	defer span.Finish()
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, erasure.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return 0, err
+	}
+
+	query := bson.M{"player_id": mongoclient.PlayerIDFilter(erasure.PlayerID)}
+	if erasure.GameID != "" {
+		query["game_id"] = erasure.GameID
+	}
+	if erasure.Redact {
+		// the tombstones were already redacted
+		query["redacted"] = bson.M{"$ne": true}
+	}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, nil, 0))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	if !erasure.Redact {
+		result, err := mongoCollection.DeleteMany(ctx, query)
+		if err != nil {
+			ext.LogError(span, err, log.Message("Error deleting messages in MongoDB"))
+			return 0, err
+		}
+		return result.DeletedCount, nil
+	}
+
+	update := bson.M{"$set": bson.M{
+		"message":          "",
+		"original_payload": bson.M{},
+		"metadata":         bson.M{},
+		"redacted":         true,
+	}}
+	result, err := mongoCollection.UpdateMany(ctx, query, update)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error redacting messages in MongoDB"))
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// StreamPlayerMessages iterates over the messages of the player in the
+// MongoDB collection, whether their player_id was stored as a string or a number
+func (s *MongoMessageStore) StreamPlayerMessages(
+	ctx context.Context,
+	queryParameters mongoclient.QueryParameters,
+	emit func(*models.MessageV2) error,
+) error {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"stream_player_messages",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       queryParameters.Collection,
+		},
+	)
+	defer span.Finish()
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, queryParameters.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return err
+	}
+
+	query := bson.M{"player_id": mongoclient.PlayerIDFilter(queryParameters.PlayerID)}
+	if queryParameters.GameID != "" {
+		query["game_id"] = queryParameters.GameID
+	}
+	sort := bson.D{
+		{Key: "timestamp", Value: 1},
+		{Key: "id", Value: 1},
+	}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, sort, 0))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	cursor, err := mongoCollection.Find(ctx, query, options.Find().SetSort(sort))
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding messages in MongoDB"))
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var rawMessage mongoclient.MongoMessage
+		if err := cursor.Decode(&rawMessage); err != nil {
+			ext.LogError(span, err, log.Message("Error decoding messages of a cursor from MongoDB"))
+			return err
+		}
+		messages, err := mongoclient.ConvertRawMessages([]mongoclient.MongoMessage{rawMessage})
+		if err != nil {
+			return err
+		}
+		if err := emit(messages[0]); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// MongoACLStore is the ACLStore backed by the mqtt_acl MongoDB collection
+type MongoACLStore struct{}
+
+// NewMongoACLStore returns a new MongoACLStore
+func NewMongoACLStore() *MongoACLStore {
+	return &MongoACLStore{}
+}
+
+// FindACLs returns the ACLs of username in the game
+func (s *MongoACLStore) FindACLs(ctx context.Context, gameID, username string) ([]models.ACL, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"find_authorized_topics",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       aclCollection,
+		},
+	)
+	defer span.Finish()
+
+	searchResults := make([]models.ACL, 0)
+	mongoCollection, err := mongoclient.GetCollection(ctx, aclCollection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return searchResults, err
+	}
+
+	opts := options.Find()
+	defaultACLSort := bson.D{
+		{Key: "username", Value: 1},
+		{Key: "pubsub", Value: 1},
+	}
+	// add sort to match index
+	opts.SetSort(defaultACLSort)
+	query := bson.M{"username": username, "game_id": gameKey(gameID)}
+
+	statement := mongoclient.ExtractStatementForTrace(query, defaultACLSort, -1)
+	span.SetTag(string(ext.DBStatement), statement)
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	cursor, err := mongoCollection.Find(ctx, query, opts)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding messages in MongoDB"))
+		return searchResults, err
+	}
+
+	if err = cursor.All(ctx, &searchResults); err != nil {
+		ext.LogError(span, err, log.Message("Error decoding messages of a cursor from MongoDB"))
+	}
+	return searchResults, err
+}
+
+// InsertACLs inserts the ACLs in the mqtt_acl collection
+func (s *MongoACLStore) InsertACLs(ctx context.Context, acls []models.ACL) error {
+	if len(acls) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(acls))
+	for i, acl := range acls {
+		documents[i] = acl
+	}
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, aclCollection)
+	if err != nil {
+		return err
+	}
+	_, err = mongoCollection.InsertMany(ctx, documents)
+	return err
+}
+
+// GrantTopics adds the topic filters missing from the ACLs of username in
+// the game to one of them, upserting it
+func (s *MongoACLStore) GrantTopics(ctx context.Context, gameID, username string, topics []string) error {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"grant_topics",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       aclCollection,
+		},
+	)
+	defer span.Finish()
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, aclCollection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return err
+	}
+
+	acls, err := s.FindACLs(ctx, gameID, username)
+	if err != nil {
+		return err
+	}
+	granted := make(map[string]bool)
+	for _, acl := range acls {
+		for _, topic := range acl.Pubsub {
+			granted[topic] = true
+		}
+	}
+	missing := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if !granted[topic] {
+			missing = append(missing, topic)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	query := bson.M{"username": username, "game_id": gameKey(gameID)}
+	update := bson.M{"$addToSet": bson.M{"pubsub": bson.M{"$each": missing}}}
+	_, err = mongoCollection.UpdateOne(ctx, query, update, options.Update().SetUpsert(true))
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error granting topics in MongoDB"))
+	}
+	return err
+}
+
+// RevokeTopics pulls the topic filters from the ACLs of username in the
+// game and deletes the ACLs left empty
+func (s *MongoACLStore) RevokeTopics(ctx context.Context, gameID, username string, topics []string) error {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"revoke_topics",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       aclCollection,
+		},
+	)
+	defer span.Finish()
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, aclCollection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return err
+	}
+
+	query := bson.M{"username": username, "game_id": gameKey(gameID)}
+	update := bson.M{"$pull": bson.M{"pubsub": bson.M{"$in": topics}}}
+	if _, err = mongoCollection.UpdateMany(ctx, query, update); err != nil {
+		ext.LogError(span, err, log.Message("Error revoking topics in MongoDB"))
+		return err
+	}
+
+	emptyQuery := bson.M{"username": username, "game_id": gameKey(gameID), "pubsub": bson.M{"$size": 0}}
+	if _, err = mongoCollection.DeleteMany(ctx, emptyQuery); err != nil {
+		ext.LogError(span, err, log.Message("Error deleting empty ACLs in MongoDB"))
+	}
+	return err
+}
+
+// gameKey returns the game_id of the documents of the game, those without a
+// game_id, stored before multi-tenancy, matching the empty gameID
+func gameKey(gameID string) interface{} {
+	if gameID == "" {
+		return nil
+	}
+	return gameID
+}
+
+// MongoReadMarkerStore is the ReadMarkerStore backed by a MongoDB collection
+type MongoReadMarkerStore struct {
+	Collection string
+}
+
+// NewMongoReadMarkerStore returns a new MongoReadMarkerStore using the collection
+func NewMongoReadMarkerStore(collection string) *MongoReadMarkerStore {
+	return &MongoReadMarkerStore{Collection: collection}
+}
+
+// SetReadMarker upserts the marker of the player on the topic in its game
+func (s *MongoReadMarkerStore) SetReadMarker(ctx context.Context, marker models.ReadMarker) error {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"set_read_marker",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.Collection,
+		},
+	)
+	defer span.Finish()
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return err
+	}
+
+	query := bson.M{"game_id": gameKey(marker.GameId), "player_id": marker.PlayerId, "topic": marker.Topic}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, nil, 0))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err = mongoCollection.ReplaceOne(ctx, query, marker, opts); err != nil {
+		ext.LogError(span, err, log.Message("Error upserting read marker in MongoDB"))
+	}
+	return err
+}
+
+// GetReadMarkers returns the markers of the player on the topics of the game
+func (s *MongoReadMarkerStore) GetReadMarkers(ctx context.Context, gameID, playerID string, topics []string) ([]models.ReadMarker, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"get_read_markers",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.Collection,
+		},
+	)
+	defer span.Finish()
+
+	markers := make([]models.ReadMarker, 0)
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return markers, err
+	}
+
+	query := bson.M{"game_id": gameKey(gameID), "player_id": playerID, "topic": bson.M{"$in": topics}}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, nil, 0))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	cursor, err := mongoCollection.Find(ctx, query)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding read markers in MongoDB"))
+		return markers, err
+	}
+
+	if err = cursor.All(ctx, &markers); err != nil {
+		ext.LogError(span, err, log.Message("Error decoding read markers of a cursor from MongoDB"))
+	}
+	return markers, err
+}
+
+// MongoMuteStore is the MuteStore backed by a MongoDB collection
+type MongoMuteStore struct {
+	Collection string
+}
+
+// NewMongoMuteStore returns a new MongoMuteStore using the collection
+func NewMongoMuteStore(collection string) *MongoMuteStore {
+	return &MongoMuteStore{Collection: collection}
+}
+
+// MutePlayer upserts the muted player in the mute list of the player in its game
+func (s *MongoMuteStore) MutePlayer(ctx context.Context, mute models.MutedPlayer) error {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"mute_player",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.Collection,
+		},
+	)
+	defer span.Finish()
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return err
+	}
+
+	query := bson.M{"game_id": gameKey(mute.GameId), "player_id": mute.PlayerId, "muted_player_id": mute.MutedPlayerId}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, nil, 0))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err = mongoCollection.ReplaceOne(ctx, query, mute, opts); err != nil {
+		ext.LogError(span, err, log.Message("Error upserting muted player in MongoDB"))
+	}
+	return err
+}
+
+// UnmutePlayer deletes the muted player from the mute list of the player in the game
+func (s *MongoMuteStore) UnmutePlayer(ctx context.Context, gameID, playerID, mutedPlayerID string) error {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"unmute_player",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.Collection,
+		},
+	)
+	defer span.Finish()
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return err
+	}
+
+	query := bson.M{"game_id": gameKey(gameID), "player_id": playerID, "muted_player_id": mutedPlayerID}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, nil, 0))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	if _, err = mongoCollection.DeleteOne(ctx, query); err != nil {
+		ext.LogError(span, err, log.Message("Error deleting muted player in MongoDB"))
+	}
+	return err
+}
+
+// GetMutedPlayers returns the mute list of the player in the game
+func (s *MongoMuteStore) GetMutedPlayers(ctx context.Context, gameID, playerID string) ([]models.MutedPlayer, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"get_muted_players",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.Collection,
+		},
+	)
+	defer span.Finish()
+
+	mutes := make([]models.MutedPlayer, 0)
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return mutes, err
+	}
+
+	query := bson.M{"game_id": gameKey(gameID), "player_id": playerID}
+	sort := bson.D{{Key: "muted_player_id", Value: 1}}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, sort, 0))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	cursor, err := mongoCollection.Find(ctx, query, options.Find().SetSort(sort))
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding muted players in MongoDB"))
+		return mutes, err
+	}
+
+	if err = cursor.All(ctx, &mutes); err != nil {
+		ext.LogError(span, err, log.Message("Error decoding muted players of a cursor from MongoDB"))
+	}
+	return mutes, err
+}
+
+// MongoOperatorStore is the OperatorStore backed by a MongoDB collection
+type MongoOperatorStore struct {
+	Collection string
+}
+
+// NewMongoOperatorStore returns a new MongoOperatorStore using the collection
+func NewMongoOperatorStore(collection string) *MongoOperatorStore {
+	return &MongoOperatorStore{Collection: collection}
+}
+
+// FindOperator returns the operator whose API key has the given SHA-256
+func (s *MongoOperatorStore) FindOperator(ctx context.Context, keySha256 string) (*models.Operator, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"find_operator",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.Collection,
+		},
+	)
+	defer span.Finish()
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return nil, err
+	}
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	var operator models.Operator
+	err = mongoCollection.FindOne(ctx, bson.M{"key_sha256": keySha256}).Decode(&operator)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding operator in MongoDB"))
+		return nil, err
+	}
+	return &operator, nil
+}
+
+// InsertOperators inserts the operators in the MongoDB collection
+func (s *MongoOperatorStore) InsertOperators(ctx context.Context, operators []models.Operator) error {
+	if len(operators) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(operators))
+	for i, operator := range operators {
+		documents[i] = operator
+	}
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		return err
+	}
+	_, err = mongoCollection.InsertMany(ctx, documents)
+	return err
+}
+
+// MongoAuditStore is the AuditStore backed by a MongoDB collection
+type MongoAuditStore struct {
+	Collection string
+}
+
+// NewMongoAuditStore returns a new MongoAuditStore using the collection
+func NewMongoAuditStore(collection string) *MongoAuditStore {
+	return &MongoAuditStore{Collection: collection}
+}
+
+// InsertAuditEntry inserts the entry in the MongoDB collection
+func (s *MongoAuditStore) InsertAuditEntry(ctx context.Context, entry models.AuditEntry) error {
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		return err
+	}
+	_, err = mongoCollection.InsertOne(ctx, entry)
+	return err
+}
+
+// FindAuditEntries returns the entries of the MongoDB collection matching the filter
+func (s *MongoAuditStore) FindAuditEntries(ctx context.Context, filter AuditFilter) ([]models.AuditEntry, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"find_audit_entries",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.Collection,
+		},
+	)
+	defer span.Finish()
+
+	entries := make([]models.AuditEntry, 0)
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return entries, err
+	}
+
+	query := bson.M{}
+	if filter.Operator != "" {
+		query["operator"] = filter.Operator
+	}
+	if filter.PlayerID != "" {
+		query["player_id"] = filter.PlayerID
+	}
+	if filter.Topic != "" {
+		query["topic"] = filter.Topic
+	}
+	timestamp := bson.M{"$gte": filter.From}
+	if filter.To != 0 {
+		timestamp["$lte"] = filter.To
+	}
+	query["timestamp"] = timestamp
+
+	sort := bson.D{{Key: "timestamp", Value: -1}}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, sort, filter.Limit))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	opts := options.Find()
+	opts.SetSort(sort)
+	opts.SetLimit(filter.Limit)
+	cursor, err := mongoCollection.Find(ctx, query, opts)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding audit entries in MongoDB"))
+		return entries, err
+	}
+
+	if err = cursor.All(ctx, &entries); err != nil {
+		ext.LogError(span, err, log.Message("Error decoding audit entries of a cursor from MongoDB"))
+	}
+	return entries, err
+}
+
+// MongoModerationStore is the ModerationStore backed by two MongoDB
+// collections, the history and the claims. The claims collection needs a
+// unique index on game_id and message_id for two moderators not to claim
+// a message
+type MongoModerationStore struct {
+	Collection       string
+	ClaimsCollection string
+}
+
+// NewMongoModerationStore returns a new MongoModerationStore using the collections
+func NewMongoModerationStore(collection, claimsCollection string) *MongoModerationStore {
+	return &MongoModerationStore{Collection: collection, ClaimsCollection: claimsCollection}
+}
+
+// InsertModerationEvents inserts the events in the MongoDB collection
+func (s *MongoModerationStore) InsertModerationEvents(ctx context.Context, events []models.ModerationEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(events))
+	for i, event := range events {
+		documents[i] = event
+	}
+
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		return err
+	}
+	_, err = mongoCollection.InsertMany(ctx, documents)
+	return err
+}
+
+// FindModerationEvents returns the events of the message stored in the MongoDB collection
+func (s *MongoModerationStore) FindModerationEvents(ctx context.Context, gameID, messageID string, limit int64) ([]models.ModerationEvent, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"find_moderation_events",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.Collection,
+		},
+	)
+	defer span.Finish()
+
+	events := make([]models.ModerationEvent, 0)
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.Collection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return events, err
+	}
+
+	query := bson.M{"message_id": messageID}
+	if gameID != "" {
+		query["game_id"] = gameID
+	}
+	sort := bson.D{{Key: "timestamp", Value: -1}}
+	span.SetTag(string(ext.DBStatement), mongoclient.ExtractStatementForTrace(query, sort, limit))
+	span.SetTag(string(ext.DBInstance), mongoCollection.Database().Name())
+
+	opts := options.Find()
+	opts.SetSort(sort)
+	opts.SetLimit(limit)
+	cursor, err := mongoCollection.Find(ctx, query, opts)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding moderation events in MongoDB"))
+		return events, err
+	}
+
+	if err = cursor.All(ctx, &events); err != nil {
+		ext.LogError(span, err, log.Message("Error decoding moderation events of a cursor from MongoDB"))
+	}
+	return events, err
+}
+
+// ClaimMessages upserts the claims of the operator on the messages of the
+// game that are not leased to another operator
+func (s *MongoModerationStore) ClaimMessages(ctx context.Context, gameID, operator, moderator string, ids []string, now, expiresAt int64) ([]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"claim_messages",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.ClaimsCollection,
+		},
+	)
+	defer span.Finish()
+
+	claimed := make([]string, 0, len(ids))
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.ClaimsCollection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return claimed, err
+	}
+
+	update := bson.M{"$set": bson.M{"operator": operator, "moderator": moderator, "expires_at": expiresAt}}
+	opts := options.Update().SetUpsert(true)
+	for _, id := range ids {
+		// the claims of others that are still valid don't match, and the
+		// upsert then fails on the unique index
+		query := bson.M{
+			"game_id":    gameKey(gameID),
+			"message_id": id,
+			"$or": bson.A{
+				bson.M{"operator": operator},
+				bson.M{"expires_at": bson.M{"$lte": now}},
+			},
+		}
+		_, err := mongoCollection.UpdateOne(ctx, query, update, opts)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			ext.LogError(span, err, log.Message("Error claiming messages in MongoDB"))
+			return claimed, err
+		}
+		claimed = append(claimed, id)
+	}
+	return claimed, nil
+}
+
+// ReleaseMessages deletes the claims on the messages, of every game when
+// gameID is empty
+func (s *MongoModerationStore) ReleaseMessages(ctx context.Context, gameID, operator string, ids []string) error {
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.ClaimsCollection)
+	if err != nil {
+		return err
+	}
+
+	query := bson.M{"message_id": bson.M{"$in": ids}}
+	if gameID != "" {
+		query["game_id"] = gameID
+	}
+	if operator != "" {
+		query["operator"] = operator
+	}
+	_, err = mongoCollection.DeleteMany(ctx, query)
+	return err
+}
+
+// FindClaims returns the claims on the messages of the game expiring after now
+func (s *MongoModerationStore) FindClaims(ctx context.Context, gameID string, ids []string, now int64) ([]models.ModerationClaim, error) {
+	span, ctx := opentracing.StartSpanFromContext(
+		ctx,
+		"find_claims",
+		opentracing.Tags{
+			string(ext.DBType): "mongo",
+			"collection":       s.ClaimsCollection,
+		},
+	)
+	defer span.Finish()
+
+	claims := make([]models.ModerationClaim, 0)
+	mongoCollection, err := mongoclient.GetCollection(ctx, s.ClaimsCollection)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error getting collection from MongoDB"))
+		return claims, err
+	}
+
+	query := bson.M{
+		"game_id":    gameKey(gameID),
+		"message_id": bson.M{"$in": ids},
+		"expires_at": bson.M{"$gt": now},
+	}
+	cursor, err := mongoCollection.Find(ctx, query)
+	if err != nil {
+		ext.LogError(span, err, log.Message("Error finding claims in MongoDB"))
+		return claims, err
+	}
+
+	if err = cursor.All(ctx, &claims); err != nil {
+		ext.LogError(span, err, log.Message("Error decoding claims of a cursor from MongoDB"))
+	}
+	return claims, err
+}
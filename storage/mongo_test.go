@@ -0,0 +1,80 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package storage_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/logger"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	"github.com/topfreegames/mqtt-history/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongoStore(t *testing.T) {
+	conn, err := net.DialTimeout("tcp", "localhost:27017", time.Second)
+	if err != nil {
+		t.Skip("no MongoDB listening on localhost:27017, run make run-containers")
+	}
+	conn.Close()
+
+	g := goblin.Goblin(t)
+
+	// special hook for gomega
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	logger.SetupLogger("ERROR")
+	viper.Set("mongo.host", "mongodb://localhost:27017")
+	viper.Set("mongo.database", "mqtt_history_test")
+
+	ctx := context.Background()
+	// every case gets its own collections, dropped once the cases ran
+	collections := []string{}
+	newCollection := func(prefix string) string {
+		collection := prefix + "_" + uuid.NewV4().String()
+		collections = append(collections, collection)
+		return collection
+	}
+
+	g.After(func() {
+		for _, collection := range collections {
+			mongoCollection, err := mongoclient.GetCollection(ctx, collection)
+			Expect(err).To(BeNil())
+			Expect(mongoCollection.Drop(ctx)).To(BeNil())
+		}
+	})
+
+	g.Describe("MongoMessageStore", func() {
+		describeMessageStore(g, storage.NewMongoMessageStore(), func() string {
+			return newCollection("messages")
+		})
+	})
+
+	g.Describe("MongoModerationStore", func() {
+		describeModerationStore(g, func() storage.ModerationStore {
+			claimsCollection := newCollection("moderation_claims")
+			mongoCollection, err := mongoclient.GetCollection(ctx, claimsCollection)
+			Expect(err).To(BeNil())
+			// the claims of others are rejected by the unique index
+			_, err = mongoCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "game_id", Value: 1}, {Key: "message_id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			})
+			Expect(err).To(BeNil())
+			return storage.NewMongoModerationStore(newCollection("moderation"), claimsCollection)
+		})
+	})
+}
@@ -0,0 +1,271 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+)
+
+// MessageStore is implemented by the backends able to serve the
+// message history queries
+type MessageStore interface {
+	// GetMessagesV2 returns the messages of queryParameters.Topic sent
+	// until queryParameters.From, newest first, or sent after
+	// queryParameters.Since, oldest first, for forward queries. When
+	// queryParameters.Topics is set their messages are merged in a single
+	// timeline. It is used by both the history and the histories endpoints
+	GetMessagesV2(ctx context.Context, queryParameters mongoclient.QueryParameters) []*models.MessageV2
+	// GetMessagesPlayerSupportV2 returns the messages sent between
+	// queryParameters.From and queryParameters.To, optionally filtered
+	// by topic and player
+	GetMessagesPlayerSupportV2(ctx context.Context, queryParameters mongoclient.QueryParameters) []*models.MessageV2
+	// CountMessagesV2 returns how many messages of queryParameters.Topic
+	// were sent after queryParameters.Since
+	CountMessagesV2(ctx context.Context, queryParameters mongoclient.QueryParameters) (int64, error)
+	// GetTopicsV2 returns, sorted, up to queryParameters.Limit topics after
+	// queryParameters.TopicsAfter with messages matching the MQTT topic filter
+	// queryParameters.Topic, sent after queryParameters.Since and, unless
+	// queryParameters.Forward, until queryParameters.From
+	GetTopicsV2(ctx context.Context, queryParameters mongoclient.QueryParameters) ([]string, error)
+	// InsertMessages stores the given messages in the collection, skipping
+	// those whose topic and id are already stored
+	InsertMessages(ctx context.Context, collection string, messages []*models.MessageV2) error
+	// ModerateMessages sets the blocked flag of the messages and marks
+	// them as reviewed, clearing should_moderate. It returns the messages
+	// found, as updated
+	ModerateMessages(ctx context.Context, moderation Moderation) ([]*models.MessageV2, error)
+	// GetModerationQueue returns up to queryParameters.Limit messages
+	// flagged by should_moderate, oldest first, sent after
+	// queryParameters.Cursor if set, and optionally filtered by topic,
+	// player, game and date range
+	GetModerationQueue(ctx context.Context, queryParameters mongoclient.QueryParameters) ([]*models.MessageV2, error)
+	// ErasePlayerMessages deletes the messages of erasure.PlayerID, or
+	// redacts them into tombstones, and returns how many were affected
+	ErasePlayerMessages(ctx context.Context, erasure Erasure) (int64, error)
+	// StreamPlayerMessages calls emit with every message of
+	// queryParameters.PlayerID, of all topics and dates, oldest first,
+	// optionally scoped to queryParameters.GameID. It stops at the first
+	// error returned by emit
+	StreamPlayerMessages(ctx context.Context, queryParameters mongoclient.QueryParameters, emit func(*models.MessageV2) error) error
+}
+
+// Moderation selects the messages to block or unblock
+type Moderation struct {
+	Collection string
+	// GameID, when set, restricts the moderation to the messages of the game
+	GameID  string
+	IDs     []string
+	Blocked bool
+}
+
+// Erasure selects the messages of a player to erase
+type Erasure struct {
+	Collection string
+	// GameID, when set, restricts the erasure to the messages of the game
+	GameID   string
+	PlayerID string
+	// Redact keeps tombstones of the messages, without their message,
+	// original_payload and metadata, instead of deleting them
+	Redact bool
+}
+
+// ACLStore is implemented by the backends holding the users' ACLs. The
+// ACLs of each game are kept apart, those without a game being the ones
+// of the empty gameID
+type ACLStore interface {
+	// FindACLs returns all the ACLs of username in the game. Their topic
+	// filters are matched against the requested topics by the caller
+	FindACLs(ctx context.Context, gameID, username string) ([]models.ACL, error)
+	// InsertACLs stores the given ACLs
+	InsertACLs(ctx context.Context, acls []models.ACL) error
+	// GrantTopics adds the topic filters missing from the ACLs of
+	// username in the game, creating an ACL if the user has none
+	GrantTopics(ctx context.Context, gameID, username string, topics []string) error
+	// RevokeTopics removes the topic filters from every ACL of username
+	// in the game, deleting the ACLs left empty
+	RevokeTopics(ctx context.Context, gameID, username string, topics []string) error
+}
+
+// ReadMarkerStore is implemented by the backends holding the players'
+// read markers, at most one per game, player and topic
+type ReadMarkerStore interface {
+	// SetReadMarker stores the marker, replacing the previous marker
+	// of the player on the topic in its game
+	SetReadMarker(ctx context.Context, marker models.ReadMarker) error
+	// GetReadMarkers returns the markers of the player on the given
+	// topics of the game. Topics the player never read have no marker
+	GetReadMarkers(ctx context.Context, gameID, playerID string, topics []string) ([]models.ReadMarker, error)
+}
+
+// MuteStore is implemented by the backends holding the players' mute
+// lists, one per game and player
+type MuteStore interface {
+	// MutePlayer adds the muted player to the mute list of the player in
+	// its game, replacing the previous entry if any
+	MutePlayer(ctx context.Context, mute models.MutedPlayer) error
+	// UnmutePlayer removes the muted player from the mute list of the
+	// player in the game
+	UnmutePlayer(ctx context.Context, gameID, playerID, mutedPlayerID string) error
+	// GetMutedPlayers returns the mute list of the player in the game,
+	// sorted by muted player
+	GetMutedPlayers(ctx context.Context, gameID, playerID string) ([]models.MutedPlayer, error)
+}
+
+// OperatorStore is implemented by the backends holding the player support operators
+type OperatorStore interface {
+	// FindOperator returns the operator whose API key has the given
+	// SHA-256, or nil if there is none
+	FindOperator(ctx context.Context, keySha256 string) (*models.Operator, error)
+}
+
+// AuditFilter selects the audit entries, the empty fields matching every entry
+type AuditFilter struct {
+	Operator string
+	PlayerID string
+	Topic    string
+	// From and To bound the time of the entries, To being ignored when zero
+	From  int64
+	To    int64
+	Limit int64
+}
+
+// AuditStore is implemented by the backends holding the audit log
+type AuditStore interface {
+	// InsertAuditEntry appends the entry to the audit log
+	InsertAuditEntry(ctx context.Context, entry models.AuditEntry) error
+	// FindAuditEntries returns up to filter.Limit entries, newest first
+	FindAuditEntries(ctx context.Context, filter AuditFilter) ([]models.AuditEntry, error)
+}
+
+// ModerationStore is implemented by the backends holding the moderation
+// history and the claims of the moderation queue
+type ModerationStore interface {
+	// InsertModerationEvents appends the events to the moderation history
+	InsertModerationEvents(ctx context.Context, events []models.ModerationEvent) error
+	// FindModerationEvents returns up to limit events of the message,
+	// newest first, of the game unless gameID is empty
+	FindModerationEvents(ctx context.Context, gameID, messageID string, limit int64) ([]models.ModerationEvent, error)
+	// ClaimMessages leases the messages of the game to the operator until
+	// expiresAt, noting the moderator, except those leased to another
+	// operator until after now. It returns the ids of the messages claimed
+	ClaimMessages(ctx context.Context, gameID, operator, moderator string, ids []string, now, expiresAt int64) ([]string, error)
+	// ReleaseMessages drops the leases of the operator on the messages, of
+	// the game unless gameID is empty, whoever holds them when operator is empty
+	ReleaseMessages(ctx context.Context, gameID, operator string, ids []string) error
+	// FindClaims returns the leases on the messages of the game expiring after now
+	FindClaims(ctx context.Context, gameID string, ids []string, now int64) ([]models.ModerationClaim, error)
+}
+
+// NewMessageStore returns the MessageStore selected by the storage.type config
+func NewMessageStore(config *viper.Viper) (MessageStore, error) {
+	storageType := config.GetString("storage.type")
+	switch storageType {
+	case "", "mongo":
+		return NewMongoMessageStore(), nil
+	case "memory":
+		return SharedMemoryMessageStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", storageType)
+	}
+}
+
+// NewACLStore returns the ACLStore selected by the storage.type config
+func NewACLStore(config *viper.Viper) (ACLStore, error) {
+	storageType := config.GetString("storage.type")
+	switch storageType {
+	case "", "mongo":
+		return NewMongoACLStore(), nil
+	case "memory":
+		return SharedMemoryACLStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", storageType)
+	}
+}
+
+// NewReadMarkerStore returns the ReadMarkerStore selected by the storage.type config
+func NewReadMarkerStore(config *viper.Viper) (ReadMarkerStore, error) {
+	storageType := config.GetString("storage.type")
+	switch storageType {
+	case "", "mongo":
+		return NewMongoReadMarkerStore(config.GetString("mongo.readMarkers.collection")), nil
+	case "memory":
+		return SharedMemoryReadMarkerStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", storageType)
+	}
+}
+
+// NewMuteStore returns the MuteStore selected by the storage.type config
+func NewMuteStore(config *viper.Viper) (MuteStore, error) {
+	storageType := config.GetString("storage.type")
+	switch storageType {
+	case "", "mongo":
+		return NewMongoMuteStore(config.GetString("mongo.mutes.collection")), nil
+	case "memory":
+		return SharedMemoryMuteStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", storageType)
+	}
+}
+
+// NewAuditStore returns the AuditStore selected by the storage.type config
+func NewAuditStore(config *viper.Viper) (AuditStore, error) {
+	storageType := config.GetString("storage.type")
+	switch storageType {
+	case "", "mongo":
+		return NewMongoAuditStore(config.GetString("mongo.audit.collection")), nil
+	case "memory":
+		return SharedMemoryAuditStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", storageType)
+	}
+}
+
+// NewModerationStore returns the ModerationStore selected by the storage.type config
+func NewModerationStore(config *viper.Viper) (ModerationStore, error) {
+	storageType := config.GetString("storage.type")
+	switch storageType {
+	case "", "mongo":
+		return NewMongoModerationStore(
+			config.GetString("mongo.moderation.collection"),
+			config.GetString("mongo.moderation.claimsCollection"),
+		), nil
+	case "memory":
+		return SharedMemoryModerationStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", storageType)
+	}
+}
+
+// NewOperatorStore returns the OperatorStore selected by the operatorAuth.source config:
+// the operators listed in operatorAuth.operators, or those of the storage.type backend
+func NewOperatorStore(config *viper.Viper) (OperatorStore, error) {
+	source := config.GetString("operatorAuth.source")
+	switch source {
+	case "", "config":
+		return NewConfigOperatorStore(config)
+	case "storage":
+	default:
+		return nil, fmt.Errorf("unknown operator source: %s", source)
+	}
+
+	storageType := config.GetString("storage.type")
+	switch storageType {
+	case "", "mongo":
+		return NewMongoOperatorStore(config.GetString("mongo.operators.collection")), nil
+	case "memory":
+		return SharedMemoryOperatorStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", storageType)
+	}
+}
@@ -0,0 +1,328 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package storage_test
+
+import (
+	"context"
+	"sort"
+
+	goblin "github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/mqtt-history/models"
+	"github.com/topfreegames/mqtt-history/mongoclient"
+	"github.com/topfreegames/mqtt-history/storage"
+)
+
+// rawMessageStore is a MessageStore able to store the messages as written
+// by the former writers of the collection, whose player_id may be a number
+type rawMessageStore interface {
+	storage.MessageStore
+	InsertRawMessages(ctx context.Context, collection string, rawMessages []mongoclient.MongoMessage) error
+}
+
+// describeMessageStore runs the cases every MessageStore must pass, each
+// case on the collection returned by newCollection
+func describeMessageStore(g *goblin.G, store rawMessageStore, newCollection func() string) {
+	ctx := context.Background()
+	var collection string
+
+	g.BeforeEach(func() {
+		collection = newCollection()
+		err := store.InsertRawMessages(ctx, collection, []mongoclient.MongoMessage{
+			{Id: "1", Topic: "chat/a", Timestamp: 10, PlayerId: "p1", GameId: "game1", Message: "hi", ShouldModerate: true},
+			{Id: "2", Topic: "chat/a", Timestamp: 20, PlayerId: int32(42), GameId: "game1", Message: "hi", ShouldModerate: true},
+			{Id: "3", Topic: "chat/b", Timestamp: 30, PlayerId: int64(42), GameId: "game2", Message: "hi", ShouldModerate: true},
+			{Id: "4", Topic: "chat/b/c", Timestamp: 40, PlayerId: float64(43), GameId: "game1", Message: "hi"},
+			{Id: "5", Topic: "other", Timestamp: 50, PlayerId: "p1", GameId: "game1", Message: "hi"},
+		})
+		Expect(err).To(BeNil())
+	})
+
+	g.It("should not store again the messages already stored", func() {
+		err := store.InsertMessages(ctx, collection, []*models.MessageV2{
+			{Id: "1", Topic: "chat/a", Timestamp: 10, PlayerId: "p1", Message: "again"},
+			{Id: "6", Topic: "chat/a", Timestamp: 60, PlayerId: "p2", Message: "new"},
+		})
+		Expect(err).To(BeNil())
+
+		messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			Topic:      "chat/a",
+			From:       100,
+			Limit:      10,
+		})
+		g.Assert(len(messages)).Equal(3)
+		g.Assert(messages[0].Message).Equal("new")
+		g.Assert(messages[2].Message).Equal("hi")
+	})
+
+	g.It("should leave the excluded players out whether their player_id is a string or a number", func() {
+		messages := store.GetMessagesV2(ctx, mongoclient.QueryParameters{
+			Collection:        collection,
+			Topics:            []string{"chat/a", "chat/b", "chat/b/c"},
+			From:              100,
+			Limit:             10,
+			ExcludedPlayerIDs: []string{"42"},
+		})
+		g.Assert(len(messages)).Equal(2)
+		g.Assert(messages[0].Id).Equal("4")
+		g.Assert(messages[1].Id).Equal("1")
+
+		count, err := store.CountMessagesV2(ctx, mongoclient.QueryParameters{
+			Collection:        collection,
+			Topic:             "chat/b/c",
+			ExcludedPlayerIDs: []string{"43"},
+		})
+		Expect(err).To(BeNil())
+		g.Assert(count).Equal(int64(0))
+	})
+
+	g.It("should return the player support messages of the player, whether its player_id is a string or a number", func() {
+		messages := store.GetMessagesPlayerSupportV2(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			PlayerID:   "42",
+			From:       0,
+			To:         100,
+			Limit:      10,
+		})
+		g.Assert(len(messages)).Equal(2)
+		g.Assert(messages[0].Id).Equal("2")
+		g.Assert(messages[1].Id).Equal("3")
+
+		messages = store.GetMessagesPlayerSupportV2(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			PlayerID:   "43",
+			Topic:      "chat/b/c",
+			From:       0,
+			To:         100,
+			Limit:      10,
+		})
+		g.Assert(len(messages)).Equal(1)
+		g.Assert(messages[0].Id).Equal("4")
+
+		messages = store.GetMessagesPlayerSupportV2(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			PlayerID:   "p1",
+			From:       0,
+			To:         45,
+			Limit:      10,
+		})
+		g.Assert(len(messages)).Equal(1)
+		g.Assert(messages[0].Id).Equal("1")
+	})
+
+	g.It("should return the topics matching a topic filter in the range, after the given one", func() {
+		topics, err := store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			Topic:      "chat/#",
+			From:       100,
+			Limit:      10,
+		})
+		Expect(err).To(BeNil())
+		g.Assert(topics).Equal([]string{"chat/a", "chat/b", "chat/b/c"})
+
+		topics, err = store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			Topic:      "chat/+",
+			From:       35,
+			Since:      15,
+			Limit:      10,
+		})
+		Expect(err).To(BeNil())
+		g.Assert(topics).Equal([]string{"chat/a", "chat/b"})
+
+		topics, err = store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			Topic:      "chat/#",
+			Since:      30,
+			Forward:    true,
+			Limit:      10,
+		})
+		Expect(err).To(BeNil())
+		g.Assert(topics).Equal([]string{"chat/b/c"})
+
+		topics, err = store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+			Collection:  collection,
+			Topic:       "chat/#",
+			From:        100,
+			Limit:       1,
+			TopicsAfter: "chat/a",
+		})
+		Expect(err).To(BeNil())
+		g.Assert(topics).Equal([]string{"chat/b"})
+
+		topics, err = store.GetTopicsV2(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			Topic:      "chat/#",
+			From:       100,
+			Limit:      10,
+			GameID:     "game2",
+		})
+		Expect(err).To(BeNil())
+		g.Assert(topics).Equal([]string{"chat/b"})
+	})
+
+	g.It("should return the messages to moderate of the player, whether its player_id is a string or a number", func() {
+		messages, err := store.GetModerationQueue(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			Limit:      10,
+		})
+		Expect(err).To(BeNil())
+		g.Assert(len(messages)).Equal(3)
+		g.Assert(messages[0].Id).Equal("1")
+
+		messages, err = store.GetModerationQueue(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			PlayerID:   "42",
+			Limit:      1,
+		})
+		Expect(err).To(BeNil())
+		g.Assert(len(messages)).Equal(1)
+		g.Assert(messages[0].Id).Equal("2")
+		g.Assert(messages[0].PlayerId).Equal("42")
+
+		cursor := models.CursorOf(messages[0])
+		messages, err = store.GetModerationQueue(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			PlayerID:   "42",
+			Cursor:     &cursor,
+			Limit:      10,
+		})
+		Expect(err).To(BeNil())
+		g.Assert(len(messages)).Equal(1)
+		g.Assert(messages[0].Id).Equal("3")
+
+		moderated, err := store.ModerateMessages(ctx, storage.Moderation{
+			Collection: collection,
+			GameID:     "game1",
+			IDs:        []string{"1", "2", "3"},
+			Blocked:    true,
+		})
+		Expect(err).To(BeNil())
+		g.Assert(len(moderated)).Equal(2)
+
+		messages, err = store.GetModerationQueue(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			Limit:      10,
+		})
+		Expect(err).To(BeNil())
+		g.Assert(len(messages)).Equal(1)
+		g.Assert(messages[0].Id).Equal("3")
+	})
+
+	g.It("should erase the messages of the player, whether its player_id is a string or a number", func() {
+		erasure := storage.Erasure{Collection: collection, GameID: "game1", PlayerID: "42", Redact: true}
+		affected, err := store.ErasePlayerMessages(ctx, erasure)
+		Expect(err).To(BeNil())
+		g.Assert(affected).Equal(int64(1))
+
+		erasure.GameID = ""
+		affected, err = store.ErasePlayerMessages(ctx, erasure)
+		Expect(err).To(BeNil())
+		g.Assert(affected).Equal(int64(1))
+		affected, err = store.ErasePlayerMessages(ctx, erasure)
+		Expect(err).To(BeNil())
+		g.Assert(affected).Equal(int64(0))
+
+		streamed := []*models.MessageV2{}
+		err = store.StreamPlayerMessages(ctx, mongoclient.QueryParameters{Collection: collection, PlayerID: "42"}, func(message *models.MessageV2) error {
+			streamed = append(streamed, message)
+			return nil
+		})
+		Expect(err).To(BeNil())
+		g.Assert(len(streamed)).Equal(2)
+		g.Assert(streamed[0].Id).Equal("2")
+		g.Assert(streamed[0].Message).Equal("")
+		g.Assert(streamed[1].Redacted).IsTrue()
+
+		affected, err = store.ErasePlayerMessages(ctx, storage.Erasure{Collection: collection, PlayerID: "43"})
+		Expect(err).To(BeNil())
+		g.Assert(affected).Equal(int64(1))
+
+		count, err := store.CountMessagesV2(ctx, mongoclient.QueryParameters{
+			Collection: collection,
+			Topic:      "chat/b/c",
+		})
+		Expect(err).To(BeNil())
+		g.Assert(count).Equal(int64(0))
+	})
+}
+
+// describeModerationStore runs the cases every ModerationStore must pass,
+// each case on the store returned by newStore
+func describeModerationStore(g *goblin.G, newStore func() storage.ModerationStore) {
+	ctx := context.Background()
+
+	g.It("should lease the messages until the claims expire or are released", func() {
+		store := newStore()
+		claimed, err := store.ClaimMessages(ctx, "game1", "alice", "", []string{"1", "2"}, 100, 200)
+		Expect(err).To(BeNil())
+		g.Assert(claimed).Equal([]string{"1", "2"})
+
+		claimed, err = store.ClaimMessages(ctx, "game1", "bob", "", []string{"2", "3"}, 150, 250)
+		Expect(err).To(BeNil())
+		g.Assert(claimed).Equal([]string{"3"})
+
+		claimed, err = store.ClaimMessages(ctx, "game1", "bob", "carol", []string{"1"}, 200, 300)
+		Expect(err).To(BeNil())
+		g.Assert(claimed).Equal([]string{"1"})
+
+		err = store.ReleaseMessages(ctx, "game1", "bob", []string{"2", "3"})
+		Expect(err).To(BeNil())
+		claims, err := store.FindClaims(ctx, "game1", []string{"1", "2", "3"}, 150)
+		Expect(err).To(BeNil())
+		sort.Slice(claims, func(i, j int) bool {
+			return claims[i].MessageId < claims[j].MessageId
+		})
+		g.Assert(claims).Equal([]models.ModerationClaim{
+			{GameId: "game1", MessageId: "1", Operator: "bob", Moderator: "carol", ExpiresAt: 300},
+			{GameId: "game1", MessageId: "2", Operator: "alice", ExpiresAt: 200},
+		})
+
+		err = store.ReleaseMessages(ctx, "game1", "", []string{"1", "2"})
+		Expect(err).To(BeNil())
+		claims, err = store.FindClaims(ctx, "game1", []string{"1", "2", "3"}, 150)
+		Expect(err).To(BeNil())
+		g.Assert(len(claims)).Equal(0)
+	})
+
+	g.It("should lease apart the messages of two games sharing an id", func() {
+		store := newStore()
+		claimed, err := store.ClaimMessages(ctx, "game1", "alice", "", []string{"1"}, 100, 200)
+		Expect(err).To(BeNil())
+		g.Assert(claimed).Equal([]string{"1"})
+
+		claimed, err = store.ClaimMessages(ctx, "game2", "bob", "", []string{"1"}, 100, 200)
+		Expect(err).To(BeNil())
+		g.Assert(claimed).Equal([]string{"1"})
+
+		claimed, err = store.ClaimMessages(ctx, "", "carol", "", []string{"1"}, 100, 200)
+		Expect(err).To(BeNil())
+		g.Assert(claimed).Equal([]string{"1"})
+
+		err = store.ReleaseMessages(ctx, "game2", "", []string{"1"})
+		Expect(err).To(BeNil())
+		claims, err := store.FindClaims(ctx, "game1", []string{"1"}, 150)
+		Expect(err).To(BeNil())
+		g.Assert(claims).Equal([]models.ModerationClaim{
+			{GameId: "game1", MessageId: "1", Operator: "alice", ExpiresAt: 200},
+		})
+		claims, err = store.FindClaims(ctx, "game2", []string{"1"}, 150)
+		Expect(err).To(BeNil())
+		g.Assert(len(claims)).Equal(0)
+
+		err = store.ReleaseMessages(ctx, "", "alice", []string{"1"})
+		Expect(err).To(BeNil())
+		claims, err = store.FindClaims(ctx, "game1", []string{"1"}, 150)
+		Expect(err).To(BeNil())
+		g.Assert(len(claims)).Equal(0)
+		claims, err = store.FindClaims(ctx, "", []string{"1"}, 150)
+		Expect(err).To(BeNil())
+		g.Assert(len(claims)).Equal(1)
+		g.Assert(claims[0].Operator).Equal("carol")
+	})
+}
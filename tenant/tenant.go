@@ -0,0 +1,79 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+//
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+// Package tenant carries the game of a request, its tenant, and resolves
+// the per game overrides of the config, read from tenant.games.<gameID>
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the game ID
+func NewContext(ctx context.Context, gameID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, gameID)
+}
+
+// GameID returns the game ID carried by ctx, empty if none
+func GameID(ctx context.Context) string {
+	gameID, _ := ctx.Value(contextKey{}).(string)
+	return gameID
+}
+
+// Collection returns the messages collection of the game
+func Collection(config *viper.Viper, gameID string) string {
+	return config.GetString(key(config, gameID, "collection", "mongo.messages.collection"))
+}
+
+// Limit returns the default number of messages returned to the game
+func Limit(config *viper.Viper, gameID string) int64 {
+	return config.GetInt64(key(config, gameID, "limit", "mongo.messages.limit"))
+}
+
+// ForwardLimit returns the maximum number of messages returned to the game
+// by the forward queries
+func ForwardLimit(config *viper.Viper, gameID string) int64 {
+	return config.GetInt64(key(config, gameID, "forwardLimit", "mongo.messages.forwardLimit"))
+}
+
+// AllowAnonymous tells whether the game skips the authorization checks
+func AllowAnonymous(config *viper.Viper, gameID string) bool {
+	return config.GetBool(key(config, gameID, "allowAnonymous", "mongo.allow_anonymous"))
+}
+
+// Collections returns the messages collections of every game, sorted
+func Collections(config *viper.Viper) []string {
+	unique := map[string]bool{config.GetString("mongo.messages.collection"): true}
+	for gameID := range config.GetStringMap("tenant.games") {
+		unique[Collection(config, gameID)] = true
+	}
+
+	collections := make([]string, 0, len(unique))
+	for collection := range unique {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+// key returns the key of the game's override of a setting if it is set,
+// or else the key of the global setting
+func key(config *viper.Viper, gameID, setting, global string) string {
+	if gameID != "" {
+		override := fmt.Sprintf("tenant.games.%s.%s", gameID, setting)
+		if config.IsSet(override) {
+			return override
+		}
+	}
+	return global
+}
@@ -0,0 +1,57 @@
+// mqtt-history
+// https://github.com/topfreegames/mqtt-history
+// Licensed under the MIT license:
+// http://www.opensource.org/licenses/mit-license
+// Copyright © 2016 Top Free Games <backend@tfgco.com>
+
+package tenant_test
+
+import (
+	"context"
+	"testing"
+
+	goblin "github.com/franela/goblin"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/mqtt-history/tenant"
+)
+
+func TestTenant(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Tenant", func() {
+		g.It("should carry the game id in the context", func() {
+			ctx := tenant.NewContext(context.Background(), "game1")
+			g.Assert(tenant.GameID(ctx)).Equal("game1")
+			g.Assert(tenant.GameID(context.Background())).Equal("")
+		})
+
+		g.It("should read the overrides of the game, or else the global settings", func() {
+			config := viper.New()
+			config.Set("mongo.messages.collection", "messages")
+			config.Set("mongo.messages.limit", 10)
+			config.Set("mongo.messages.forwardLimit", 100)
+			config.Set("mongo.allow_anonymous", false)
+			config.Set("tenant.games.game1.collection", "game1_messages")
+			config.Set("tenant.games.game1.limit", 5)
+			config.Set("tenant.games.game1.allowAnonymous", true)
+
+			g.Assert(tenant.Collection(config, "game1")).Equal("game1_messages")
+			g.Assert(tenant.Limit(config, "game1")).Equal(int64(5))
+			g.Assert(tenant.ForwardLimit(config, "game1")).Equal(int64(100))
+			g.Assert(tenant.AllowAnonymous(config, "game1")).IsTrue()
+
+			g.Assert(tenant.Collection(config, "game2")).Equal("messages")
+			g.Assert(tenant.Limit(config, "")).Equal(int64(10))
+			g.Assert(tenant.AllowAnonymous(config, "game2")).IsFalse()
+		})
+
+		g.It("should list the messages collections of every game", func() {
+			config := viper.New()
+			config.Set("mongo.messages.collection", "messages")
+			config.Set("tenant.games.game1.collection", "game1_messages")
+			config.Set("tenant.games.game2.limit", 5)
+
+			g.Assert(tenant.Collections(config)).Equal([]string{"game1_messages", "messages"})
+		})
+	})
+}
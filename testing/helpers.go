@@ -14,25 +14,36 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/labstack/echo/engine/standard"
 	"github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/viper"
 	"github.com/topfreegames/mqtt-history/app"
 	"github.com/topfreegames/mqtt-history/models"
-	"github.com/topfreegames/mqtt-history/mongoclient"
+	"github.com/topfreegames/mqtt-history/storage"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const cfgFile = "../config/test.yaml"
 
+// The API keys of the operators of config/test.yaml
+const (
+	SupportReadAPIKey     = "support-read-key"
+	SupportBlockedAPIKey  = "support-blocked-key"
+	SupportAuditAPIKey    = "support-audit-key"
+	ACLAdminAPIKey        = "acl-admin-key"
+	ModeratorAPIKey       = "moderator-key"
+	SecondModeratorAPIKey = "second-moderator-key"
+	GDPRAPIKey            = "gdpr-key"
+)
+
 // GetDefaultTestApp retrieve a default app for testing purposes
 func GetDefaultTestApp() *app.App {
+	viper.SetDefault("logger.level", "DEBUG")
 	viper.SetConfigFile(cfgFile)
 	app := app.GetApp("0.0.0.0", 8888, true, cfgFile)
 
@@ -41,10 +52,59 @@ func GetDefaultTestApp() *app.App {
 
 // Get implements the GET http verb for testing purposes
 func Get(app *app.App, url string, t *testing.T) (int, string) {
-	return doRequest(app, "GET", url, "")
+	status, body, _ := doRequest(app, "GET", url, "", nil)
+	return status, body
+}
+
+// GetWithHeaders implements the GET http verb sending the given headers
+// and also returns the response headers
+func GetWithHeaders(app *app.App, url string, headers map[string]string, t *testing.T) (int, string, http.Header) {
+	return doRequest(app, "GET", url, "", headers)
+}
+
+// PutJSON implements the PUT http verb sending body as JSON
+func PutJSON(app *app.App, url, body string, t *testing.T) (int, string) {
+	status, responseBody, _ := doRequest(app, "PUT", url, body, map[string]string{"Content-Type": "application/json"})
+	return status, responseBody
+}
+
+// PutJSONWithHeaders implements the PUT http verb sending body as JSON
+// along with the given headers
+func PutJSONWithHeaders(app *app.App, url, body string, headers map[string]string, t *testing.T) (int, string) {
+	headers["Content-Type"] = "application/json"
+	status, responseBody, _ := doRequest(app, "PUT", url, body, headers)
+	return status, responseBody
+}
+
+// Delete implements the DELETE http verb for testing purposes
+func Delete(app *app.App, url string, t *testing.T) (int, string) {
+	status, body, _ := doRequest(app, "DELETE", url, "", nil)
+	return status, body
+}
+
+// GetAsOperator implements the GET http verb authenticated by the operator's API key
+func GetAsOperator(app *app.App, url, apiKey string, t *testing.T) (int, string) {
+	status, body, _ := doRequest(app, "GET", url, "", map[string]string{"X-API-Key": apiKey})
+	return status, body
 }
 
-func doRequest(app *app.App, method, url, body string) (int, string) {
+// PostJSONAsOperator implements the POST http verb sending a JSON body,
+// authenticated by the operator's API key
+func PostJSONAsOperator(app *app.App, url, body, apiKey string, t *testing.T) (int, string) {
+	status, responseBody, _ := doRequest(app, "POST", url, body, map[string]string{
+		"Content-Type": "application/json",
+		"X-API-Key":    apiKey,
+	})
+	return status, responseBody
+}
+
+// PostWithHeaders implements the POST http verb sending the given headers
+// and also returns the response headers
+func PostWithHeaders(app *app.App, url, body string, headers map[string]string, t *testing.T) (int, string, http.Header) {
+	return doRequest(app, "POST", url, body, headers)
+}
+
+func doRequest(app *app.App, method, url, body string, headers map[string]string) (int, string, http.Header) {
 	app.Engine.SetHandler(app.API)
 	ts := httptest.NewServer(app.Engine.(*standard.Server))
 	defer ts.Close()
@@ -55,6 +115,9 @@ func doRequest(app *app.App, method, url, body string) (int, string) {
 	}
 	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", ts.URL, url), reader)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 
 	client := &http.Client{}
 	res, err := client.Do(req)
@@ -67,36 +130,35 @@ func doRequest(app *app.App, method, url, body string) (int, string) {
 	b, err := ioutil.ReadAll(res.Body)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-	return res.StatusCode, string(b)
+	return res.StatusCode, string(b), res.Header
 }
 
+// AuthorizeTestUserInTopics grants the test:test user access to the topics
+// in the configured ACL store
 func AuthorizeTestUserInTopics(ctx context.Context, topics []string) error {
-	var acl []interface{}
+	acls := make([]models.ACL, 0, len(topics))
 	for _, topic := range topics {
-		acl = append(acl, app.ACL{Username: "test:test", Pubsub: []string{topic}})
-	}
-
-	insertAuthCallback := func(c *mongo.Collection) error {
-		var _, err = c.InsertMany(ctx, acl)
-		return err
+		acls = append(acls, models.ACL{Username: "test:test", Pubsub: []string{topic}})
 	}
 
-	mongoCollection, err := mongoclient.GetCollection(ctx, "mqtt_acl")
+	aclStore, err := storage.NewACLStore(viper.GetViper())
 	if err != nil {
 		return err
 	}
-	return insertAuthCallback(mongoCollection)
+	return aclStore.InsertACLs(ctx, acls)
 }
 
+// InsertMongoMessages inserts one unblocked message per topic in the configured message store
 func InsertMongoMessages(ctx context.Context, topics []string) error {
 	return InsertMongoMessagesWithParameters(ctx, topics, false)
 }
 
+// InsertMongoMessagesWithParameters inserts one message per topic in the configured message store
 func InsertMongoMessagesWithParameters(ctx context.Context, topics []string, blocked bool) error {
-	var messages []interface{}
+	messages := make([]*models.MessageV2, 0, len(topics))
 	for i, topic := range topics {
-		message := models.MessageV2{
-			Id:             strconv.FormatInt(int64(i), 10),
+		message := &models.MessageV2{
+			Id:             uuid.NewV4().String(),
 			GameId:         "game test",
 			PlayerId:       "test",
 			Blocked:        blocked,
@@ -113,16 +175,14 @@ func InsertMongoMessagesWithParameters(ctx context.Context, topics []string, blo
 		messages = append(messages, message)
 	}
 
-	// and given that the user has 2 messages stored in mongo
-	insertMessagesCallback := func(c *mongo.Collection) error {
-		_, err := c.InsertMany(ctx, messages)
-		return err
-	}
+	return InsertTestMessages(ctx, messages)
+}
 
-	messagesCollection := "messages"
-	mongoCollection, err := mongoclient.GetCollection(ctx, messagesCollection)
+// InsertTestMessages inserts the messages in the configured message store
+func InsertTestMessages(ctx context.Context, messages []*models.MessageV2) error {
+	messageStore, err := storage.NewMessageStore(viper.GetViper())
 	if err != nil {
-		return nil
+		return err
 	}
-	return insertMessagesCallback(mongoCollection)
+	return messageStore.InsertMessages(ctx, "messages", messages)
 }